@@ -18,7 +18,14 @@ func main() {
 	utils.InitFirebase()
 	zerolog.InitLogger()
 
-	app := fiber.New()
+	// Client IPs come from PROXY_HEADER only when the request arrives through
+	// one of TRUSTED_PROXIES, otherwise the connection's address is used
+	app := fiber.New(fiber.Config{
+		ProxyHeader:             viper.GetString("PROXY_HEADER"),
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          utils.ListFromConfig("TRUSTED_PROXIES"),
+		EnableIPValidation:      true,
+	})
 
 	app.Use(cors.New())
 
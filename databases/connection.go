@@ -27,9 +27,13 @@ func init() {
 	}
 }
 
+func PostgresDSN() string {
+	return "postgres://" + viper.GetString("DB_USER") + ":" + viper.GetString("DB_PASSWORD") + "@" + viper.GetString("DB_HOST") + ":" + viper.GetString("DB_PORT") + "/" + viper.GetString("DB_NAME") + "?sslmode=disable"
+}
+
 func PostgresConnection() (*sqlx.DB, error) {
 	once.Do(func() {
-		dbURI := "postgres://" + viper.GetString("DB_USER") + ":" + viper.GetString("DB_PASSWORD") + "@" + viper.GetString("DB_HOST") + ":" + viper.GetString("DB_PORT") + "/" + viper.GetString("DB_NAME") + "?sslmode=disable"
+		dbURI := PostgresDSN()
 
 		conn, err := sqlx.Connect("postgres", dbURI)
 		if err != nil {
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS shuttle_locations (
+    location_id BIGINT PRIMARY KEY,
+    shuttle_uuid UUID NOT NULL,
+    user_uuid UUID NOT NULL,
+    latitude DOUBLE PRECISION NOT NULL,
+    longitude DOUBLE PRECISION NOT NULL,
+    speed DOUBLE PRECISION NULL DEFAULT NULL,
+    heading DOUBLE PRECISION NULL DEFAULT NULL,
+    recorded_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (shuttle_uuid) REFERENCES shuttle (shuttle_uuid) ON UPDATE NO ACTION ON DELETE CASCADE,
+    FOREIGN KEY (user_uuid) REFERENCES users (user_uuid) ON UPDATE NO ACTION ON DELETE CASCADE
+);
+
+CREATE INDEX idx_shuttle_locations_shuttle_recorded ON shuttle_locations(shuttle_uuid, recorded_at);
+CREATE INDEX idx_shuttle_locations_user_recorded ON shuttle_locations(user_uuid, recorded_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS shuttle_locations CASCADE;
+-- +goose StatementEnd
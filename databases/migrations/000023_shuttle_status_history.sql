@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS shuttle_status_history (
+    history_id BIGINT PRIMARY KEY,
+    shuttle_uuid UUID NOT NULL,
+    from_status shuttle_status NULL DEFAULT NULL,
+    to_status shuttle_status NOT NULL,
+    changed_by UUID NULL DEFAULT NULL,
+    latitude DOUBLE PRECISION NULL DEFAULT NULL,
+    longitude DOUBLE PRECISION NULL DEFAULT NULL,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (shuttle_uuid) REFERENCES shuttle (shuttle_uuid) ON UPDATE NO ACTION ON DELETE CASCADE,
+    FOREIGN KEY (changed_by) REFERENCES users (user_uuid) ON UPDATE NO ACTION ON DELETE SET NULL
+);
+
+CREATE INDEX idx_shuttle_status_history_shuttle_changed ON shuttle_status_history(shuttle_uuid, changed_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS shuttle_status_history CASCADE;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE schools
+    ADD COLUMN IF NOT EXISTS school_approach_radius INTEGER NOT NULL DEFAULT 500,
+    ADD COLUMN IF NOT EXISTS school_arrival_radius INTEGER NOT NULL DEFAULT 150;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE schools
+    DROP COLUMN IF EXISTS school_approach_radius,
+    DROP COLUMN IF EXISTS school_arrival_radius;
+-- +goose StatementEnd
@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TYPE trip_direction AS ENUM ('to_school', 'to_home');
+
+CREATE TABLE IF NOT EXISTS route_schedules (
+    schedule_id BIGINT PRIMARY KEY,
+    schedule_uuid UUID UNIQUE NOT NULL,
+    route_name_uuid UUID NOT NULL,
+    school_uuid UUID NOT NULL,
+    direction trip_direction NOT NULL,
+    departure_time TIME NOT NULL,
+    active_days INTEGER[] NOT NULL DEFAULT '{1,2,3,4,5}', -- ISO weekdays, 1 = Monday
+    term_start DATE NULL DEFAULT NULL,
+    term_end DATE NULL DEFAULT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_by VARCHAR(255) NULL DEFAULT NULL,
+    updated_at TIMESTAMPTZ NULL DEFAULT NULL,
+    updated_by VARCHAR(255) NULL DEFAULT NULL,
+    deleted_at TIMESTAMPTZ NULL DEFAULT NULL,
+    deleted_by VARCHAR(255) NULL DEFAULT NULL,
+    FOREIGN KEY (route_name_uuid) REFERENCES routes (route_name_uuid) ON UPDATE NO ACTION ON DELETE CASCADE,
+    FOREIGN KEY (school_uuid) REFERENCES schools (school_uuid) ON UPDATE NO ACTION ON DELETE NO ACTION
+);
+
+CREATE INDEX idx_route_schedules_route ON route_schedules(route_name_uuid);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS route_schedules CASCADE;
+DROP TYPE IF EXISTS trip_direction;
+-- +goose StatementEnd
@@ -0,0 +1,58 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TYPE trip_status AS ENUM ('scheduled', 'in_progress', 'completed', 'cancelled');
+
+CREATE TABLE IF NOT EXISTS trips (
+    trip_id BIGINT PRIMARY KEY,
+    trip_uuid UUID UNIQUE NOT NULL,
+    route_name_uuid UUID NOT NULL,
+    schedule_uuid UUID NULL DEFAULT NULL,
+    school_uuid UUID NOT NULL,
+    driver_uuid UUID NOT NULL,
+    trip_date DATE NOT NULL,
+    direction trip_direction NULL DEFAULT NULL,
+    departure_time TIME NULL DEFAULT NULL,
+    status trip_status NOT NULL DEFAULT 'scheduled',
+    started_at TIMESTAMPTZ NULL DEFAULT NULL,
+    finished_at TIMESTAMPTZ NULL DEFAULT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_by VARCHAR(255) NULL DEFAULT NULL,
+    updated_at TIMESTAMPTZ NULL DEFAULT NULL,
+    updated_by VARCHAR(255) NULL DEFAULT NULL,
+    FOREIGN KEY (route_name_uuid) REFERENCES routes (route_name_uuid) ON UPDATE NO ACTION ON DELETE CASCADE,
+    FOREIGN KEY (schedule_uuid) REFERENCES route_schedules (schedule_uuid) ON UPDATE NO ACTION ON DELETE SET NULL,
+    FOREIGN KEY (school_uuid) REFERENCES schools (school_uuid) ON UPDATE NO ACTION ON DELETE NO ACTION,
+    FOREIGN KEY (driver_uuid) REFERENCES users (user_uuid) ON UPDATE NO ACTION ON DELETE NO ACTION
+);
+
+-- One trip per route, driver, day and schedule; routes without schedules get one trip a day
+CREATE UNIQUE INDEX idx_trips_unique_day ON trips(route_name_uuid, driver_uuid, trip_date, COALESCE(schedule_uuid, '00000000-0000-0000-0000-000000000000'::uuid));
+CREATE INDEX idx_trips_driver_date ON trips(driver_uuid, trip_date);
+CREATE INDEX idx_trips_date ON trips(trip_date);
+
+CREATE TABLE IF NOT EXISTS trip_manifest (
+    manifest_id BIGINT PRIMARY KEY,
+    trip_uuid UUID NOT NULL,
+    student_uuid UUID NOT NULL,
+    stop_order INTEGER NOT NULL,
+    shuttle_uuid UUID NULL DEFAULT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (trip_uuid, student_uuid),
+    FOREIGN KEY (trip_uuid) REFERENCES trips (trip_uuid) ON UPDATE NO ACTION ON DELETE CASCADE,
+    FOREIGN KEY (student_uuid) REFERENCES students (student_uuid) ON UPDATE NO ACTION ON DELETE CASCADE,
+    FOREIGN KEY (shuttle_uuid) REFERENCES shuttle (shuttle_uuid) ON UPDATE NO ACTION ON DELETE SET NULL
+);
+
+ALTER TABLE shuttle ADD COLUMN IF NOT EXISTS trip_uuid UUID NULL DEFAULT NULL;
+ALTER TABLE shuttle ADD CONSTRAINT fk_shuttle_trip_uuid FOREIGN KEY (trip_uuid) REFERENCES trips (trip_uuid) ON UPDATE NO ACTION ON DELETE SET NULL;
+CREATE INDEX idx_shuttle_trip_uuid ON shuttle(trip_uuid);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE shuttle DROP CONSTRAINT IF EXISTS fk_shuttle_trip_uuid;
+ALTER TABLE shuttle DROP COLUMN IF EXISTS trip_uuid;
+DROP TABLE IF EXISTS trip_manifest CASCADE;
+DROP TABLE IF EXISTS trips CASCADE;
+DROP TYPE IF EXISTS trip_status;
+-- +goose StatementEnd
@@ -0,0 +1,43 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS student_absences (
+    absence_id BIGINT PRIMARY KEY,
+    absence_uuid UUID UNIQUE NOT NULL,
+    student_uuid UUID NOT NULL,
+    parent_uuid UUID NOT NULL,
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL,
+    reason VARCHAR(255) NULL DEFAULT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_by VARCHAR(255) NULL DEFAULT NULL,
+    cancelled_at TIMESTAMPTZ NULL DEFAULT NULL,
+    cancelled_by VARCHAR(255) NULL DEFAULT NULL,
+    CHECK (end_date >= start_date),
+    FOREIGN KEY (student_uuid) REFERENCES students (student_uuid) ON UPDATE NO ACTION ON DELETE CASCADE,
+    FOREIGN KEY (parent_uuid) REFERENCES users (user_uuid) ON UPDATE NO ACTION ON DELETE CASCADE
+);
+
+CREATE INDEX idx_student_absences_student_dates ON student_absences(student_uuid, start_date, end_date) WHERE cancelled_at IS NULL;
+
+-- The status that applies on a date: a scheduled absence wins over the stored student_status
+CREATE OR REPLACE FUNCTION student_effective_status(p_student_uuid UUID, p_date DATE)
+RETURNS TEXT AS $$
+    SELECT CASE
+        WHEN EXISTS (
+            SELECT 1 FROM student_absences a
+            WHERE a.student_uuid = p_student_uuid
+            AND a.cancelled_at IS NULL
+            AND p_date BETWEEN a.start_date AND a.end_date
+        ) THEN 'absent'
+        ELSE s.student_status::text
+    END
+    FROM students s
+    WHERE s.student_uuid = p_student_uuid
+$$ LANGUAGE sql STABLE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP FUNCTION IF EXISTS student_effective_status(UUID, DATE);
+DROP TABLE IF EXISTS student_absences CASCADE;
+-- +goose StatementEnd
@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TYPE handover_type AS ENUM ('boarded', 'alighted');
+
+CREATE TABLE IF NOT EXISTS student_handovers (
+    handover_id BIGINT PRIMARY KEY,
+    handover_uuid UUID UNIQUE NOT NULL,
+    shuttle_uuid UUID NOT NULL,
+    trip_uuid UUID NULL DEFAULT NULL,
+    student_uuid UUID NOT NULL,
+    driver_uuid UUID NOT NULL,
+    handover_type handover_type NOT NULL,
+    latitude DOUBLE PRECISION NULL DEFAULT NULL,
+    longitude DOUBLE PRECISION NULL DEFAULT NULL,
+    proof_type VARCHAR(20) NULL DEFAULT NULL,
+    proof_file VARCHAR(255) NULL DEFAULT NULL,
+    notes VARCHAR(255) NULL DEFAULT NULL,
+    confirmed_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK (proof_type IS NULL OR proof_type IN ('photo', 'signature')),
+    FOREIGN KEY (shuttle_uuid) REFERENCES shuttle (shuttle_uuid) ON UPDATE NO ACTION ON DELETE CASCADE,
+    FOREIGN KEY (trip_uuid) REFERENCES trips (trip_uuid) ON UPDATE NO ACTION ON DELETE SET NULL,
+    FOREIGN KEY (student_uuid) REFERENCES students (student_uuid) ON UPDATE NO ACTION ON DELETE CASCADE,
+    FOREIGN KEY (driver_uuid) REFERENCES users (user_uuid) ON UPDATE NO ACTION ON DELETE CASCADE
+);
+
+CREATE INDEX idx_student_handovers_shuttle_confirmed ON student_handovers(shuttle_uuid, confirmed_at);
+CREATE INDEX idx_student_handovers_student_confirmed ON student_handovers(student_uuid, confirmed_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS student_handovers CASCADE;
+DROP TYPE IF EXISTS handover_type;
+-- +goose StatementEnd
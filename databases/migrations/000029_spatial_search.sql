@@ -0,0 +1,37 @@
+-- +goose Up
+-- +goose StatementBegin
+-- PostGIS is optional: enable it where the server ships it, otherwise distances use haversine_meters
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_available_extensions WHERE name = 'postgis') THEN
+        CREATE EXTENSION IF NOT EXISTS postgis;
+    END IF;
+EXCEPTION WHEN insufficient_privilege THEN
+    RAISE NOTICE 'postgis is available but could not be enabled, falling back to haversine distances';
+END
+$$;
+
+-- A coordinate from the {"latitude": .., "longitude": ..} JSON points, NULL when missing or not a number
+CREATE OR REPLACE FUNCTION json_point_coordinate(p_point JSON, p_key TEXT)
+RETURNS DOUBLE PRECISION AS $$
+    SELECT CASE
+        WHEN TRIM(p_point->>p_key) ~ '^-?[0-9]+(\.[0-9]+)?$' THEN TRIM(p_point->>p_key)::DOUBLE PRECISION
+        ELSE NULL
+    END
+$$ LANGUAGE sql IMMUTABLE;
+
+-- Great-circle distance in meters, same formula as utils.HaversineDistance
+CREATE OR REPLACE FUNCTION haversine_meters(lat1 DOUBLE PRECISION, lng1 DOUBLE PRECISION, lat2 DOUBLE PRECISION, lng2 DOUBLE PRECISION)
+RETURNS DOUBLE PRECISION AS $$
+    SELECT 2 * 6371000 * ASIN(LEAST(1, SQRT(
+        POWER(SIN(RADIANS(lat2 - lat1) / 2), 2) +
+        COS(RADIANS(lat1)) * COS(RADIANS(lat2)) * POWER(SIN(RADIANS(lng2 - lng1) / 2), 2)
+    )))
+$$ LANGUAGE sql IMMUTABLE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP FUNCTION IF EXISTS haversine_meters(DOUBLE PRECISION, DOUBLE PRECISION, DOUBLE PRECISION, DOUBLE PRECISION);
+DROP FUNCTION IF EXISTS json_point_coordinate(JSON, TEXT);
+-- +goose StatementEnd
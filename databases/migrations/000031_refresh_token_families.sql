@@ -0,0 +1,40 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Tokens issued before rotation carry no jti or family and can't be rotated, those sessions log in again
+DELETE FROM refresh_tokens;
+
+ALTER TABLE refresh_tokens DROP CONSTRAINT IF EXISTS refresh_tokens_user_uuid_key;
+ALTER TABLE refresh_tokens DROP COLUMN refresh_token;
+
+ALTER TABLE refresh_tokens
+    ADD COLUMN jti UUID UNIQUE NOT NULL,
+    ADD COLUMN family_id UUID NOT NULL,
+    ADD COLUMN absolute_expired_at TIMESTAMPTZ NOT NULL,
+    ADD COLUMN rotated_at TIMESTAMPTZ NULL DEFAULT NULL,
+    ADD COLUMN replaced_by UUID NULL DEFAULT NULL,
+    ADD COLUMN revoked_at TIMESTAMPTZ NULL DEFAULT NULL,
+    ADD COLUMN revoked_reason VARCHAR(30) NULL DEFAULT NULL;
+
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+CREATE INDEX idx_refresh_tokens_user_uuid ON refresh_tokens(user_uuid);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM refresh_tokens;
+
+DROP INDEX IF EXISTS idx_refresh_tokens_user_uuid;
+DROP INDEX IF EXISTS idx_refresh_tokens_family_id;
+
+ALTER TABLE refresh_tokens
+    DROP COLUMN revoked_reason,
+    DROP COLUMN revoked_at,
+    DROP COLUMN replaced_by,
+    DROP COLUMN rotated_at,
+    DROP COLUMN absolute_expired_at,
+    DROP COLUMN family_id,
+    DROP COLUMN jti;
+
+ALTER TABLE refresh_tokens ADD COLUMN refresh_token TEXT NOT NULL;
+ALTER TABLE refresh_tokens ADD CONSTRAINT refresh_tokens_user_uuid_key UNIQUE (user_uuid);
+-- +goose StatementEnd
@@ -0,0 +1,75 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS user_sessions (
+    session_id BIGINT PRIMARY KEY,
+    session_uuid UUID UNIQUE NOT NULL,
+    user_uuid UUID NOT NULL,
+    device_name VARCHAR(100) NULL DEFAULT NULL,
+    platform VARCHAR(20) NULL DEFAULT NULL,
+    ip_address VARCHAR(45) NULL DEFAULT NULL,
+    user_agent TEXT NULL DEFAULT NULL,
+    fcm_token TEXT NULL DEFAULT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ NULL DEFAULT NULL,
+    revoked_reason VARCHAR(30) NULL DEFAULT NULL,
+    FOREIGN KEY (user_uuid) REFERENCES users (user_uuid) ON UPDATE NO ACTION ON DELETE CASCADE
+);
+
+CREATE INDEX idx_user_sessions_user_uuid ON user_sessions(user_uuid);
+CREATE INDEX idx_user_sessions_fcm_token ON user_sessions(fcm_token) WHERE fcm_token IS NOT NULL;
+
+-- Every refresh token family that is still alive becomes a session
+INSERT INTO user_sessions (session_id, session_uuid, user_uuid, created_at, last_used_at, expires_at, revoked_at, revoked_reason)
+SELECT
+    (EXTRACT(EPOCH FROM CURRENT_TIMESTAMP) * 1000)::BIGINT * 1000000 + ROW_NUMBER() OVER (),
+    family_id,
+    user_uuid,
+    MIN(issued_at),
+    COALESCE(MAX(last_used_at), MAX(issued_at)),
+    MAX(absolute_expired_at),
+    MAX(revoked_at),
+    MAX(revoked_reason)
+FROM refresh_tokens
+GROUP BY family_id, user_uuid;
+
+-- The push token moves to the user's most recent session
+UPDATE user_sessions us
+SET fcm_token = ft.device_token
+FROM fcm_tokens ft
+WHERE ft.user_uuid = us.user_uuid
+AND us.session_uuid = (
+    SELECT session_uuid FROM user_sessions latest
+    WHERE latest.user_uuid = us.user_uuid AND latest.revoked_at IS NULL
+    ORDER BY latest.last_used_at DESC
+    LIMIT 1
+);
+
+ALTER TABLE refresh_tokens
+    ADD CONSTRAINT refresh_tokens_family_id_fkey FOREIGN KEY (family_id) REFERENCES user_sessions (session_uuid) ON UPDATE NO ACTION ON DELETE CASCADE;
+
+DROP TABLE IF EXISTS fcm_tokens CASCADE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS fcm_tokens (
+    id BIGINT PRIMARY KEY,
+    user_uuid UUID UNIQUE NOT NULL,
+    device_token TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NULL DEFAULT NULL,
+    FOREIGN KEY (user_uuid) REFERENCES users (user_uuid) ON UPDATE NO ACTION ON DELETE CASCADE
+);
+
+INSERT INTO fcm_tokens (id, user_uuid, device_token, created_at)
+SELECT DISTINCT ON (user_uuid) session_id, user_uuid, fcm_token, created_at
+FROM user_sessions
+WHERE fcm_token IS NOT NULL AND revoked_at IS NULL
+ORDER BY user_uuid, last_used_at DESC;
+
+ALTER TABLE refresh_tokens DROP CONSTRAINT IF EXISTS refresh_tokens_family_id_fkey;
+
+DROP TABLE IF EXISTS user_sessions CASCADE;
+-- +goose StatementEnd
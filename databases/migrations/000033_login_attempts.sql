@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS login_attempts (
+    attempt_id BIGINT PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    user_uuid UUID NULL DEFAULT NULL,
+    ip_address VARCHAR(45) NOT NULL,
+    user_agent TEXT NULL DEFAULT NULL,
+    succeeded BOOLEAN NOT NULL,
+    failure_reason VARCHAR(30) NULL DEFAULT NULL,
+    attempted_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK (failure_reason IS NULL OR failure_reason IN ('invalid_credentials', 'locked')),
+    FOREIGN KEY (user_uuid) REFERENCES users (user_uuid) ON UPDATE NO ACTION ON DELETE SET NULL
+);
+
+CREATE INDEX idx_login_attempts_email_attempted ON login_attempts(email, attempted_at);
+CREATE INDEX idx_login_attempts_ip_attempted ON login_attempts(ip_address, attempted_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS login_attempts CASCADE;
+-- +goose StatementEnd
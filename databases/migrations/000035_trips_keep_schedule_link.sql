@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Schedules are soft-deleted now, trips keep pointing at the schedule they were generated from
+ALTER TABLE trips DROP CONSTRAINT IF EXISTS trips_schedule_uuid_fkey;
+ALTER TABLE trips ADD CONSTRAINT trips_schedule_uuid_fkey FOREIGN KEY (schedule_uuid) REFERENCES route_schedules (schedule_uuid) ON UPDATE NO ACTION ON DELETE NO ACTION;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE trips DROP CONSTRAINT IF EXISTS trips_schedule_uuid_fkey;
+ALTER TABLE trips ADD CONSTRAINT trips_schedule_uuid_fkey FOREIGN KEY (schedule_uuid) REFERENCES route_schedules (schedule_uuid) ON UPDATE NO ACTION ON DELETE SET NULL;
+-- +goose StatementEnd
@@ -3,15 +3,18 @@ package handler
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"shuttle/errors"
 	"shuttle/logger"
 	"shuttle/models/dto"
 	"shuttle/services"
 	"shuttle/utils"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
 type AuthHandlerInterface interface {
@@ -21,19 +24,31 @@ type AuthHandlerInterface interface {
 	UpdateMyProfile(c *fiber.Ctx) error
 	IssueNewAccessToken(c *fiber.Ctx) error
 	AddDeviceToken(c *fiber.Ctx) error
+	GetMySessions(c *fiber.Ctx) error
+	RevokeMySession(c *fiber.Ctx) error
+	ForceLogoutUser(c *fiber.Ctx) error
+	GetLoginAttempts(c *fiber.Ctx) error
+	ForgotPassword(c *fiber.Ctx) error
+	ResetPassword(c *fiber.Ctx) error
 	ChangePassword(c *fiber.Ctx) error
 	ChangeProfilePicture(c *fiber.Ctx) error
 }
 
 type authHandler struct {
-	authService services.AuthService
-	userService services.UserService
+	authService          services.AuthService
+	userService          services.UserService
+	loginAttemptService  services.LoginAttemptServiceInterface
+	passwordResetService services.PasswordResetServiceInterface
+	hub                  *utils.Hub
 }
 
-func NewAuthHttpHandler(authService services.AuthService, userService services.UserService) AuthHandlerInterface {
+func NewAuthHttpHandler(authService services.AuthService, userService services.UserService, loginAttemptService services.LoginAttemptServiceInterface, passwordResetService services.PasswordResetServiceInterface, hub *utils.Hub) AuthHandlerInterface {
 	return &authHandler{
-		authService: authService,
-		userService: userService,
+		authService:          authService,
+		userService:          userService,
+		loginAttemptService:  loginAttemptService,
+		passwordResetService: passwordResetService,
+		hub:                  hub,
 	}
 }
 
@@ -43,41 +58,60 @@ func (handler *authHandler) Login(c *fiber.Ctx) error {
 		return utils.BadRequestResponse(c, "Invalid request data", nil)
 	}
 
+	// Lockout and audit trail key on the normalized email, the credential lookup keeps it as typed
+	email := strings.ToLower(strings.TrimSpace(loginRequest.Email))
+	userAgent := c.Get(fiber.HeaderUserAgent)
+
+	retryAfter, err := handler.loginAttemptService.CheckLockout(email, c.IP())
+	if err != nil {
+		logger.LogError(err, "Failed to check login lockout", map[string]interface{}{
+			"email": email,
+		})
+		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+	}
+	if retryAfter > 0 {
+		handler.recordLoginAttempt(email, c.IP(), userAgent, "", false, services.LoginFailureLocked)
+
+		seconds := int(math.Ceil(retryAfter.Seconds()))
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+		return utils.ErrorResponse(c, fiber.StatusTooManyRequests, fmt.Sprintf("Too many failed login attempts, try again in %d minute(s)", (seconds+59)/60), nil)
+	}
+
 	userDataOnLogin, err := handler.authService.Login(loginRequest.Email, loginRequest.Password)
 	if err != nil {
 		logger.LogError(err, "Failed to login", map[string]interface{}{
-			"email": loginRequest.Email,
+			"email": email,
 		})
+		handler.recordLoginAttempt(email, c.IP(), userAgent, "", false, services.LoginFailureInvalidCredentials)
 		return utils.UnauthorizedResponse(c, "Invalid email or password", nil)
 	}
 
+	handler.recordLoginAttempt(email, c.IP(), userAgent, userDataOnLogin.UserUUID, true, "")
+
 	logger.LogInfo("User logged in", map[string]interface{}{
 		"id":    userDataOnLogin.UserID,
 		"email": loginRequest.Email,
 	})
 
-	// Access token (short expiration)
-	accessToken, err := utils.GenerateToken(fmt.Sprintf("%d", userDataOnLogin.UserID), userDataOnLogin.UserUUID, userDataOnLogin.Username, userDataOnLogin.RoleCode)
-	if err != nil {
-		logger.LogError(err, "Failed to generate access token", map[string]interface{}{
-			"user_id": userDataOnLogin.UserID,
-		})
-		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
-	}
-
-	// Refresh token (long expiration)
-	refreshToken, err := utils.GenerateRefreshToken(fmt.Sprintf("%d", userDataOnLogin.UserID), userDataOnLogin.UserUUID, userDataOnLogin.Username, userDataOnLogin.RoleCode)
+	// Session for this device with its refresh token (long expiration)
+	session, err := handler.authService.StartSession(userDataOnLogin, dto.SessionDeviceDTO{
+		DeviceName: loginRequest.DeviceName,
+		Platform:   loginRequest.Platform,
+		IPAddress:  c.IP(),
+		UserAgent:  c.Get(fiber.HeaderUserAgent),
+		FCMToken:   loginRequest.FCMToken,
+	})
 	if err != nil {
-		logger.LogError(err, "Failed to generate refresh token", map[string]interface{}{
+		logger.LogError(err, "Failed to start session", map[string]interface{}{
 			"user_id": userDataOnLogin.UserID,
 		})
 		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
 	}
 
-	// Save refresh token in the database
-	err = utils.SaveRefreshToken(userDataOnLogin.UserUUID, refreshToken)
+	// Access token (short expiration)
+	accessToken, err := utils.GenerateToken(session.UserID, session.UserUUID, session.Username, session.RoleCode, session.SessionUUID)
 	if err != nil {
-		logger.LogError(err, "Failed to save refresh token", map[string]interface{}{
+		logger.LogError(err, "Failed to generate access token", map[string]interface{}{
 			"user_id": userDataOnLogin.UserID,
 		})
 		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
@@ -85,46 +119,66 @@ func (handler *authHandler) Login(c *fiber.Ctx) error {
 
 	responseData := map[string]interface{}{
 		"access_token":  accessToken,
-		"refresh_token": refreshToken,
+		"refresh_token": session.RefreshToken,
+		"session_uuid":  session.SessionUUID,
 	}
 
 	return utils.SuccessResponse(c, "User logged in successfully", responseData)
 }
 
+// The audit trail must not block a login, a failure to write it is only logged
+func (handler *authHandler) recordLoginAttempt(email, ipAddress, userAgent, userUUID string, succeeded bool, failureReason string) {
+	if err := handler.loginAttemptService.RecordAttempt(email, ipAddress, userAgent, userUUID, succeeded, failureReason); err != nil {
+		logger.LogError(err, "Failed to record login attempt", map[string]interface{}{
+			"email": email,
+			"ip":    ipAddress,
+		})
+	}
+}
+
 func (handler *authHandler) Logout(c *fiber.Ctx) error {
 	userUUID, ok := c.Locals("userUUID").(string)
 	if !ok {
 		return utils.UnauthorizedResponse(c, "Token is invalid", nil)
 	}
 
-	// Delete WebSocket connection if exists
-	conn, exists := utils.GetConnection(userUUID)
-	if exists {
-		conn.Close()
-		utils.RemoveConnection(userUUID)
-		logger.LogInfo("WebSocket connection closed", map[string]interface{}{
-			"user_uuid": userUUID,
-		})
+	sessionUUID, ok := c.Locals("sessionUUID").(string)
+	if !ok {
+		return utils.UnauthorizedResponse(c, "Token is invalid", nil)
 	}
 
-	err := handler.authService.DeleteRefreshTokenOnLogout(c.Context(), userUUID)
+	// Only this device is signed out, the user's other sessions stay
+	err := handler.authService.EndSession(userUUID, sessionUUID, services.SessionRevokedLogout)
 	if err != nil {
-		logger.LogError(err, "Failed to delete refresh token", map[string]interface{}{
-			"user_uuid": userUUID,
+		logger.LogError(err, "Failed to end session", map[string]interface{}{
+			"user_uuid":    userUUID,
+			"session_uuid": sessionUUID,
 		})
 		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
 	}
 
-	utils.InvalidateToken(c.Get("Authorization"))
+	handler.hub.DisconnectSession(userUUID, sessionUUID)
 
-	err = handler.authService.UpdateUserStatus(userUUID, "offline", time.Now())
+	// Still online on another device; a socket of this session that is still
+	// closing marks the user offline itself when it was the last one
+	remaining, err := handler.authService.GetMySessions(userUUID, sessionUUID)
 	if err != nil {
-		logger.LogError(err, "Failed to update user status", map[string]interface{}{
+		logger.LogError(err, "Failed to fetch remaining sessions", map[string]interface{}{
 			"user_uuid": userUUID,
 		})
 		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
 	}
 
+	if len(remaining) == 0 && handler.hub.UserConnectionCount(userUUID) == 0 {
+		err = handler.authService.UpdateUserStatus(userUUID, "offline", time.Now())
+		if err != nil {
+			logger.LogError(err, "Failed to update user status", map[string]interface{}{
+				"user_uuid": userUUID,
+			})
+			return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+		}
+	}
+
 	return utils.SuccessResponse(c, "User logged out successfully", nil)
 }
 
@@ -271,6 +325,54 @@ func (handler *authHandler) ChangePassword(c *fiber.Ctx) error {
 	return utils.SuccessResponse(c, "Password changed successfully", nil)
 }
 
+// Always answers the same way, whether or not the email has an account
+func (handler *authHandler) ForgotPassword(c *fiber.Ctx) error {
+	forgotPasswordRequest := new(dto.ForgotPasswordRequest)
+	if err := c.BodyParser(forgotPasswordRequest); err != nil {
+		return utils.BadRequestResponse(c, "Invalid request data", nil)
+	}
+
+	if err := utils.ValidateStruct(c, forgotPasswordRequest); err != nil {
+		return utils.BadRequestResponse(c, strings.ToUpper(err.Error()[0:1])+err.Error()[1:], nil)
+	}
+
+	// Emails are stored as registered, so the lookup must not change their case
+	email := strings.TrimSpace(forgotPasswordRequest.Email)
+	handler.passwordResetService.RequestPasswordReset(email, c.IP())
+
+	return utils.SuccessResponse(c, "If the email is registered, a password reset link has been sent", nil)
+}
+
+func (handler *authHandler) ResetPassword(c *fiber.Ctx) error {
+	resetPasswordRequest := new(dto.ResetPasswordRequest)
+	if err := c.BodyParser(resetPasswordRequest); err != nil {
+		return utils.BadRequestResponse(c, "Invalid request data", nil)
+	}
+
+	if err := utils.ValidateStruct(c, resetPasswordRequest); err != nil {
+		return utils.BadRequestResponse(c, strings.ToUpper(err.Error()[0:1])+err.Error()[1:], nil)
+	}
+
+	userUUID, err := handler.passwordResetService.ResetPassword(resetPasswordRequest.Token, resetPasswordRequest.NewPassword)
+	if err != nil {
+		if customErr, ok := err.(*errors.CustomError); ok {
+			return utils.ErrorResponse(c, customErr.StatusCode, strings.ToUpper(string(customErr.Message[0]))+customErr.Message[1:], nil)
+		}
+		logger.LogError(err, "Failed to reset password", nil)
+		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+	}
+
+	// Every device has to sign in again with the new password
+	handler.hub.DisconnectUser(userUUID)
+
+	logger.LogInfo("Password reset", map[string]interface{}{
+		"user_uuid": userUUID,
+		"ip":        c.IP(),
+	})
+
+	return utils.SuccessResponse(c, "Password reset successfully, please log in again", nil)
+}
+
 // Reissue a new access token
 func (handler *authHandler) IssueNewAccessToken(c *fiber.Ctx) error {
 	refreshToken := c.Get("Authorization")
@@ -284,57 +386,27 @@ func (handler *authHandler) IssueNewAccessToken(c *fiber.Ctx) error {
 		refreshToken = refreshToken[len(bearerPrefix):]
 	}
 
-	claims, err := utils.ValidateToken(refreshToken)
-	if err != nil {
-		logger.LogWarn("Invalid refresh token", map[string]interface{}{
-			"error": err.Error(),
-		})
-		return utils.UnauthorizedResponse(c, "Invalid refresh token", nil)
-	}
-
-	userID := claims["sub"].(string)
-	userUUID := claims["user_uuid"].(string)
-
-	tokenErr := handler.authService.CheckStoredRefreshToken(userUUID, refreshToken)
-	if tokenErr != nil {
-		logger.LogError(tokenErr, "Failed to get stored refresh token", map[string]interface{}{
-			"user_id": userID,
-			"token":   refreshToken,
-		})
-		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
-	}
-
-	username := claims["user_name"].(string)
-	roleCode := claims["role_code"].(string)
-
-	newRefreshToken, err := utils.RegenerateRefreshToken(refreshToken)
+	refreshed, err := handler.authService.RotateRefreshToken(refreshToken)
 	if err != nil {
-		logger.LogError(err, "Failed to regenerate refresh token", map[string]interface{}{
-			"user_id": userID,
-		})
+		if customErr, ok := err.(*errors.CustomError); ok {
+			return utils.ErrorResponse(c, customErr.StatusCode, strings.ToUpper(string(customErr.Message[0]))+customErr.Message[1:], nil)
+		}
+		logger.LogError(err, "Failed to rotate refresh token", nil)
 		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
 	}
 
-	err = handler.authService.UpdateRefreshToken(userUUID, refreshToken, newRefreshToken)
-	if err != nil {
-		logger.LogError(err, "Failed to update refresh token", map[string]interface{}{
-			"user_uuid": userUUID,
-		})
-		return utils.UnauthorizedResponse(c, "Something went wrong, please try again later", nil)
-	}
-
 	// Generate new access token
-	newAccessToken, err := utils.GenerateToken(userID, userUUID, username, roleCode)
+	newAccessToken, err := utils.GenerateToken(refreshed.UserID, refreshed.UserUUID, refreshed.Username, refreshed.RoleCode, refreshed.SessionUUID)
 	if err != nil {
 		logger.LogError(err, "Failed to generate access token", map[string]interface{}{
-			"user_id": userID,
+			"user_id": refreshed.UserID,
 		})
 		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
 	}
 
 	return utils.SuccessResponse(c, "Access token refreshed", map[string]interface{}{
 		"reissued_access_token":  newAccessToken,
-		"reiussed_refresh_token": newRefreshToken,
+		"reiussed_refresh_token": refreshed.RefreshToken,
 	})
 }
 
@@ -344,6 +416,11 @@ func (handler *authHandler) AddDeviceToken(c *fiber.Ctx) error {
 		return utils.UnauthorizedResponse(c, "Token is invalid", nil)
 	}
 
+	sessionUUID, ok := c.Locals("sessionUUID").(string)
+	if !ok {
+		return utils.UnauthorizedResponse(c, "Token is invalid", nil)
+	}
+
 	tokenRequest := new(dto.DeviceTokenRequest)
 	if err := c.BodyParser(tokenRequest); err != nil {
 		return utils.BadRequestResponse(c, "Invalid request data", nil)
@@ -357,9 +434,12 @@ func (handler *authHandler) AddDeviceToken(c *fiber.Ctx) error {
 
 	deviceToken := tokenRequest.Token
 
-	// Simpan FCM Token di database
-	err := handler.authService.AddDeviceToken(userUUID, deviceToken)
+	// Simpan FCM Token di database, pada sesi perangkat ini
+	err := handler.authService.AddDeviceToken(sessionUUID, deviceToken)
 	if err != nil {
+		if customErr, ok := err.(*errors.CustomError); ok {
+			return utils.ErrorResponse(c, customErr.StatusCode, strings.ToUpper(string(customErr.Message[0]))+customErr.Message[1:], nil)
+		}
 		logger.LogError(err, "Failed to save FCM token", map[string]interface{}{
 			"user_uuid":    userUUID,
 			"device_token": tokenRequest.Token,
@@ -372,6 +452,164 @@ func (handler *authHandler) AddDeviceToken(c *fiber.Ctx) error {
 	return utils.SuccessResponse(c, "Device token added successfully", nil)
 }
 
+func (handler *authHandler) GetMySessions(c *fiber.Ctx) error {
+	userUUID, ok := c.Locals("userUUID").(string)
+	if !ok {
+		return utils.UnauthorizedResponse(c, "Token is invalid", nil)
+	}
+
+	sessionUUID, _ := c.Locals("sessionUUID").(string)
+
+	sessions, err := handler.authService.GetMySessions(userUUID, sessionUUID)
+	if err != nil {
+		logger.LogError(err, "Failed to fetch sessions", map[string]interface{}{
+			"user_uuid": userUUID,
+		})
+		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+	}
+
+	return utils.SuccessResponse(c, "Sessions fetched successfully", sessions)
+}
+
+// Sign out one of the user's own devices
+func (handler *authHandler) RevokeMySession(c *fiber.Ctx) error {
+	userUUID, ok := c.Locals("userUUID").(string)
+	if !ok {
+		return utils.UnauthorizedResponse(c, "Token is invalid", nil)
+	}
+
+	sessionUUID := c.Params("id")
+
+	err := handler.authService.EndSession(userUUID, sessionUUID, services.SessionRevokedSignedOut)
+	if err != nil {
+		if customErr, ok := err.(*errors.CustomError); ok {
+			return utils.ErrorResponse(c, customErr.StatusCode, strings.ToUpper(string(customErr.Message[0]))+customErr.Message[1:], nil)
+		}
+		logger.LogError(err, "Failed to end session", map[string]interface{}{
+			"user_uuid":    userUUID,
+			"session_uuid": sessionUUID,
+		})
+		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+	}
+
+	handler.hub.DisconnectSession(userUUID, sessionUUID)
+
+	return utils.SuccessResponse(c, "Session signed out successfully", nil)
+}
+
+// Sign a user out of every device
+func (handler *authHandler) ForceLogoutUser(c *fiber.Ctx) error {
+	userUUID := c.Params("id")
+	if _, err := uuid.Parse(userUUID); err != nil {
+		return utils.BadRequestResponse(c, "Invalid user UUID format", nil)
+	}
+
+	if _, err := handler.userService.GetSpecUserWithDetails(userUUID); err != nil {
+		return utils.NotFoundResponse(c, "User not found", nil)
+	}
+
+	revoked, err := handler.authService.EndAllSessions(userUUID, services.SessionRevokedForced)
+	if err != nil {
+		logger.LogError(err, "Failed to end user sessions", map[string]interface{}{
+			"user_uuid": userUUID,
+		})
+		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+	}
+
+	handler.hub.DisconnectUser(userUUID)
+
+	if err := handler.authService.UpdateUserStatus(userUUID, "offline", time.Now()); err != nil {
+		logger.LogError(err, "Failed to update user status", map[string]interface{}{
+			"user_uuid": userUUID,
+		})
+	}
+
+	logger.LogInfo("User signed out of every device", map[string]interface{}{
+		"user_uuid":  userUUID,
+		"sessions":   revoked,
+		"revoked_by": c.Locals("user_name"),
+	})
+
+	return utils.SuccessResponse(c, "User logged out from every device", map[string]interface{}{
+		"sessions_revoked": revoked,
+	})
+}
+
+func (handler *authHandler) GetLoginAttempts(c *fiber.Ctx) error {
+	page, err := strconv.Atoi(c.Query("page", "1"))
+	if err != nil || page < 1 {
+		return utils.BadRequestResponse(c, "Invalid page number", nil)
+	}
+
+	limit, err := strconv.Atoi(c.Query("limit", "10"))
+	if err != nil || limit < 1 {
+		return utils.BadRequestResponse(c, "Invalid limit number", nil)
+	}
+
+	filter := dto.LoginAttemptFilter{
+		Email:     strings.ToLower(strings.TrimSpace(c.Query("email"))),
+		IPAddress: c.Query("ip"),
+		Succeeded: c.Query("succeeded"),
+		DateFrom:  c.Query("date_from"),
+		DateTo:    c.Query("date_to"),
+	}
+	if filter.Succeeded != "" && filter.Succeeded != "true" && filter.Succeeded != "false" {
+		return utils.BadRequestResponse(c, "Invalid 'succeeded' value, use true or false", nil)
+	}
+	if filter.DateFrom != "" {
+		if _, err := time.Parse("2006-01-02", filter.DateFrom); err != nil {
+			return utils.BadRequestResponse(c, "Invalid 'date_from' format, use YYYY-MM-DD", nil)
+		}
+	}
+	if filter.DateTo != "" {
+		if _, err := time.Parse("2006-01-02", filter.DateTo); err != nil {
+			return utils.BadRequestResponse(c, "Invalid 'date_to' format, use YYYY-MM-DD", nil)
+		}
+	}
+
+	attempts, totalItems, err := handler.loginAttemptService.GetLoginAttempts(filter, page, limit)
+	if err != nil {
+		logger.LogError(err, "Failed to fetch login attempts", nil)
+		return utils.InternalServerErrorResponse(c, "Failed to fetch login attempts", nil)
+	}
+
+	totalPages := (totalItems + limit - 1) / limit
+	if page > totalPages {
+		if totalItems > 0 {
+			return utils.BadRequestResponse(c, "Page number out of range", nil)
+		}
+		page = 1
+	}
+
+	start := (page-1)*limit + 1
+	if totalItems == 0 || start > totalItems {
+		start = 0
+	}
+
+	end := start + len(attempts) - 1
+	if end > totalItems {
+		end = totalItems
+	}
+
+	if len(attempts) == 0 {
+		start = 0
+		end = 0
+	}
+
+	response := fiber.Map{
+		"data": attempts,
+		"meta": fiber.Map{
+			"current_page":   page,
+			"total_pages":    totalPages,
+			"per_page_items": limit,
+			"total_items":    totalItems,
+			"showing":        fmt.Sprintf("Showing %d-%d of %d", start, end, totalItems),
+		},
+	}
+
+	return utils.SuccessResponse(c, "Login attempts fetched successfully", response)
+}
+
 func mergeDetails(updateRequestDetails, existingDetails json.RawMessage) (json.RawMessage, error) {
 	// Unmarshal `existingDetails` into a map
 	var existingDetailsMap map[string]interface{}
@@ -2,7 +2,9 @@ package handler
 
 import (
 	"net/http"
+	"strings"
 
+	"shuttle/errors"
 	"shuttle/models/dto"
 	"shuttle/services"
 	"shuttle/utils"
@@ -17,6 +19,9 @@ type ChildernHandlerInterface interface {
 	GetSpecChildern(c *fiber.Ctx) error
 	UpdateChildern(c *fiber.Ctx) error
 	UpdateChildernStatus(c *fiber.Ctx) error
+	AddAbsence(c *fiber.Ctx) error
+	GetAbsences(c *fiber.Ctx) error
+	CancelAbsence(c *fiber.Ctx) error
 }
 
 type ChildernHandler struct {
@@ -166,4 +171,62 @@ func (handler *ChildernHandler) UpdateChildernStatus(c *fiber.Ctx) error {
 		"message": "Student status updated successfully",
 		"status":  true,
 	})
-}
\ No newline at end of file
+}
+
+func (handler *ChildernHandler) AddAbsence(c *fiber.Ctx) error {
+	parentUUID, ok := c.Locals("userUUID").(string)
+	if !ok || parentUUID == "" {
+		return utils.UnauthorizedResponse(c, "User UUID is missing or invalid", nil)
+	}
+	username, _ := c.Locals("user_name").(string)
+
+	var req dto.StudentAbsenceRequestDTO
+	if err := c.BodyParser(&req); err != nil {
+		return utils.BadRequestResponse(c, "Invalid request data", nil)
+	}
+	if err := utils.ValidateStruct(c, req); err != nil {
+		return utils.BadRequestResponse(c, "Validation error: "+err.Error(), nil)
+	}
+
+	absence, err := handler.ChildernService.AddAbsence(c.Params("id"), parentUUID, req, username)
+	if err != nil {
+		return absenceErrorResponse(c, err, "Failed to add absence")
+	}
+
+	return utils.CreatedResponse(c, "Absence added successfully", absence)
+}
+
+func (handler *ChildernHandler) GetAbsences(c *fiber.Ctx) error {
+	parentUUID, ok := c.Locals("userUUID").(string)
+	if !ok || parentUUID == "" {
+		return utils.UnauthorizedResponse(c, "User UUID is missing or invalid", nil)
+	}
+
+	absences, err := handler.ChildernService.GetAbsences(c.Params("id"), parentUUID)
+	if err != nil {
+		return absenceErrorResponse(c, err, "Failed to fetch absences")
+	}
+
+	return utils.SuccessResponse(c, "Absences fetched successfully", absences)
+}
+
+func (handler *ChildernHandler) CancelAbsence(c *fiber.Ctx) error {
+	parentUUID, ok := c.Locals("userUUID").(string)
+	if !ok || parentUUID == "" {
+		return utils.UnauthorizedResponse(c, "User UUID is missing or invalid", nil)
+	}
+	username, _ := c.Locals("user_name").(string)
+
+	if err := handler.ChildernService.CancelAbsence(c.Params("id"), parentUUID, username); err != nil {
+		return absenceErrorResponse(c, err, "Failed to cancel absence")
+	}
+
+	return utils.SuccessResponse(c, "Absence cancelled successfully", nil)
+}
+
+func absenceErrorResponse(c *fiber.Ctx, err error, message string) error {
+	if customErr, ok := err.(*errors.CustomError); ok {
+		return utils.ErrorResponse(c, customErr.StatusCode, strings.ToUpper(string(customErr.Message[0]))+customErr.Message[1:], nil)
+	}
+	return utils.InternalServerErrorResponse(c, message, nil)
+}
@@ -1,11 +1,14 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"shuttle/models/dto"
 	"shuttle/services"
 	"shuttle/utils"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
@@ -15,9 +18,12 @@ type RouteHandlerInterface interface {
 	GetAllRoutesByAS(c *fiber.Ctx) error
 	GetSpecRouteByAS(c *fiber.Ctx) error
 	GetAllRoutesByDriver(c *fiber.Ctx) error
+	GetDriverItinerary(c *fiber.Ctx) error
+	GetRouteGeoJSON(c *fiber.Ctx) error
 	AddRoute(c *fiber.Ctx) error
 	UpdateRoute(c *fiber.Ctx) error
 	DeleteRoute(c *fiber.Ctx) error
+	OptimizeRoute(c *fiber.Ctx) error
 }
 
 type routeHandler struct {
@@ -129,6 +135,83 @@ func (handler *routeHandler) GetAllRoutesByDriver(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{"routes": routes})
 }
 
+// Itinerary of today's runs as JSON, or as a GPX or GeoJSON file for navigation apps
+func (handler *routeHandler) GetDriverItinerary(c *fiber.Ctx) error {
+	driverUUID, ok := c.Locals("userUUID").(string)
+	if !ok {
+		return utils.InternalServerErrorResponse(c, "Token does not contain driver UUID", nil)
+	}
+	if _, err := uuid.Parse(driverUUID); err != nil {
+		return utils.BadRequestResponse(c, "Invalid UUID format", nil)
+	}
+
+	format := c.Query("format", "json")
+	if format != "json" && format != "gpx" && format != "geojson" {
+		return utils.BadRequestResponse(c, "Invalid format, use 'json', 'gpx' or 'geojson'", nil)
+	}
+
+	itineraries, err := handler.routeService.GetDriverItinerary(driverUUID)
+	if err != nil {
+		return utils.InternalServerErrorResponse(c, "Failed to fetch itinerary", nil)
+	}
+
+	filename := "itinerary-" + time.Now().Format("2006-01-02")
+	switch format {
+	case "gpx":
+		body, err := services.ItineraryGPX(itineraries).Encode()
+		if err != nil {
+			return utils.InternalServerErrorResponse(c, "Failed to encode itinerary", nil)
+		}
+		c.Set(fiber.HeaderContentType, utils.GPXContentType)
+		c.Attachment(filename + ".gpx")
+		return c.Send(body)
+	case "geojson":
+		c.Attachment(filename + ".geojson")
+		if err := c.JSON(services.ItineraryGeoJSON(itineraries)); err != nil {
+			return err
+		}
+		c.Set(fiber.HeaderContentType, "application/geo+json")
+		return nil
+	}
+
+	return utils.SuccessResponse(c, "Itinerary fetched successfully", itineraries)
+}
+
+func (handler *routeHandler) GetRouteGeoJSON(c *fiber.Ctx) error {
+	schoolUUID, ok := c.Locals("schoolUUID").(string)
+	if !ok {
+		return utils.InternalServerErrorResponse(c, "Token does not contain schoolUUID", nil)
+	}
+
+	routeNameUUID := c.Params("id")
+	if _, err := uuid.Parse(routeNameUUID); err != nil {
+		return utils.BadRequestResponse(c, "Invalid route UUID format", nil)
+	}
+
+	date := time.Now()
+	if dateParam := c.Query("date"); dateParam != "" {
+		parsed, err := time.Parse("2006-01-02", dateParam)
+		if err != nil {
+			return utils.BadRequestResponse(c, "Invalid date format, use YYYY-MM-DD", nil)
+		}
+		date = parsed
+	}
+
+	collection, err := handler.routeService.GetRouteGeoJSON(routeNameUUID, schoolUUID, date)
+	if err != nil {
+		if err.Error() == "route not found" {
+			return utils.NotFoundResponse(c, "Route not found", nil)
+		}
+		return utils.InternalServerErrorResponse(c, err.Error(), nil)
+	}
+
+	if err := c.JSON(collection); err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, "application/geo+json")
+	return nil
+}
+
 func (handler *routeHandler) AddRoute(c *fiber.Ctx) error {
 	schoolUUID, ok := c.Locals("schoolUUID").(string)
 	if !ok {
@@ -150,6 +233,14 @@ func (handler *routeHandler) AddRoute(c *fiber.Ctx) error {
 
 	err := handler.routeService.AddRoute(*route, schoolUUID, username)
 	if err != nil {
+		var capacityErr *services.VehicleCapacityError
+		if errors.As(err, &capacityErr) {
+			return utils.BadRequestResponse(c, "Vehicle capacity exceeded", capacityErr.Capacity)
+		}
+		if strings.HasPrefix(err.Error(), "invalid schedule") {
+			return utils.BadRequestResponse(c, strings.ToUpper(err.Error()[:1])+err.Error()[1:], nil)
+		}
+
 		// Tangani error spesifik untuk validasi duplikasi student
 		if err.Error() == "same student not permitted" {
 			return utils.BadRequestResponse(c, "Same student not permitted", nil)
@@ -163,6 +254,8 @@ func (handler *routeHandler) AddRoute(c *fiber.Ctx) error {
 			return utils.BadRequestResponse(c, "Driver not found", nil)
 		case "driver already assigned to another route":
 			return utils.BadRequestResponse(c, "Driver already assigned to another route", nil)
+		case "driver has no vehicle":
+			return utils.BadRequestResponse(c, "Driver has no vehicle", nil)
 		}
 
 		// Jika error tidak dikenali, kembalikan respons 500
@@ -190,6 +283,22 @@ func (handler *routeHandler) UpdateRoute(c *fiber.Ctx) error {
 		return utils.BadRequestResponse(c, err.Error(), nil)
 	}
 	if err := handler.routeService.UpdateRoute(*route, routenameUUID, schoolUUID, username); err != nil {
+		var capacityErr *services.VehicleCapacityError
+		if errors.As(err, &capacityErr) {
+			return utils.BadRequestResponse(c, "Vehicle capacity exceeded", capacityErr.Capacity)
+		}
+		if strings.HasPrefix(err.Error(), "invalid schedule") {
+			return utils.BadRequestResponse(c, strings.ToUpper(err.Error()[:1])+err.Error()[1:], nil)
+		}
+
+		switch err.Error() {
+		case "student not found":
+			return utils.BadRequestResponse(c, "Student not found", nil)
+		case "driver not found":
+			return utils.BadRequestResponse(c, "Driver not found", nil)
+		case "driver has no vehicle":
+			return utils.BadRequestResponse(c, "Driver has no vehicle", nil)
+		}
 		return utils.InternalServerErrorResponse(c, err.Error(), nil)
 	}
 	return utils.SuccessResponse(c, "Route updated successfully", nil)
@@ -213,3 +322,39 @@ func (handler *routeHandler) DeleteRoute(c *fiber.Ctx) error {
 	}
 	return utils.SuccessResponse(c, "Route deleted successfully", nil)
 }
+
+func (handler *routeHandler) OptimizeRoute(c *fiber.Ctx) error {
+	schoolUUID, ok := c.Locals("schoolUUID").(string)
+	if !ok {
+		return utils.InternalServerErrorResponse(c, "Token does not contain schoolUUID", nil)
+	}
+
+	req := new(dto.RouteOptimizeRequestDTO)
+	if err := c.BodyParser(req); err != nil {
+		return utils.BadRequestResponse(c, "Invalid request body", nil)
+	}
+	if err := utils.ValidateStruct(c, req); err != nil {
+		return utils.BadRequestResponse(c, err.Error(), nil)
+	}
+
+	seen := make(map[uuid.UUID]bool, len(req.StudentUUIDs))
+	for _, studentUUID := range req.StudentUUIDs {
+		if seen[studentUUID] {
+			return utils.BadRequestResponse(c, "Same student not permitted", nil)
+		}
+		seen[studentUUID] = true
+	}
+
+	optimized, err := handler.routeService.OptimizeRoute(*req, schoolUUID)
+	if err != nil {
+		switch err.Error() {
+		case "student not found":
+			return utils.BadRequestResponse(c, "Student not found", nil)
+		case "driver not found":
+			return utils.BadRequestResponse(c, "Driver not found", nil)
+		}
+		return utils.InternalServerErrorResponse(c, err.Error(), nil)
+	}
+
+	return utils.SuccessResponse(c, "Route optimized successfully", optimized)
+}
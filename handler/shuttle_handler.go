@@ -2,10 +2,10 @@ package handler
 
 import (
 	"database/sql"
-	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"shuttle/errors"
 	"shuttle/logger"
 	"shuttle/models/dto"
 	"shuttle/services"
@@ -21,12 +21,16 @@ import (
 
 type ShuttleHandler struct {
 	ShuttleService services.ShuttleServiceInterface
+	TripService    services.TripServiceInterface
 	DB             *sqlx.DB 
+	Hub            *utils.Hub
 }
 
-func NewShuttleHandler(shuttleService services.ShuttleServiceInterface) *ShuttleHandler {
+func NewShuttleHandler(shuttleService services.ShuttleServiceInterface, tripService services.TripServiceInterface, hub *utils.Hub) *ShuttleHandler {
 	return &ShuttleHandler{
 		ShuttleService: shuttleService,
+		TripService:    tripService,
+		Hub:            hub,
 	}
 }
 
@@ -43,6 +47,20 @@ func (h *ShuttleHandler) GetShuttleSummary(c *fiber.Ctx) error {
 		return utils.InternalServerErrorResponse(c, "Gagal mengambil jumlah shuttle kemarin", err)
 	}
 
+	// Trips generated from routes, as opposed to raw shuttle rows
+	tripToday, err := h.TripService.GetTripCountByDate(time.Now(), "")
+	if err != nil {
+		return utils.InternalServerErrorResponse(c, "Gagal mengambil jumlah trip hari ini", err)
+	}
+	tripCompletedToday, err := h.TripService.GetTripCountByDate(time.Now(), services.TripStatusCompleted)
+	if err != nil {
+		return utils.InternalServerErrorResponse(c, "Gagal mengambil jumlah trip selesai hari ini", err)
+	}
+	tripYesterday, err := h.TripService.GetTripCountByDate(time.Now().AddDate(0, 0, -1), "")
+	if err != nil {
+		return utils.InternalServerErrorResponse(c, "Gagal mengambil jumlah trip kemarin", err)
+	}
+
 	// Mendapatkan tanggal hari ini dan kemarin
 	shuttleDateToday := time.Now().Format("2006-01-02")
 	shuttleDateYesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
@@ -54,6 +72,9 @@ func (h *ShuttleHandler) GetShuttleSummary(c *fiber.Ctx) error {
 		"shuttle_date_today":   shuttleDateToday,
 		"shuttle_yesterday":    shuttleYesterday,
 		"shuttle_date_yesterday": shuttleDateYesterday,
+		"trip_today":           tripToday,
+		"trip_completed_today": tripCompletedToday,
+		"trip_yesterday":       tripYesterday,
 	})
 }
 
@@ -265,10 +286,8 @@ func (h *ShuttleHandler) EditShuttle(c *fiber.Ctx) error {
 		return utils.BadRequestResponse(c, "Missing shuttleUUID in URL", nil)
 	}
 
-	var statusReq struct {
-		Status string `json:"status" validate:"required"`
-	}
-	if err := c.BodyParser(&statusReq); err != nil {
+	statusReq := new(dto.ShuttleStatusUpdateRequest)
+	if err := c.BodyParser(statusReq); err != nil {
 		return utils.BadRequestResponse(c, "Invalid request body", nil)
 	}
 
@@ -276,21 +295,40 @@ func (h *ShuttleHandler) EditShuttle(c *fiber.Ctx) error {
 		return utils.BadRequestResponse(c, "Invalid status: "+err.Error(), nil)
 	}
 
-	if err := h.ShuttleService.EditShuttleStatus(id, statusReq.Status); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+	// Fall back to the driver's last streamed position when the app doesn't send one
+	if statusReq.Latitude == nil || statusReq.Longitude == nil {
+		if position, exists := h.Hub.GetLastPosition(id); exists && time.Since(position.RecordedAt) < 2*time.Minute {
+			statusReq.Latitude = &position.Latitude
+			statusReq.Longitude = &position.Longitude
+		}
+	}
+
+	driverUUID, _ := c.Locals("userUUID").(string)
+
+	change, err := h.ShuttleService.EditShuttleStatus(id, *statusReq, driverUUID)
+	if err != nil {
+		if customErr, ok := err.(*errors.CustomError); ok {
+			return utils.ErrorResponse(c, customErr.StatusCode, strings.ToUpper(string(customErr.Message[0]))+customErr.Message[1:], nil)
+		}
+		if err == sql.ErrNoRows {
 			return utils.NotFoundResponse(c, "Shuttle not found", nil)
 		}
+		logger.LogError(err, "Failed to edit shuttle status", map[string]interface{}{
+			"shuttleUUID": id,
+		})
 		return utils.InternalServerErrorResponse(c, "Failed to edit shuttle", nil)
 	}
 
-	shuttle, err := h.ShuttleService.GetSpecShuttle(uuid.MustParse(id))
-	if err != nil {
-		return utils.InternalServerErrorResponse(c, "Internal server error, please try again later", nil)
+	parentUUID := change.ParentUUID
+	shuttleStatus := change.NewStatus
+
+	// Push the transition to everyone watching the shuttle and to the parent's own connections
+	if err := utils.PublishStatusChange(h.Hub, change, driverUUID); err != nil {
+		logger.LogError(err, "Failed to publish shuttle status event", map[string]interface{}{
+			"shuttleUUID": id,
+		})
 	}
 
-	parentUUID := shuttle[0].ParentUUID
-	shuttleStatus := shuttle[0].ShuttleStatus
-	
 	// Send notification to parent
 	err = utils.SendNotification(parentUUID, "Shuttle Status Update", shuttleStatus)
 	if err != nil {
@@ -304,3 +342,285 @@ func (h *ShuttleHandler) EditShuttle(c *fiber.Ctx) error {
 
 	return utils.SuccessResponse(c, "Shuttle status updated successfully", nil)
 }
+
+func (h *ShuttleHandler) GetShuttleTrail(c *fiber.Ctx) error {
+	userUUIDStr, ok := c.Locals("userUUID").(string)
+	if !ok || userUUIDStr == "" {
+		return utils.BadRequestResponse(c, "Invalid or missing userUUID", nil)
+	}
+
+	userUUID, err := uuid.Parse(userUUIDStr)
+	if err != nil {
+		return utils.BadRequestResponse(c, "Invalid userUUID format", nil)
+	}
+
+	shuttleUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utils.BadRequestResponse(c, "Invalid shuttle UUID format", nil)
+	}
+
+	roleCode, _ := c.Locals("role_code").(string)
+	schoolUUID, _ := c.Locals("schoolUUID").(string)
+
+	allowed, err := h.ShuttleService.CanAccessShuttle(shuttleUUID, userUUID, roleCode, schoolUUID)
+	if err != nil {
+		logger.LogError(err, "Failed to check shuttle access", map[string]interface{}{
+			"shuttleUUID": shuttleUUID.String(),
+			"userUUID":    userUUIDStr,
+		})
+		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+	}
+	if !allowed {
+		return utils.ForbiddenResponse(c, "You don't have permission to access this shuttle", nil)
+	}
+
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	to := now
+
+	if fromParam := c.Query("from"); fromParam != "" {
+		if from, err = time.Parse(time.RFC3339, fromParam); err != nil {
+			return utils.BadRequestResponse(c, "Invalid 'from' format, use RFC3339", nil)
+		}
+	}
+	if toParam := c.Query("to"); toParam != "" {
+		if to, err = time.Parse(time.RFC3339, toParam); err != nil {
+			return utils.BadRequestResponse(c, "Invalid 'to' format, use RFC3339", nil)
+		}
+	}
+	if to.Before(from) {
+		return utils.BadRequestResponse(c, "'to' must be after 'from'", nil)
+	}
+
+	trail, err := h.ShuttleService.GetShuttleTrail(shuttleUUID, from, to)
+	if err != nil {
+		logger.LogError(err, "Failed to fetch shuttle trail", map[string]interface{}{
+			"shuttleUUID": shuttleUUID.String(),
+		})
+		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+	}
+
+	return utils.SuccessResponse(c, "Shuttle trail fetched successfully", trail)
+}
+
+
+func (h *ShuttleHandler) GetShuttleStatusHistory(c *fiber.Ctx) error {
+	userUUIDStr, ok := c.Locals("userUUID").(string)
+	if !ok || userUUIDStr == "" {
+		return utils.BadRequestResponse(c, "Invalid or missing userUUID", nil)
+	}
+
+	userUUID, err := uuid.Parse(userUUIDStr)
+	if err != nil {
+		return utils.BadRequestResponse(c, "Invalid userUUID format", nil)
+	}
+
+	shuttleUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utils.BadRequestResponse(c, "Invalid shuttle UUID format", nil)
+	}
+
+	roleCode, _ := c.Locals("role_code").(string)
+	schoolUUID, _ := c.Locals("schoolUUID").(string)
+
+	allowed, err := h.ShuttleService.CanAccessShuttle(shuttleUUID, userUUID, roleCode, schoolUUID)
+	if err != nil {
+		logger.LogError(err, "Failed to check shuttle access", map[string]interface{}{
+			"shuttleUUID": shuttleUUID.String(),
+			"userUUID":    userUUIDStr,
+		})
+		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+	}
+	if !allowed {
+		return utils.ForbiddenResponse(c, "You don't have permission to access this shuttle", nil)
+	}
+
+	history, err := h.ShuttleService.GetShuttleStatusHistory(shuttleUUID)
+	if err != nil {
+		logger.LogError(err, "Failed to fetch shuttle status history", map[string]interface{}{
+			"shuttleUUID": shuttleUUID.String(),
+		})
+		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+	}
+
+	return utils.SuccessResponse(c, "Shuttle status history fetched successfully", history)
+}
+
+func (h *ShuttleHandler) BoardStudent(c *fiber.Ctx) error {
+	return h.confirmHandover(c, services.HandoverTypeBoarded)
+}
+
+func (h *ShuttleHandler) AlightStudent(c *fiber.Ctx) error {
+	return h.confirmHandover(c, services.HandoverTypeAlighted)
+}
+
+func (h *ShuttleHandler) confirmHandover(c *fiber.Ctx, handoverType string) error {
+	driverUUID, ok := c.Locals("userUUID").(string)
+	if !ok || driverUUID == "" {
+		return utils.BadRequestResponse(c, "Invalid or missing userUUID", nil)
+	}
+
+	id := c.Params("id")
+	shuttleUUID, err := uuid.Parse(id)
+	if err != nil {
+		return utils.BadRequestResponse(c, "Invalid shuttle UUID format", nil)
+	}
+
+	req := new(dto.StudentHandoverRequest)
+	if err := c.BodyParser(req); err != nil {
+		return utils.BadRequestResponse(c, "Invalid request body", nil)
+	}
+
+	if err := utils.ValidateStruct(c, req); err != nil {
+		return utils.BadRequestResponse(c, err.Error(), nil)
+	}
+
+	// Fall back to the driver's last streamed position when the app doesn't send one
+	if req.Latitude == nil || req.Longitude == nil {
+		if position, exists := h.Hub.GetLastPosition(id); exists && time.Since(position.RecordedAt) < 2*time.Minute {
+			req.Latitude = &position.Latitude
+			req.Longitude = &position.Longitude
+		}
+	}
+
+	proofFile := ""
+	if _, err := c.FormFile("picture"); err == nil {
+		if proofFile, err = utils.HandleUploadedFile(c); err != nil {
+			if customErr, ok := err.(*errors.CustomError); ok {
+				return utils.ErrorResponse(c, customErr.StatusCode, strings.ToUpper(string(customErr.Message[0]))+customErr.Message[1:], nil)
+			}
+			return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+		}
+	}
+
+	handover, err := h.ShuttleService.ConfirmStudentHandover(shuttleUUID, driverUUID, handoverType, *req, proofFile)
+	if err != nil {
+		if err := utils.DeletePicture(proofFile); err != nil {
+			logger.LogWarn("Failed to delete unused handover proof", map[string]interface{}{
+				"error": err.Error(),
+				"file":  proofFile,
+			})
+		}
+		if customErr, ok := err.(*errors.CustomError); ok {
+			return utils.ErrorResponse(c, customErr.StatusCode, strings.ToUpper(string(customErr.Message[0]))+customErr.Message[1:], nil)
+		}
+		logger.LogError(err, "Failed to confirm student handover", map[string]interface{}{
+			"shuttleUUID":  id,
+			"handoverType": handoverType,
+		})
+		return utils.InternalServerErrorResponse(c, "Failed to confirm handover", nil)
+	}
+
+	// Let the parent know right away, both in the app and through a push notification
+	message, err := utils.NewWebSocketMessage(utils.MessageTypeHandover, handover.ShuttleUUID, driverUUID, handover)
+	if err != nil {
+		logger.LogError(err, "Failed to encode handover event", map[string]interface{}{
+			"shuttleUUID": id,
+		})
+	} else {
+		h.Hub.BroadcastToGroupAndUser(handover.ShuttleUUID, handover.ParentUUID, message)
+	}
+
+	title, body := "Student Boarded", handover.StudentFirstName+" has boarded the shuttle"
+	if handoverType == services.HandoverTypeAlighted {
+		title, body = "Student Dropped Off", handover.StudentFirstName+" has been dropped off"
+	}
+	if err := utils.SendNotificationMessage(handover.ParentUUID, title, body); err != nil {
+		logger.LogWarn("Failed to send notification to parent", map[string]interface{}{
+			"error":        err.Error(),
+			"shuttleUUID":  id,
+			"parentUUID":   handover.ParentUUID,
+			"handoverType": handoverType,
+		})
+	}
+
+	return utils.SuccessResponse(c, "Student handover confirmed successfully", handover)
+}
+
+func (h *ShuttleHandler) GetStudentHandovers(c *fiber.Ctx) error {
+	schoolUUID, ok := c.Locals("schoolUUID").(string)
+	if !ok {
+		return utils.BadRequestResponse(c, "Invalid token or schoolUUID", nil)
+	}
+
+	page, err := strconv.Atoi(c.Query("page", "1"))
+	if err != nil || page < 1 {
+		return utils.BadRequestResponse(c, "Invalid page number", nil)
+	}
+
+	limit, err := strconv.Atoi(c.Query("limit", "10"))
+	if err != nil || limit < 1 {
+		return utils.BadRequestResponse(c, "Invalid limit number", nil)
+	}
+
+	filter := dto.StudentHandoverFilter{
+		StudentUUID: c.Query("student_uuid"),
+		ShuttleUUID: c.Query("shuttle_uuid"),
+		DateFrom:    c.Query("date_from"),
+		DateTo:      c.Query("date_to"),
+	}
+	if filter.StudentUUID != "" {
+		if _, err := uuid.Parse(filter.StudentUUID); err != nil {
+			return utils.BadRequestResponse(c, "Invalid student UUID format", nil)
+		}
+	}
+	if filter.ShuttleUUID != "" {
+		if _, err := uuid.Parse(filter.ShuttleUUID); err != nil {
+			return utils.BadRequestResponse(c, "Invalid shuttle UUID format", nil)
+		}
+	}
+	if filter.DateFrom != "" {
+		if _, err := time.Parse("2006-01-02", filter.DateFrom); err != nil {
+			return utils.BadRequestResponse(c, "Invalid 'date_from' format, use YYYY-MM-DD", nil)
+		}
+	}
+	if filter.DateTo != "" {
+		if _, err := time.Parse("2006-01-02", filter.DateTo); err != nil {
+			return utils.BadRequestResponse(c, "Invalid 'date_to' format, use YYYY-MM-DD", nil)
+		}
+	}
+
+	handovers, totalItems, err := h.ShuttleService.GetStudentHandovers(schoolUUID, filter, page, limit)
+	if err != nil {
+		logger.LogError(err, "Failed to fetch student handovers", map[string]interface{}{
+			"schoolUUID": schoolUUID,
+		})
+		return utils.InternalServerErrorResponse(c, "Failed to fetch handovers", nil)
+	}
+
+	totalPages := (totalItems + limit - 1) / limit
+	if page > totalPages {
+		if totalItems > 0 {
+			return utils.BadRequestResponse(c, "Page number out of range", nil)
+		}
+		page = 1
+	}
+
+	start := (page-1)*limit + 1
+	if totalItems == 0 || start > totalItems {
+		start = 0
+	}
+
+	end := start + len(handovers) - 1
+	if end > totalItems {
+		end = totalItems
+	}
+
+	if len(handovers) == 0 {
+		start = 0
+		end = 0
+	}
+
+	response := fiber.Map{
+		"data": handovers,
+		"meta": fiber.Map{
+			"current_page":   page,
+			"total_pages":    totalPages,
+			"per_page_items": limit,
+			"total_items":    totalItems,
+			"showing":        fmt.Sprintf("Showing %d-%d of %d", start, end, totalItems),
+		},
+	}
+
+	return utils.SuccessResponse(c, "Student handovers fetched successfully", response)
+}
@@ -6,12 +6,14 @@ import (
 	"shuttle/errors"
 	"shuttle/logger"
 	"shuttle/models/dto"
+	"shuttle/models/geo"
 	"shuttle/services"
 	"shuttle/utils"
 	"strconv"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
 type StudentHandlerInterface interface {
@@ -19,6 +21,8 @@ type StudentHandlerInterface interface {
 	GetAllStudentWithParents(c *fiber.Ctx) error
 	GetSpecStudentWithParents(c *fiber.Ctx) error
 	GetAvailableStudents(c *fiber.Ctx) error
+	GetNearbyStudents(c *fiber.Ctx) error
+	GetRouteCandidates(c *fiber.Ctx) error
 	AddSchoolStudentWithParents(c *fiber.Ctx) error
 	UpdateSchoolStudentWithParents(c *fiber.Ctx) error
 	DeleteSchoolStudentWithParentsIfNeccessary(c *fiber.Ctx) error
@@ -162,6 +166,95 @@ func (handler *studentHandler) GetAvailableStudents(c *fiber.Ctx) error {
 	})
 }
 
+const (
+	maxNearbyRadiusMeters = 50000
+	maxNearbyLimit        = 200
+)
+
+// Radius in meters and result limit shared by the spatial searches
+func parseNearbyQuery(c *fiber.Ctx, defaultRadius string) (float64, int, error) {
+	radius, err := strconv.ParseFloat(c.Query("radius", defaultRadius), 64)
+	if err != nil || radius <= 0 || radius > maxNearbyRadiusMeters {
+		return 0, 0, fmt.Errorf("invalid radius, use meters between 1 and %d", maxNearbyRadiusMeters)
+	}
+
+	limit, err := strconv.Atoi(c.Query("limit", "50"))
+	if err != nil || limit < 1 || limit > maxNearbyLimit {
+		return 0, 0, fmt.Errorf("invalid limit, use a number between 1 and %d", maxNearbyLimit)
+	}
+
+	return radius, limit, nil
+}
+
+func (handler *studentHandler) GetNearbyStudents(c *fiber.Ctx) error {
+	schoolUUID, ok := c.Locals("schoolUUID").(string)
+	if !ok {
+		return utils.BadRequestResponse(c, "Invalid token or schoolUUID", nil)
+	}
+
+	radius, limit, err := parseNearbyQuery(c, "2000")
+	if err != nil {
+		return utils.BadRequestResponse(c, strings.ToUpper(err.Error()[:1])+err.Error()[1:], nil)
+	}
+
+	// Without coordinates the search is centred on the school
+	var center *geo.GeoPoint
+	if c.Query("latitude") != "" || c.Query("longitude") != "" {
+		latitude, latErr := strconv.ParseFloat(c.Query("latitude"), 64)
+		longitude, lngErr := strconv.ParseFloat(c.Query("longitude"), 64)
+		point := geo.GeoPoint{Latitude: latitude, Longitude: longitude}
+		if latErr != nil || lngErr != nil || point.Validate() != nil {
+			return utils.BadRequestResponse(c, "Invalid latitude or longitude", nil)
+		}
+		center = &point
+	}
+
+	onlyAvailable := c.QueryBool("available", false)
+
+	nearby, err := handler.studentService.GetNearbyStudents(schoolUUID, center, radius, onlyAvailable, limit)
+	if err != nil {
+		if customErr, ok := err.(*errors.CustomError); ok {
+			return utils.ErrorResponse(c, customErr.StatusCode, strings.ToUpper(string(customErr.Message[0]))+customErr.Message[1:], nil)
+		}
+		logger.LogError(err, "Failed to search nearby students", map[string]interface{}{
+			"schoolUUID": schoolUUID,
+		})
+		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+	}
+
+	return utils.SuccessResponse(c, "Nearby students fetched successfully", nearby)
+}
+
+func (handler *studentHandler) GetRouteCandidates(c *fiber.Ctx) error {
+	schoolUUID, ok := c.Locals("schoolUUID").(string)
+	if !ok {
+		return utils.BadRequestResponse(c, "Invalid token or schoolUUID", nil)
+	}
+
+	routeNameUUID := c.Params("id")
+	if _, err := uuid.Parse(routeNameUUID); err != nil {
+		return utils.BadRequestResponse(c, "Invalid route UUID format", nil)
+	}
+
+	radius, limit, err := parseNearbyQuery(c, "500")
+	if err != nil {
+		return utils.BadRequestResponse(c, strings.ToUpper(err.Error()[:1])+err.Error()[1:], nil)
+	}
+
+	candidates, err := handler.studentService.GetRouteCandidates(routeNameUUID, schoolUUID, radius, limit)
+	if err != nil {
+		if customErr, ok := err.(*errors.CustomError); ok {
+			return utils.ErrorResponse(c, customErr.StatusCode, strings.ToUpper(string(customErr.Message[0]))+customErr.Message[1:], nil)
+		}
+		logger.LogError(err, "Failed to search route candidates", map[string]interface{}{
+			"routeUUID": routeNameUUID,
+		})
+		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+	}
+
+	return utils.SuccessResponse(c, "Route candidates fetched successfully", candidates)
+}
+
 func (handler *studentHandler) AddSchoolStudentWithParents(c *fiber.Ctx) error {
 	username, ok := c.Locals("user_name").(string)
 	if !ok {
@@ -188,12 +281,6 @@ func (handler *studentHandler) AddSchoolStudentWithParents(c *fiber.Ctx) error {
 		return utils.BadRequestResponse(c, "Address is required", nil)
 	}
 
-	if student.Student.StudentPickupPoint == nil || 
-		student.Student.StudentPickupPoint["latitude"] == 0 || 
-		student.Student.StudentPickupPoint["longitude"] == 0 {
-		return utils.BadRequestResponse(c, "Valid latitude and longitude are required for pickup point", nil)
-	}
-
 	if reflect.DeepEqual(dto.UserRequestsDTO{}, student.Parent) {
 		return utils.BadRequestResponse(c, "Parent details are required", nil)
 	}
@@ -233,11 +320,6 @@ func (handler *studentHandler) UpdateSchoolStudentWithParents(c *fiber.Ctx) erro
 		return utils.BadRequestResponse(c, err.Error(), nil)
 	}
 
-	if student.StudentPickupPoint["latitude"] == 0 || student.StudentPickupPoint["longitude"] == 0 {
-		logger.LogError(nil, "Valid latitude and longitude are required for pickup point", nil)
-		return utils.BadRequestResponse(c, "Valid latitude and longitude are required for pickup point", nil)
-	}
-
 	if err := handler.studentService.UpdateSchoolStudentWithParents(id, *student, schoolUUIDStr, username); err != nil {
 		if customErr, ok := err.(*errors.CustomError); ok {
 			return utils.ErrorResponse(c, customErr.StatusCode, strings.ToUpper(string(customErr.Message[0]))+customErr.Message[1:], nil)
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"shuttle/errors"
+	"shuttle/logger"
+	"shuttle/models/dto"
+	"shuttle/services"
+	"shuttle/utils"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type TripHandlerInterface interface {
+	GenerateTrips(c *fiber.Ctx) error
+	GetDriverTripsToday(c *fiber.Ctx) error
+	StartTrip(c *fiber.Ctx) error
+	FinishTrip(c *fiber.Ctx) error
+}
+
+type tripHandler struct {
+	tripService services.TripServiceInterface
+}
+
+func NewTripHttpHandler(tripService services.TripServiceInterface) TripHandlerInterface {
+	return &tripHandler{
+		tripService: tripService,
+	}
+}
+
+func (handler *tripHandler) GenerateTrips(c *fiber.Ctx) error {
+	schoolUUID, ok := c.Locals("schoolUUID").(string)
+	if !ok {
+		return utils.InternalServerErrorResponse(c, "Token does not contain schoolUUID", nil)
+	}
+	username, ok := c.Locals("user_name").(string)
+	if !ok {
+		return utils.InternalServerErrorResponse(c, "Token does not contain username", nil)
+	}
+
+	req := new(dto.TripGenerateRequestDTO)
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(req); err != nil {
+			return utils.BadRequestResponse(c, "Invalid request body", nil)
+		}
+	}
+
+	date := time.Now()
+	if req.Date != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", req.Date, time.Local)
+		if err != nil {
+			return utils.BadRequestResponse(c, "Invalid date, use YYYY-MM-DD", nil)
+		}
+		date = parsed
+	}
+
+	created, err := handler.tripService.GenerateTrips(date, schoolUUID, username)
+	if err != nil {
+		logger.LogError(err, "Failed to generate trips", map[string]interface{}{
+			"schoolUUID": schoolUUID,
+		})
+		return utils.InternalServerErrorResponse(c, "Failed to generate trips", nil)
+	}
+
+	return utils.SuccessResponse(c, "Trips generated successfully", dto.TripGenerateResponseDTO{
+		Date:         date.Format("2006-01-02"),
+		TripsCreated: created,
+	})
+}
+
+func (handler *tripHandler) GetDriverTripsToday(c *fiber.Ctx) error {
+	driverUUID, ok := c.Locals("userUUID").(string)
+	if !ok {
+		return utils.InternalServerErrorResponse(c, "Token does not contain driver UUID", nil)
+	}
+
+	trips, err := handler.tripService.GetDriverTripsToday(driverUUID)
+	if err != nil {
+		logger.LogError(err, "Failed to fetch driver trips", map[string]interface{}{
+			"driverUUID": driverUUID,
+		})
+		return utils.InternalServerErrorResponse(c, "Failed to fetch trips", nil)
+	}
+
+	return utils.SuccessResponse(c, "Trips fetched successfully", trips)
+}
+
+func (handler *tripHandler) StartTrip(c *fiber.Ctx) error {
+	driverUUID, ok := c.Locals("userUUID").(string)
+	if !ok {
+		return utils.InternalServerErrorResponse(c, "Token does not contain driver UUID", nil)
+	}
+
+	if err := handler.tripService.StartTrip(c.Params("id"), driverUUID); err != nil {
+		return tripErrorResponse(c, err, "Failed to start trip")
+	}
+
+	return utils.SuccessResponse(c, "Trip started successfully", nil)
+}
+
+func (handler *tripHandler) FinishTrip(c *fiber.Ctx) error {
+	driverUUID, ok := c.Locals("userUUID").(string)
+	if !ok {
+		return utils.InternalServerErrorResponse(c, "Token does not contain driver UUID", nil)
+	}
+
+	if err := handler.tripService.FinishTrip(c.Params("id"), driverUUID); err != nil {
+		return tripErrorResponse(c, err, "Failed to finish trip")
+	}
+
+	return utils.SuccessResponse(c, "Trip finished successfully", nil)
+}
+
+func tripErrorResponse(c *fiber.Ctx, err error, message string) error {
+	if customErr, ok := err.(*errors.CustomError); ok {
+		return utils.ErrorResponse(c, customErr.StatusCode, strings.ToUpper(string(customErr.Message[0]))+customErr.Message[1:], nil)
+	}
+
+	logger.LogError(err, message, map[string]interface{}{
+		"tripUUID": c.Params("id"),
+	})
+	return utils.InternalServerErrorResponse(c, message, nil)
+}
@@ -1,11 +1,14 @@
 package middleware
 
 import (
+	"time"
+
 	"shuttle/logger"
 	"shuttle/utils"
 	"shuttle/services"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
 )
 
 func SchoolAdminMiddleware(service services.UserService) fiber.Handler {
@@ -26,7 +29,7 @@ func SchoolAdminMiddleware(service services.UserService) fiber.Handler {
 	}
 }
 
-func AuthenticationMiddleware() fiber.Handler {
+func AuthenticationMiddleware(revocations services.TokenRevocationServiceInterface) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		token := c.Get("Authorization")
 		if token == "" {
@@ -44,6 +47,39 @@ func AuthenticationMiddleware() fiber.Handler {
 			return utils.UnauthorizedResponse(c, "Token is invalid", nil)
 		}
 
+		// Refresh tokens are only good for /refresh-token
+		if claims["typ"] == utils.TokenTypeRefresh {
+			return utils.UnauthorizedResponse(c, "Token is invalid", nil)
+		}
+
+		jti, ok := claims["jti"].(string)
+		if !ok || jti == "" {
+			logger.LogWarn("Token ID is missing or invalid", map[string]interface{}{"claims": claims})
+			return utils.UnauthorizedResponse(c, "Token is invalid", nil)
+		}
+
+		sessionUUID, ok := claims["sid"].(string)
+		if !ok || sessionUUID == "" {
+			logger.LogWarn("Session ID is missing or invalid", map[string]interface{}{"claims": claims})
+			return utils.UnauthorizedResponse(c, "Token is invalid", nil)
+		}
+
+		exp, ok := claims["exp"].(float64)
+		if !ok {
+			logger.LogWarn("Token expiry is missing or invalid", map[string]interface{}{"claims": claims})
+			return utils.UnauthorizedResponse(c, "Token is invalid", nil)
+		}
+		expiresAt := time.Unix(int64(exp), 0)
+
+		revoked, err := revocations.IsRevoked(expiresAt, jti, sessionUUID)
+		if err != nil {
+			logger.LogError(err, "Failed to check token revocation", map[string]interface{}{"jti": jti})
+			return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+		}
+		if revoked {
+			return utils.UnauthorizedResponse(c, "Token has been revoked", nil)
+		}
+
 		userID, ok := claims["sub"].(string)
 		if !ok || userID == "" {
 			logger.LogWarn("User ID is missing or invalid", map[string]interface{}{"claims": claims})
@@ -68,6 +104,9 @@ func AuthenticationMiddleware() fiber.Handler {
 			return utils.UnauthorizedResponse(c, "Token is invalid", nil)
 		}
 
+		c.Locals("jti", jti)
+		c.Locals("tokenExpiresAt", expiresAt)
+		c.Locals("sessionUUID", sessionUUID)
 		c.Locals("userID", userID)
 		c.Locals("userUUID", userUUID)
 		c.Locals("role_code", role_code)
@@ -77,6 +116,27 @@ func AuthenticationMiddleware() fiber.Handler {
 	}
 }
 
+// Allow each client IP at most max requests per window on the route. Counters
+// are kept in memory, so with several instances the limit applies per instance;
+// the login lockout is the shared protection.
+func RateLimitMiddleware(max int, window time.Duration) fiber.Handler {
+	return limiter.New(limiter.Config{
+		Max:               max,
+		Expiration:        window,
+		LimiterMiddleware: limiter.SlidingWindow{},
+		KeyGenerator: func(c *fiber.Ctx) string {
+			return c.IP()
+		},
+		LimitReached: func(c *fiber.Ctx) error {
+			logger.LogWarn("Rate limit reached", map[string]interface{}{
+				"ip":   c.IP(),
+				"path": c.Path(),
+			})
+			return utils.ErrorResponse(c, fiber.StatusTooManyRequests, "Too many requests, please slow down", nil)
+		},
+	})
+}
+
 func AuthorizationMiddleware(allowedRoles []string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		role_code, ok := c.Locals("role_code").(string)
@@ -1,8 +1,35 @@
 package dto
 
+import "database/sql"
+
 type LoginRequest struct {
-	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required"`
+	Email      string `json:"email" validate:"required,email"`
+	Password   string `json:"password" validate:"required"`
+	DeviceName string `json:"device_name"`
+	Platform   string `json:"platform"`
+	FCMToken   string `json:"fcm_token"`
+}
+
+// The device a session is opened from
+type SessionDeviceDTO struct {
+	DeviceName string
+	Platform   string
+	IPAddress  string
+	UserAgent  string
+	FCMToken   string
+}
+
+type UserSessionResponseDTO struct {
+	SessionUUID  string `db:"session_uuid" json:"session_uuid"`
+	DeviceName   string `db:"device_name" json:"device_name"`
+	Platform     string `db:"platform" json:"platform"`
+	IPAddress    string `db:"ip_address" json:"ip_address"`
+	UserAgent    string `db:"user_agent" json:"user_agent"`
+	HasPushToken bool   `db:"has_push_token" json:"has_push_token"`
+	CreatedAt    string `db:"created_at" json:"created_at"`
+	LastUsedAt   string `db:"last_used_at" json:"last_used_at"`
+	ExpiresAt    string `db:"expires_at" json:"expires_at"`
+	Current      bool   `db:"-" json:"current"`
 }
 
 type DeviceTokenRequest struct {
@@ -21,3 +48,47 @@ type UserDataOnLoginDTO struct {
 	RoleCode  string `json:"user_role_code"`
 	Password  string `json:"user_password"`
 }
+
+// Who the refresh token belongs to, for issuing the access token that goes with it
+type RefreshedTokenDTO struct {
+	UserID       string
+	UserUUID     string
+	Username     string
+	RoleCode     string
+	SessionUUID  string
+	RefreshToken string
+}
+
+// Failed logins that count towards a lockout
+type LoginFailureStatsDTO struct {
+	Failures     int          `db:"failures"`
+	LastFailedAt sql.NullTime `db:"last_failed_at"`
+}
+
+type LoginAttemptFilter struct {
+	Email     string
+	IPAddress string
+	Succeeded string
+	DateFrom  string
+	DateTo    string
+}
+
+type LoginAttemptResponseDTO struct {
+	AttemptID     string `db:"attempt_id" json:"attempt_id"`
+	Email         string `db:"email" json:"email"`
+	UserUUID      string `db:"user_uuid" json:"user_uuid"`
+	IPAddress     string `db:"ip_address" json:"ip_address"`
+	UserAgent     string `db:"user_agent" json:"user_agent"`
+	Succeeded     bool   `db:"succeeded" json:"succeeded"`
+	FailureReason string `db:"failure_reason" json:"failure_reason"`
+	AttemptedAt   string `db:"attempted_at" json:"attempted_at"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,min=8"`
+}
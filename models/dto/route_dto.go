@@ -2,6 +2,8 @@ package dto
 
 import (
 	"database/sql"
+	"shuttle/models/geo"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -37,6 +39,10 @@ type RoutesResponseDTO struct {
 	CreatedBy         string                    `json:"created_by,omitempty"`
 	UpdatedAt         string                    `json:"updated_at,omitempty"`
 	UpdatedBy         string                    `json:"updated_by,omitempty"`
+	AssignedStudents  int                       `json:"assigned_students"`
+	SeatCapacity      int                       `json:"seat_capacity"`
+	SeatUtilization   float64                   `json:"seat_utilization"` // percent of seats taken
+	Schedules         []RouteScheduleResponseDTO `json:"schedules,omitempty"`
 	RouteAssignment   []RouteAssignmentResponseDTO `json:"route_assignment"`
 }
 
@@ -45,6 +51,8 @@ type RoutesRequestDTO struct {
 	RouteName        string                     `json:"route_name" validate:"required"`
 	RouteDescription string                     `json:"route_description" validate:"required"`
 	RouteAssignment  []RouteAssignmentRequestDTO `json:"route_assignment"`
+	Optimize         bool                       `json:"optimize"` // overwrite student_order with the optimized order
+	Schedules        []RouteScheduleRequestDTO  `json:"schedules"` // nil keeps the current schedules on update
 }
 
 type RouteAssignmentRequestDTO struct {
@@ -61,9 +69,139 @@ type RouteResponseByDriverDTO struct {
 	StudentLastName    string        `json:"student_last_name,omitempty" db:"student_last_name"`
 	StudentStatus		string			`json:"student_status,omitempty" db:"student_status"`
 	StudentAddress     string         `json:"student_address,omitempty" db:"student_address"`
-	StudentPickupPoint string         `json:"student_pickup_point,omitempty" db:"student_pickup_point"`
+	StudentPickupPoint geo.NullGeoPoint `json:"student_pickup_point" db:"student_pickup_point"`
 	ShuttleUUID        sql.NullString `db:"shuttle_uuid" json:"shuttle_uuid"`
 	ShuttleStatus      sql.NullString `db:"shuttle_status" json:"shuttle_status"`
 	SchoolName         string         `json:"school_name,omitempty" db:"school_name"`
-	SchoolPoint        string         `json:"school_point,omitempty" db:"school_point"`
+	SchoolPoint        geo.NullGeoPoint `json:"school_point" db:"school_point"`
+	StudentOrder       int            `json:"student_order" db:"student_order"`
+	ScheduleUUID       sql.NullString `db:"schedule_uuid" json:"schedule_uuid"`
+	TripDirection      sql.NullString `db:"trip_direction" json:"trip_direction"`
+	DepartureTime      sql.NullString `db:"departure_time" json:"departure_time"`
+}
+
+// One stop of a driver's itinerary; the school is a stop too
+type ItineraryStopDTO struct {
+	Sequence                   int      `json:"sequence"`
+	StopType                   string   `json:"stop_type"` // pickup, dropoff or school
+	StudentUUID                string   `json:"student_uuid,omitempty"`
+	StudentFirstName           string   `json:"student_first_name,omitempty"`
+	StudentLastName            string   `json:"student_last_name,omitempty"`
+	Address                    string   `json:"address,omitempty"`
+	Latitude                   *float64 `json:"latitude"`
+	Longitude                  *float64 `json:"longitude"`
+	ShuttleUUID                string   `json:"shuttle_uuid,omitempty"`
+	ShuttleStatus              string   `json:"shuttle_status,omitempty"`
+	DistanceFromPreviousMeters float64  `json:"distance_from_previous_meters"`
+	CumulativeDistanceMeters   float64  `json:"cumulative_distance_meters"`
+}
+
+// Ordered stops of one run of a route, as the driver drives it
+type DriverItineraryDTO struct {
+	RouteUUID           string             `json:"route_uuid"`
+	ScheduleUUID        string             `json:"schedule_uuid,omitempty"`
+	Direction           string             `json:"direction"`
+	DepartureTime       string             `json:"departure_time,omitempty"`
+	SchoolUUID          string             `json:"school_uuid"`
+	SchoolName          string             `json:"school_name"`
+	TotalStops          int                `json:"total_stops"`
+	TotalDistanceMeters float64            `json:"total_distance_meters"`
+	Stops               []ItineraryStopDTO `json:"stops"`
+}
+
+/////////// ROUTE SCHEDULES //////////////////////
+type RouteScheduleRequestDTO struct {
+	Direction     string `json:"direction"`      // to_school or to_home
+	DepartureTime string `json:"departure_time"` // HH:MM
+	ActiveDays    []int  `json:"active_days"`    // ISO weekdays, 1 = Monday; empty means Monday to Friday
+	TermStart     string `json:"term_start,omitempty"` // YYYY-MM-DD
+	TermEnd       string `json:"term_end,omitempty"`
+}
+
+type RouteScheduleResponseDTO struct {
+	ScheduleUUID  string  `json:"schedule_uuid"`
+	Direction     string  `json:"direction"`
+	DepartureTime string  `json:"departure_time"`
+	ActiveDays    []int64 `json:"active_days"`
+	TermStart     string  `json:"term_start,omitempty"`
+	TermEnd       string  `json:"term_end,omitempty"`
+}
+
+// One student stop of a driver's route with today's shuttle status, used for ETA
+type RouteStopDTO struct {
+	StudentUUID        string `db:"student_uuid"`
+	ParentUUID         string `db:"parent_uuid"`
+	StudentFirstName   string `db:"student_first_name"`
+	StudentPickupPoint geo.NullGeoPoint `db:"student_pickup_point"`
+	StudentOrder       int    `db:"student_order"`
+	ShuttleUUID        string `db:"shuttle_uuid"`
+	ShuttleStatus      string `db:"shuttle_status"`
+	SchoolPoint        geo.NullGeoPoint `db:"school_point"`
+}
+
+// A route assignment with its raw point columns, one row per student
+type RouteGeoStopDTO struct {
+	RouteNameUUID      string `db:"route_name_uuid"`
+	RouteName          string `db:"route_name"`
+	SchoolName         string `db:"school_name"`
+	SchoolPoint        geo.NullGeoPoint `db:"school_point"`
+	DriverUUID         string `db:"driver_uuid"`
+	StudentUUID        string `db:"student_uuid"`
+	StudentFirstName   string `db:"student_first_name"`
+	StudentLastName    string `db:"student_last_name"`
+	StudentAddress     string `db:"student_address"`
+	StudentPickupPoint geo.NullGeoPoint `db:"student_pickup_point"`
+	StudentOrder       int    `db:"student_order"`
+}
+
+// A recorded driver position during one trip of a route
+type RouteTrailPointDTO struct {
+	TripUUID   string         `db:"trip_uuid"`
+	DriverUUID string         `db:"driver_uuid"`
+	Direction  sql.NullString `db:"direction"`
+	Latitude   float64        `db:"latitude"`
+	Longitude  float64        `db:"longitude"`
+	RecordedAt time.Time      `db:"recorded_at"`
+}
+
+// Seats of a driver's vehicle against the students assigned to that driver
+type VehicleCapacityDTO struct {
+	DriverUUID       string `json:"driver_uuid"`
+	VehicleUUID      string `json:"vehicle_uuid"`
+	VehicleName      string `json:"vehicle_name"`
+	VehicleNumber    string `json:"vehicle_number"`
+	VehicleSeats     int    `json:"vehicle_seats"`
+	AssignedStudents int    `json:"assigned_students"`
+}
+
+/////////// ROUTE OPTIMIZATION //////////////////////
+type RouteOptimizeRequestDTO struct {
+	DriverUUID   uuid.UUID   `json:"driver_uuid" validate:"required"`
+	StudentUUIDs []uuid.UUID `json:"student_uuids" validate:"required,min=1"`
+}
+
+type RouteStudentPointDTO struct {
+	StudentUUID        string `db:"student_uuid"`
+	StudentFirstName   string `db:"student_first_name"`
+	StudentLastName    string `db:"student_last_name"`
+	StudentPickupPoint geo.NullGeoPoint `db:"student_pickup_point"`
+}
+
+type OptimizedStopDTO struct {
+	StudentUUID       string  `json:"student_uuid"`
+	StudentFirstName  string  `json:"student_first_name"`
+	StudentLastName   string  `json:"student_last_name"`
+	StudentOrder      int     `json:"student_order"`
+	Latitude          float64 `json:"latitude"`
+	Longitude         float64 `json:"longitude"`
+	LegDistanceMeters float64 `json:"leg_distance_meters"`
+}
+
+type RouteOptimizeResponseDTO struct {
+	DriverUUID               string             `json:"driver_uuid"`
+	Stops                    []OptimizedStopDTO `json:"stops"`
+	SchoolLegMeters          float64            `json:"school_leg_meters"`
+	TotalDistanceMeters      float64            `json:"total_distance_meters"`
+	EstimatedDurationSeconds int64              `json:"estimated_duration_seconds"`
+	UnroutableStudents       []string           `json:"unroutable_students,omitempty"` // no usable pickup point
 }
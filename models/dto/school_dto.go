@@ -1,12 +1,16 @@
 package dto
 
+import "shuttle/models/geo"
+
 type SchoolRequestDTO struct {
 	Name        string                 `json:"name" validate:"required,max=255"`
 	Address     string                 `json:"address" validate:"required,max=255"`
 	Contact     string                 `json:"contact" validate:"required,phone"`
 	Email       string                 `json:"email" validate:"required,email"`
 	Description string                 `json:"description" validate:"omitempty,max=255"`
-	Point       map[string]interface{} `json:"point"`
+	Point       *geo.GeoPoint          `json:"point" validate:"omitempty,geopoint"`
+	ApproachRadius int                 `json:"approach_radius" validate:"omitempty,min=50,max=5000"`
+	ArrivalRadius  int                 `json:"arrival_radius" validate:"omitempty,min=20,max=2000"`
 }
 
 type SchoolResponseDTO struct {
@@ -18,7 +22,9 @@ type SchoolResponseDTO struct {
 	Contact     string `json:"school_contact"`
 	Email       string `json:"school_email"`
 	Description string `json:"school_description,omitempty"`
-	Point       string `json:"school_point,omitempty"`
+	Point       geo.NullGeoPoint `json:"school_point"`
+	ApproachRadius int `json:"school_approach_radius,omitempty"`
+	ArrivalRadius  int `json:"school_arrival_radius,omitempty"`
 	CreatedAt   string `json:"created_at,omitempty"`
 	CreatedBy   string `json:"created_by,omitempty"`
 	UpdatedAt   string `json:"updated_at,omitempty"`
@@ -1,6 +1,10 @@
 package dto
 
-import "database/sql"
+import (
+	"database/sql"
+	"shuttle/models/geo"
+	"time"
+)
 
 type ShuttleRequest struct {
 	StudentUUID string `json:"student_uuid" validate:"required,uuid4"`
@@ -16,8 +20,10 @@ type ShuttleResponse struct {
 	SchoolUUID      string `db:"school_uuid" json:"school_uuid"`
 	SchoolName      string `db:"school_name" json:"school_name"`
 	ShuttleStatus   string `db:"shuttle_status" json:"shuttle_status"`
+	DriverUUID      string `db:"driver_uuid" json:"driver_uuid"`
 	CreatedAt       string `db:"created_at" json:"created_at"`
 	CurrentDate     string `db:"current_date" json:"current_date"`
+	ETA             *StudentETAResponse `db:"-" json:"eta,omitempty"`
 }
 
 type ShuttleAllResponse struct {
@@ -50,12 +56,149 @@ type ShuttleSpecResponse struct {
 	ShuttleUUID        string `db:"shuttle_uuid" json:"shuttle_uuid"`
 	StudentFirstName   string `db:"student_first_name" json:"student_first_name"`
 	StudentLastName    string `db:"student_last_name" json:"student_last_name"`
-	StudentPickupPoint string `db:"student_pickup_point" json:"student_pickup_point"` // Format JSON
+	StudentPickupPoint geo.NullGeoPoint `db:"student_pickup_point" json:"student_pickup_point"`
 	ParentUUID         string `db:"parent_uuid" json:"parent_uuid"`
 	SchoolUUID         string `db:"school_uuid" json:"school_uuid"`
 	SchoolName         string `db:"school_name" json:"school_name"`
-	SchoolPoint        string `db:"school_point" json:"school_point"` // Format JSON
+	SchoolPoint        geo.NullGeoPoint `db:"school_point" json:"school_point"`
 	ShuttleStatus      string `db:"shuttle_status" json:"shuttle_status"`
 	CreatedAt          string `db:"created_at" json:"created_at"`
 	CurrentDate        string `db:"current_date" json:"current_date"`
 }
+
+type ShuttleStatusUpdateRequest struct {
+	Status    string   `json:"status" validate:"required"`
+	Latitude  *float64 `json:"latitude" validate:"omitempty,latitude"`
+	Longitude *float64 `json:"longitude" validate:"omitempty,longitude"`
+}
+
+type ShuttleStatusChange struct {
+	ShuttleUUID string    `db:"shuttle_uuid" json:"shuttle_uuid"`
+	StudentUUID string    `db:"student_uuid" json:"student_uuid"`
+	ParentUUID  string    `db:"parent_uuid" json:"parent_uuid"`
+	OldStatus   string    `db:"old_status" json:"old_status"`
+	NewStatus   string    `db:"new_status" json:"new_status"`
+	ChangedAt   time.Time `db:"changed_at" json:"changed_at"`
+}
+
+// Everything the geofence engine needs to judge a position of one shuttle
+type ShuttleGeofenceContext struct {
+	ShuttleUUID        string `db:"shuttle_uuid"`
+	StudentUUID        string `db:"student_uuid"`
+	DriverUUID         string `db:"driver_uuid"`
+	ParentUUID         string `db:"parent_uuid"`
+	StudentFirstName   string `db:"student_first_name"`
+	ShuttleStatus      string `db:"shuttle_status"`
+	StudentPickupPoint geo.NullGeoPoint `db:"student_pickup_point"`
+	SchoolPoint        geo.NullGeoPoint `db:"school_point"`
+	ApproachRadius     int    `db:"school_approach_radius"`
+	ArrivalRadius      int    `db:"school_arrival_radius"`
+}
+
+type ShuttleTrailPointResponse struct {
+	UserUUID   string   `json:"user_uuid"`
+	Latitude   float64  `json:"latitude"`
+	Longitude  float64  `json:"longitude"`
+	Speed      *float64 `json:"speed,omitempty"`
+	Heading    *float64 `json:"heading,omitempty"`
+	RecordedAt string   `json:"recorded_at"`
+}
+
+type ShuttleTrailResponse struct {
+	ShuttleUUID string                      `json:"shuttle_uuid"`
+	From        string                      `json:"from"`
+	To          string                      `json:"to"`
+	TotalPoints int                         `json:"total_points"`
+	Points      []ShuttleTrailPointResponse `json:"points"`
+}
+
+
+type ShuttleStatusHistoryResponse struct {
+	FromStatus string   `json:"from_status,omitempty"`
+	ToStatus   string   `json:"to_status"`
+	ChangedBy  string   `json:"changed_by,omitempty"`
+	Latitude   *float64 `json:"latitude,omitempty"`
+	Longitude  *float64 `json:"longitude,omitempty"`
+	ChangedAt  string   `json:"changed_at"`
+}
+
+// Estimated arrival of the shuttle at the stop that matters to one student:
+// their pickup point, the school, or their drop-off point
+type StudentETAResponse struct {
+	StudentUUID        string  `json:"student_uuid"`
+	ShuttleUUID        string  `json:"shuttle_uuid"`
+	ParentUUID         string  `json:"-"`
+	StopType           string  `json:"stop_type"`
+	StopsBefore        int     `json:"stops_before"`
+	DistanceMeters     float64 `json:"distance_meters"`
+	ETASeconds         int64   `json:"eta_seconds"`
+	EstimatedArrival   string  `json:"estimated_arrival"`
+	AverageSpeed       float64 `json:"average_speed"`
+	PositionAgeSeconds int64   `json:"position_age_seconds"`
+
+	// Students still to be picked up also get the arrival at school
+	SchoolETASeconds       int64  `json:"school_eta_seconds,omitempty"`
+	SchoolEstimatedArrival string `json:"school_estimated_arrival,omitempty"`
+}
+
+// Sent as multipart form when a photo or signature is attached, JSON otherwise
+type StudentHandoverRequest struct {
+	Latitude  *float64 `json:"latitude" form:"latitude" validate:"omitempty,latitude"`
+	Longitude *float64 `json:"longitude" form:"longitude" validate:"omitempty,longitude"`
+	ProofType string   `json:"proof_type" form:"proof_type"`
+	Notes     string   `json:"notes" form:"notes" validate:"omitempty,max=255"`
+}
+
+type ShuttleHandoverContext struct {
+	ShuttleUUID      string `db:"shuttle_uuid"`
+	StudentUUID      string `db:"student_uuid"`
+	DriverUUID       string `db:"driver_uuid"`
+	ParentUUID       string `db:"parent_uuid"`
+	StudentFirstName string `db:"student_first_name"`
+}
+
+type StudentHandoverFilter struct {
+	StudentUUID string
+	ShuttleUUID string
+	DateFrom    string
+	DateTo      string
+}
+
+type StudentHandoverRecord struct {
+	HandoverUUID     string          `db:"handover_uuid"`
+	ShuttleUUID      string          `db:"shuttle_uuid"`
+	TripUUID         sql.NullString  `db:"trip_uuid"`
+	StudentUUID      string          `db:"student_uuid"`
+	StudentFirstName string          `db:"student_first_name"`
+	StudentLastName  string          `db:"student_last_name"`
+	DriverUUID       string          `db:"driver_uuid"`
+	DriverFirstName  string          `db:"driver_first_name"`
+	DriverLastName   string          `db:"driver_last_name"`
+	HandoverType     string          `db:"handover_type"`
+	Latitude         sql.NullFloat64 `db:"latitude"`
+	Longitude        sql.NullFloat64 `db:"longitude"`
+	ProofType        sql.NullString  `db:"proof_type"`
+	ProofFile        sql.NullString  `db:"proof_file"`
+	Notes            sql.NullString  `db:"notes"`
+	ConfirmedAt      time.Time       `db:"confirmed_at"`
+}
+
+type StudentHandoverResponse struct {
+	HandoverUUID     string   `json:"handover_uuid"`
+	ShuttleUUID      string   `json:"shuttle_uuid"`
+	TripUUID         string   `json:"trip_uuid,omitempty"`
+	StudentUUID      string   `json:"student_uuid"`
+	StudentFirstName string   `json:"student_first_name,omitempty"`
+	StudentLastName  string   `json:"student_last_name,omitempty"`
+	DriverUUID       string   `json:"driver_uuid"`
+	DriverFirstName  string   `json:"driver_first_name,omitempty"`
+	DriverLastName   string   `json:"driver_last_name,omitempty"`
+	HandoverType     string   `json:"handover_type"`
+	Latitude         *float64 `json:"latitude,omitempty"`
+	Longitude        *float64 `json:"longitude,omitempty"`
+	ProofType        string   `json:"proof_type,omitempty"`
+	ProofURL         string   `json:"proof_url,omitempty"`
+	Notes            string   `json:"notes,omitempty"`
+	ConfirmedAt      string   `json:"confirmed_at"`
+	ParentUUID       string   `json:"-"`
+}
@@ -1,6 +1,6 @@
 package dto
 
-import ()
+import "shuttle/models/geo"
 
 type StudentResponseDTO struct {
 	UUID           string `json:"student_uuid"`
@@ -13,7 +13,7 @@ type StudentResponseDTO struct {
 	SchoolUUID     string `json:"school_uuid"`
 	SchoolName     string `json:"school_name,omitempty"`
 	StudentAddress string `json:"student_address"`
-	PickupPoint    string `json:"student_pickup_point"`
+	PickupPoint    geo.NullGeoPoint `json:"student_pickup_point"`
 	ShuttleStatus  string `json:"shuttle_status,omitempty"`
 	CreatedAt      string `json:"created_at,omitempty"`
 	CreatedBy      string `json:"created_by,omitempty"`
@@ -28,7 +28,7 @@ type StudentRequestDTO struct {
 	StudentGrade       string             `json:"student_grade" validate:"required"`
 	StudentStatus      string             `json:"student_status"`
 	StudentAddress     string             `json:"student_address" validate:"required"` // Menambahkan field student_address
-	StudentPickupPoint map[string]float64 `json:"student_pickup_point" validate:"required"`
+	StudentPickupPoint *geo.GeoPoint      `json:"student_pickup_point" validate:"required,geopoint"`
 }
 
 type StudentRequestByParentDTO struct {
@@ -36,7 +36,7 @@ type StudentRequestByParentDTO struct {
 	StudentLastName    string             `json:"student_last_name" validate:"required"`
 	StudentGender      Gender             `json:"student_gender" validate:"required"`
 	StudentAddress     string             `json:"student_address" validate:"required"` // Menambahkan field student_address
-	StudentPickupPoint map[string]float64 `json:"student_pickup_point" validate:"required"`
+	StudentPickupPoint *geo.GeoPoint      `json:"student_pickup_point" validate:"required,geopoint"`
 	StudentStatus      string             `json:"student_status"`
 }
 
@@ -44,6 +44,49 @@ type StudentStatusRequestByParentDTO struct {
 	StudentStatus string `json:"student_status"`
 }
 
+type StudentAbsenceRequestDTO struct {
+	StartDate string `json:"start_date" validate:"required"` // YYYY-MM-DD
+	EndDate   string `json:"end_date" validate:"required"`   // YYYY-MM-DD, inclusive
+	Reason    string `json:"reason" validate:"max=255"`
+}
+
+type StudentAbsenceResponseDTO struct {
+	AbsenceUUID string `json:"absence_uuid" db:"absence_uuid"`
+	StudentUUID string `json:"student_uuid" db:"student_uuid"`
+	StartDate   string `json:"start_date" db:"start_date"`
+	EndDate     string `json:"end_date" db:"end_date"`
+	Reason      string `json:"reason,omitempty" db:"reason"`
+	CreatedAt   string `json:"created_at" db:"created_at"`
+}
+
+// A student with the distance from their pickup point to the nearest searched point
+type StudentDistanceDTO struct {
+	StudentUUID        string           `db:"student_uuid"`
+	StudentFirstName   string           `db:"student_first_name"`
+	StudentLastName    string           `db:"student_last_name"`
+	StudentAddress     string           `db:"student_address"`
+	StudentPickupPoint geo.NullGeoPoint `db:"student_pickup_point"`
+	DistanceMeters     float64          `db:"distance_meters"`
+	NearestIndex       int              `db:"nearest_index"`
+}
+
+type NearbyStudentDTO struct {
+	StudentUUID            string           `json:"student_uuid"`
+	StudentFirstName       string           `json:"student_first_name"`
+	StudentLastName        string           `json:"student_last_name"`
+	StudentAddress         string           `json:"student_address"`
+	StudentPickupPoint     geo.NullGeoPoint `json:"student_pickup_point"`
+	DistanceMeters         float64          `json:"distance_meters"`
+	NearestStopStudentUUID string           `json:"nearest_stop_student_uuid,omitempty"` // route candidates only
+}
+
+type NearbyStudentsResponseDTO struct {
+	Center       *geo.GeoPoint      `json:"center,omitempty"`
+	RouteUUID    string             `json:"route_uuid,omitempty"`
+	RadiusMeters float64            `json:"radius_meters"`
+	Students     []NearbyStudentDTO `json:"students"`
+}
+
 type SchoolStudentParentRequestDTO struct {
 	Student StudentRequestDTO `json:"student" validate:"required"`
 	Parent  UserRequestsDTO   `json:"parent" validate:"required"`
@@ -64,7 +107,7 @@ type SchoolStudentParentResponseDTO struct {
 	StudentGrade     string `json:"student_grade"`
 	StudentStatus    string `json:"student_status"`
 	Address          string `json:"student_address"`
-	PickupPoint      string `json:"student_pickup_point"` // Menambahkan field pickup_point
+	PickupPoint      geo.NullGeoPoint `json:"student_pickup_point"` // Menambahkan field pickup_point
 	ShuttleStatus    string `json:"shuttle_status"`       // Menambahkan field pickup_point
 	CreatedAt        string `json:"created_at,omitempty"`
 	CreatedBy        string `json:"created_by,omitempty"`
@@ -0,0 +1,55 @@
+package dto
+
+import (
+	"database/sql"
+	"shuttle/models/geo"
+)
+
+// A route and driver that should run on a given day, with the schedule that applies
+type TripCandidateDTO struct {
+	RouteNameUUID string         `db:"route_name_uuid"`
+	DriverUUID    string         `db:"driver_uuid"`
+	SchoolUUID    string         `db:"school_uuid"`
+	ScheduleUUID  sql.NullString `db:"schedule_uuid"`
+	Direction     sql.NullString `db:"direction"`
+	DepartureTime sql.NullString `db:"departure_time"`
+}
+
+type TripStudentDTO struct {
+	StudentUUID  string `db:"student_uuid"`
+	StudentOrder int    `db:"student_order"`
+}
+
+type TripGenerateRequestDTO struct {
+	Date string `json:"date"` // YYYY-MM-DD, defaults to today
+}
+
+type TripGenerateResponseDTO struct {
+	Date         string `json:"date"`
+	TripsCreated int    `json:"trips_created"`
+}
+
+type TripManifestResponseDTO struct {
+	StudentUUID        string `json:"student_uuid" db:"student_uuid"`
+	StudentFirstName   string `json:"student_first_name" db:"student_first_name"`
+	StudentLastName    string `json:"student_last_name" db:"student_last_name"`
+	StudentPickupPoint geo.NullGeoPoint `json:"student_pickup_point" db:"student_pickup_point"`
+	StopOrder          int    `json:"stop_order" db:"stop_order"`
+	ShuttleUUID        string `json:"shuttle_uuid,omitempty" db:"shuttle_uuid"`
+	ShuttleStatus      string `json:"shuttle_status,omitempty" db:"shuttle_status"`
+}
+
+type TripResponseDTO struct {
+	TripUUID      string                    `json:"trip_uuid" db:"trip_uuid"`
+	RouteNameUUID string                    `json:"route_name_uuid" db:"route_name_uuid"`
+	RouteName     string                    `json:"route_name" db:"route_name"`
+	ScheduleUUID  string                    `json:"schedule_uuid,omitempty" db:"schedule_uuid"`
+	DriverUUID    string                    `json:"driver_uuid" db:"driver_uuid"`
+	TripDate      string                    `json:"trip_date" db:"trip_date"`
+	Direction     string                    `json:"direction,omitempty" db:"direction"`
+	DepartureTime string                    `json:"departure_time,omitempty" db:"departure_time"`
+	Status        string                    `json:"status" db:"status"`
+	StartedAt     string                    `json:"started_at,omitempty" db:"started_at"`
+	FinishedAt    string                    `json:"finished_at,omitempty" db:"finished_at"`
+	Manifest      []TripManifestResponseDTO `json:"manifest" db:"-"`
+}
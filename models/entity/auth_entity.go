@@ -1,6 +1,7 @@
 package entity
 
 import (
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,19 +16,62 @@ type UserDataOnLogin struct {
 }
 
 type RefreshToken struct {
-	ID           int64      `db:"id"`
-	UserUUID     uuid.UUID  `db:"user_uuid"`
-	RefreshToken string     `db:"refresh_token"`
-	IssuedAt     time.Time  `db:"issued_at"`
-	ExpiredAt    time.Time  `db:"expired_at"`
-	Revoked      bool       `db:"is_revoked"`
-	LastUsedAt   *time.Time `db:"last_used_at"`
+	ID                int64          `db:"id"`
+	UserUUID          uuid.UUID      `db:"user_uuid"`
+	JTI               uuid.UUID      `db:"jti"`
+	FamilyID          uuid.UUID      `db:"family_id"`
+	IssuedAt          time.Time      `db:"issued_at"`
+	ExpiredAt         time.Time      `db:"expired_at"`
+	AbsoluteExpiredAt time.Time      `db:"absolute_expired_at"`
+	Revoked           bool           `db:"is_revoked"`
+	LastUsedAt        *time.Time     `db:"last_used_at"`
+	RotatedAt         *time.Time     `db:"rotated_at"`
+	ReplacedBy        uuid.NullUUID  `db:"replaced_by"`
+	RevokedAt         *time.Time     `db:"revoked_at"`
+	RevokedReason     sql.NullString `db:"revoked_reason"`
 }
 
-type FCMToken struct {
-	ID          int64     `db:"id"`
-	UserUUID    uuid.UUID `db:"user_uuid"`
-	DeviceToken string    `db:"device_token"`
-	CreatedAt   time.Time `db:"created_at"`
-	UpdateAt    time.Time `db:"updated_at"`
+// One signed-in device; its refresh token family shares the session UUID
+type UserSession struct {
+	SessionID     int64          `db:"session_id"`
+	SessionUUID   uuid.UUID      `db:"session_uuid"`
+	UserUUID      uuid.UUID      `db:"user_uuid"`
+	DeviceName    sql.NullString `db:"device_name"`
+	Platform      sql.NullString `db:"platform"`
+	IPAddress     sql.NullString `db:"ip_address"`
+	UserAgent     sql.NullString `db:"user_agent"`
+	FCMToken      sql.NullString `db:"fcm_token"`
+	CreatedAt     time.Time      `db:"created_at"`
+	LastUsedAt    time.Time      `db:"last_used_at"`
+	ExpiresAt     time.Time      `db:"expires_at"`
+	RevokedAt     *time.Time     `db:"revoked_at"`
+	RevokedReason sql.NullString `db:"revoked_reason"`
+}
+
+type RevokedToken struct {
+	JTI       uuid.UUID `db:"jti"`
+	UserUUID  uuid.UUID `db:"user_uuid"`
+	ExpiresAt time.Time `db:"expires_at"`
+	RevokedAt time.Time `db:"revoked_at"`
+}
+
+type LoginAttempt struct {
+	AttemptID     int64          `db:"attempt_id"`
+	Email         string         `db:"email"`
+	UserUUID      uuid.NullUUID  `db:"user_uuid"`
+	IPAddress     string         `db:"ip_address"`
+	UserAgent     sql.NullString `db:"user_agent"`
+	Succeeded     bool           `db:"succeeded"`
+	FailureReason sql.NullString `db:"failure_reason"`
+	AttemptedAt   time.Time      `db:"attempted_at"`
+}
+
+type PasswordResetToken struct {
+	TokenID     int64          `db:"token_id"`
+	UserUUID    uuid.UUID      `db:"user_uuid"`
+	TokenHash   string         `db:"token_hash"`
+	RequestedIP sql.NullString `db:"requested_ip"`
+	CreatedAt   time.Time      `db:"created_at"`
+	ExpiresAt   time.Time      `db:"expires_at"`
+	UsedAt      *time.Time     `db:"used_at"`
 }
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type RouteAssignment struct {
@@ -44,3 +45,17 @@ type Routes struct {
 	DeletedAt        sql.NullTime   `db:"deleted_at"`
 	DeletedBy        sql.NullString `db:"deleted_by"`
 }
+
+type RouteSchedule struct {
+	ScheduleID    int64          `db:"schedule_id"`
+	ScheduleUUID  uuid.UUID      `db:"schedule_uuid"`
+	RouteNameUUID uuid.UUID      `db:"route_name_uuid"`
+	SchoolUUID    uuid.UUID      `db:"school_uuid"`
+	Direction     string         `db:"direction"`
+	DepartureTime string         `db:"departure_time"`
+	ActiveDays    pq.Int64Array  `db:"active_days"`
+	TermStart     sql.NullTime   `db:"term_start"`
+	TermEnd       sql.NullTime   `db:"term_end"`
+	CreatedAt     sql.NullTime   `db:"created_at"`
+	CreatedBy     sql.NullString `db:"created_by"`
+}
@@ -2,6 +2,7 @@ package entity
 
 import (
 	"database/sql"
+	"shuttle/models/geo"
 
 	"github.com/google/uuid"
 )
@@ -14,7 +15,10 @@ type School struct {
 	Contact     string         `db:"school_contact"`
 	Email       string         `db:"school_email"`
 	Description string         `db:"school_description"`
-	Point       sql.NullString `db:"school_point"`
+	Point       geo.NullGeoPoint `db:"school_point"`
+	// Geofence radii in meters: pickup alert distance and school arrival distance
+	ApproachRadius int `db:"school_approach_radius"`
+	ArrivalRadius  int `db:"school_arrival_radius"`
 	CreatedAt   sql.NullTime   `db:"created_at"`
 	CreatedBy   sql.NullString `db:"created_by"`
 	UpdatedAt   sql.NullTime   `db:"updated_at"`
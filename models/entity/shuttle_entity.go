@@ -2,6 +2,8 @@ package entity
 
 import (
 	"database/sql"
+	"time"
+
 	"github.com/google/uuid"
 )
 
@@ -10,6 +12,7 @@ type Shuttle struct {
 	ShuttleUUID  uuid.UUID      `db:"shuttle_uuid"`
 	StudentUUID  uuid.UUID      `db:"student_uuid"`
 	DriverUUID   uuid.UUID      `db:"driver_uuid"`
+	TripUUID     uuid.NullUUID  `db:"trip_uuid"`
 	Status       string         `db:"status"`
 	CreatedAt    sql.NullTime   `db:"created_at"`
 	CreatedBy    sql.NullString `db:"created_by"`
@@ -18,3 +21,42 @@ type Shuttle struct {
 	DeletedAt    sql.NullTime   `db:"deleted_at"`
 	DeletedBy    sql.NullString `db:"deleted_by"`
 }
+
+type ShuttleLocation struct {
+	LocationID  int64           `db:"location_id"`
+	ShuttleUUID uuid.UUID       `db:"shuttle_uuid"`
+	UserUUID    uuid.UUID       `db:"user_uuid"`
+	Latitude    float64         `db:"latitude"`
+	Longitude   float64         `db:"longitude"`
+	Speed       sql.NullFloat64 `db:"speed"`
+	Heading     sql.NullFloat64 `db:"heading"`
+	RecordedAt  time.Time       `db:"recorded_at"`
+	CreatedAt   sql.NullTime    `db:"created_at"`
+}
+
+
+type ShuttleStatusHistory struct {
+	HistoryID   int64           `db:"history_id"`
+	ShuttleUUID uuid.UUID       `db:"shuttle_uuid"`
+	FromStatus  sql.NullString  `db:"from_status"`
+	ToStatus    string          `db:"to_status"`
+	ChangedBy   uuid.NullUUID   `db:"changed_by"`
+	Latitude    sql.NullFloat64 `db:"latitude"`
+	Longitude   sql.NullFloat64 `db:"longitude"`
+	ChangedAt   time.Time       `db:"changed_at"`
+}
+type StudentHandover struct {
+	HandoverID   int64           `db:"handover_id"`
+	HandoverUUID uuid.UUID       `db:"handover_uuid"`
+	ShuttleUUID  uuid.UUID       `db:"shuttle_uuid"`
+	TripUUID     uuid.NullUUID   `db:"trip_uuid"`
+	StudentUUID  uuid.UUID       `db:"student_uuid"`
+	DriverUUID   uuid.UUID       `db:"driver_uuid"`
+	HandoverType string          `db:"handover_type"`
+	Latitude     sql.NullFloat64 `db:"latitude"`
+	Longitude    sql.NullFloat64 `db:"longitude"`
+	ProofType    sql.NullString  `db:"proof_type"`
+	ProofFile    sql.NullString  `db:"proof_file"`
+	Notes        sql.NullString  `db:"notes"`
+	ConfirmedAt  time.Time       `db:"confirmed_at"`
+}
@@ -2,6 +2,7 @@ package entity
 
 import (
 	"database/sql"
+	"shuttle/models/geo"
 
 	"github.com/google/uuid"
 )
@@ -33,7 +34,7 @@ type Student struct {
 	LastName           string         `db:"last_name"`
 	Grade              string         `db:"student_grade"`
 	StudentAddress     sql.NullString `db:"student_address"` // Menambahkan field student_address
-	StudentPickupPoint sql.NullString `db:"student_pickup_point"`
+	StudentPickupPoint geo.NullGeoPoint `db:"student_pickup_point"`
 	Gender             string         `db:"student_gender"`
 	Status             string         `db:"student_status"`
 	ParentID           sql.NullInt64  `db:"parent_id"`
@@ -51,3 +52,17 @@ type Student struct {
 	DeletedAt          sql.NullTime   `db:"deleted_at"`
 	DeletedBy          sql.NullString `db:"deleted_by"`
 }
+
+type StudentAbsence struct {
+	AbsenceID   int64          `db:"absence_id"`
+	AbsenceUUID uuid.UUID      `db:"absence_uuid"`
+	StudentUUID uuid.UUID      `db:"student_uuid"`
+	ParentUUID  uuid.UUID      `db:"parent_uuid"`
+	StartDate   string         `db:"start_date"`
+	EndDate     string         `db:"end_date"`
+	Reason      sql.NullString `db:"reason"`
+	CreatedAt   sql.NullTime   `db:"created_at"`
+	CreatedBy   sql.NullString `db:"created_by"`
+	CancelledAt sql.NullTime   `db:"cancelled_at"`
+	CancelledBy sql.NullString `db:"cancelled_by"`
+}
@@ -0,0 +1,33 @@
+package entity
+
+import (
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+type Trip struct {
+	TripID        int64          `db:"trip_id"`
+	TripUUID      uuid.UUID      `db:"trip_uuid"`
+	RouteNameUUID uuid.UUID      `db:"route_name_uuid"`
+	ScheduleUUID  uuid.NullUUID  `db:"schedule_uuid"`
+	SchoolUUID    uuid.UUID      `db:"school_uuid"`
+	DriverUUID    uuid.UUID      `db:"driver_uuid"`
+	TripDate      string         `db:"trip_date"`
+	Direction     sql.NullString `db:"direction"`
+	DepartureTime sql.NullString `db:"departure_time"`
+	Status        string         `db:"status"`
+	StartedAt     sql.NullTime   `db:"started_at"`
+	FinishedAt    sql.NullTime   `db:"finished_at"`
+	CreatedAt     sql.NullTime   `db:"created_at"`
+	CreatedBy     sql.NullString `db:"created_by"`
+}
+
+type TripManifest struct {
+	ManifestID  int64         `db:"manifest_id"`
+	TripUUID    uuid.UUID     `db:"trip_uuid"`
+	StudentUUID uuid.UUID     `db:"student_uuid"`
+	StopOrder   int           `db:"stop_order"`
+	ShuttleUUID uuid.NullUUID `db:"shuttle_uuid"`
+	CreatedAt   sql.NullTime  `db:"created_at"`
+}
@@ -0,0 +1,156 @@
+package geo
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A WGS84 coordinate, stored in Postgres as {"latitude": .., "longitude": ..} JSON
+type GeoPoint struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+var ErrIncompletePoint = errors.New("point must have both latitude and longitude")
+
+// Both coordinates inside their range; 0,0 is treated as a point that was never set
+func (p GeoPoint) Validate() error {
+	if p.Latitude < -90 || p.Latitude > 90 {
+		return fmt.Errorf("latitude must be between -90 and 90, got %v", p.Latitude)
+	}
+	if p.Longitude < -180 || p.Longitude > 180 {
+		return fmt.Errorf("longitude must be between -180 and 180, got %v", p.Longitude)
+	}
+	if p.Latitude == 0 && p.Longitude == 0 {
+		return ErrIncompletePoint
+	}
+	return nil
+}
+
+// Both keys are required. Numbers sent as strings are accepted since older
+// clients and rows stored them that way.
+func (p *GeoPoint) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Latitude  json.RawMessage `json:"latitude"`
+		Longitude json.RawMessage `json:"longitude"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if len(raw.Latitude) == 0 || len(raw.Longitude) == 0 {
+		return ErrIncompletePoint
+	}
+
+	latitude, err := parseCoordinate(raw.Latitude)
+	if err != nil {
+		return fmt.Errorf("invalid latitude: %w", err)
+	}
+	longitude, err := parseCoordinate(raw.Longitude)
+	if err != nil {
+		return fmt.Errorf("invalid longitude: %w", err)
+	}
+
+	p.Latitude, p.Longitude = latitude, longitude
+	return nil
+}
+
+func parseCoordinate(raw json.RawMessage) (float64, error) {
+	value := strings.Trim(strings.TrimSpace(string(raw)), `"`)
+	if value == "" || value == "null" {
+		return 0, ErrIncompletePoint
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+func (p GeoPoint) Value() (driver.Value, error) {
+	encoded, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(encoded), nil
+}
+
+func (p *GeoPoint) Scan(src interface{}) error {
+	switch value := src.(type) {
+	case []byte:
+		return json.Unmarshal(value, p)
+	case string:
+		return json.Unmarshal([]byte(value), p)
+	case nil:
+		return ErrIncompletePoint
+	default:
+		return fmt.Errorf("cannot scan %T into GeoPoint", src)
+	}
+}
+
+// A point column that may be empty. Rows holding NULL, '{}' or an unusable
+// point scan as not valid instead of failing the whole query.
+type NullGeoPoint struct {
+	GeoPoint
+	Valid bool
+}
+
+func NewNullGeoPoint(point *GeoPoint) NullGeoPoint {
+	if point == nil {
+		return NullGeoPoint{}
+	}
+	return NullGeoPoint{GeoPoint: *point, Valid: true}
+}
+
+// The point, or nil when there is none
+func (p NullGeoPoint) Ptr() *GeoPoint {
+	if !p.Valid {
+		return nil
+	}
+	point := p.GeoPoint
+	return &point
+}
+
+func (p NullGeoPoint) Coordinates() (latitude, longitude float64, ok bool) {
+	return p.Latitude, p.Longitude, p.Valid
+}
+
+func (p *NullGeoPoint) Scan(src interface{}) error {
+	p.GeoPoint, p.Valid = GeoPoint{}, false
+	if src == nil {
+		return nil
+	}
+
+	var point GeoPoint
+	if err := point.Scan(src); err != nil || point.Validate() != nil {
+		return nil
+	}
+
+	p.GeoPoint, p.Valid = point, true
+	return nil
+}
+
+func (p NullGeoPoint) Value() (driver.Value, error) {
+	if !p.Valid {
+		return nil, nil
+	}
+	return p.GeoPoint.Value()
+}
+
+func (p NullGeoPoint) MarshalJSON() ([]byte, error) {
+	if !p.Valid {
+		return []byte("null"), nil
+	}
+	return json.Marshal(p.GeoPoint)
+}
+
+func (p *NullGeoPoint) UnmarshalJSON(data []byte) error {
+	if strings.TrimSpace(string(data)) == "null" {
+		p.GeoPoint, p.Valid = GeoPoint{}, false
+		return nil
+	}
+	if err := p.GeoPoint.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	p.Valid = true
+	return nil
+}
@@ -1,21 +1,31 @@
 package repositories
 
 import (
-	"context"
+	"shuttle/models/dto"
 	"shuttle/models/entity"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 )
 
 type AuthRepositoryInterface interface {
 	Login(email string) (entity.UserDataOnLogin, error)
 	UpdatePassword(userUUID, newPassword string) error
-	CheckRefreshTokenData(userUUID, token string) (entity.RefreshToken, error)
-	DeleteRefreshToken(ctx context.Context, userUUID string) error
 	UpdateUserStatus(userUUID, status string, lastActive time.Time) error
-	UpdateRefreshToken(userUUID, newRefreshToken string) error
-	SaveDeviceToken(tokenData entity.FCMToken) error
+	BeginTransaction() (*sqlx.Tx, error)
+	SaveRefreshToken(tx *sqlx.Tx, refreshToken entity.RefreshToken) error
+	FetchRefreshTokenForUpdate(tx *sqlx.Tx, jti string) (entity.RefreshToken, error)
+	MarkRefreshTokenRotated(tx *sqlx.Tx, jti, replacedBy uuid.UUID, at time.Time) error
+	RevokeRefreshTokenFamily(tx *sqlx.Tx, familyID uuid.UUID, reason string, at time.Time) error
+	RevokeUserRefreshTokens(tx *sqlx.Tx, userUUID uuid.UUID, reason string, at time.Time) error
+
+	SaveUserSession(tx *sqlx.Tx, session entity.UserSession) error
+	FetchActiveSessions(userUUID string) ([]dto.UserSessionResponseDTO, error)
+	TouchSession(tx *sqlx.Tx, sessionUUID uuid.UUID, at time.Time) error
+	RevokeSession(tx *sqlx.Tx, sessionUUID, userUUID uuid.UUID, reason string, at time.Time) (bool, error)
+	RevokeUserSessions(tx *sqlx.Tx, userUUID uuid.UUID, reason string, at time.Time) ([]uuid.UUID, error)
+	SetSessionFCMToken(sessionUUID uuid.UUID, fcmToken string) (bool, error)
 }
 
 type authRepository struct {
@@ -61,91 +71,183 @@ func (r *authRepository) UpdatePassword(userUUID, newPassword string) error {
 	return nil
 }
 
-func (r *authRepository) CheckRefreshTokenData(userUUID, token string) (entity.RefreshToken, error) {
+func (r *authRepository) UpdateUserStatus(userUUID, status string, lastActive time.Time) error {
 	query := `
-		SELECT refresh_token, expired_at, is_revoked, last_used_at
-		FROM refresh_tokens 
-		WHERE user_uuid = $1 AND refresh_token = $2
+		UPDATE users
+		SET user_status = $1, user_last_active = $2
+		WHERE user_uuid = $3
 	`
 
-	var tokenData entity.RefreshToken
-	err := r.DB.Get(&tokenData, query, userUUID, token)
+	_, err := r.DB.Exec(query, status, lastActive, userUUID)
 	if err != nil {
-		return tokenData, err
+		return err
 	}
 
-	return tokenData, nil
+	return nil
+}
+
+func (r *authRepository) BeginTransaction() (*sqlx.Tx, error) {
+	return r.DB.Beginx()
 }
 
-func SaveRefreshToken(db sqlx.DB, refreshToken entity.RefreshToken) error {
+func (r *authRepository) SaveRefreshToken(tx *sqlx.Tx, refreshToken entity.RefreshToken) error {
 	query := `
-		INSERT INTO refresh_tokens (id, user_uuid, refresh_token, expired_at)
-		VALUES ($1, $2, $3, $4)
-		ON CONFLICT (user_uuid)
-		DO UPDATE SET refresh_token = $3, issued_at = CURRENT_TIMESTAMP, expired_at = $4, is_revoked = false 
+		INSERT INTO refresh_tokens (id, user_uuid, jti, family_id, issued_at, expired_at, absolute_expired_at)
+		VALUES (:id, :user_uuid, :jti, :family_id, :issued_at, :expired_at, :absolute_expired_at)`
+
+	_, err := tx.NamedExec(query, refreshToken)
+	return err
+}
+
+// Lock the token so two refreshes with it can't both rotate it
+func (r *authRepository) FetchRefreshTokenForUpdate(tx *sqlx.Tx, jti string) (entity.RefreshToken, error) {
+	query := `
+		SELECT id, user_uuid, jti, family_id, issued_at, expired_at, absolute_expired_at,
+			COALESCE(is_revoked, false) AS is_revoked, last_used_at, rotated_at, replaced_by, revoked_at, revoked_reason
+		FROM refresh_tokens
+		WHERE jti = $1
+		FOR UPDATE
 	`
-	_, err := db.Exec(query, refreshToken.ID, refreshToken.UserUUID, refreshToken.RefreshToken, refreshToken.ExpiredAt)
-	if err != nil {
-		return err
+
+	var refreshToken entity.RefreshToken
+	if err := tx.Get(&refreshToken, query, jti); err != nil {
+		return entity.RefreshToken{}, err
 	}
 
-	return nil
+	return refreshToken, nil
 }
 
-func (r *authRepository) DeleteRefreshToken(ctx context.Context, userUUID string) error {
+func (r *authRepository) MarkRefreshTokenRotated(tx *sqlx.Tx, jti, replacedBy uuid.UUID, at time.Time) error {
 	query := `
-		DELETE FROM refresh_tokens
-		WHERE user_uuid = $1
+		UPDATE refresh_tokens
+		SET rotated_at = $1, last_used_at = $1, replaced_by = $2
+		WHERE jti = $3
 	`
 
-	_, err := r.DB.ExecContext(ctx, query, userUUID)
-	if err != nil {
-		return err
+	_, err := tx.Exec(query, at, replacedBy, jti)
+	return err
+}
+
+func (r *authRepository) RevokeRefreshTokenFamily(tx *sqlx.Tx, familyID uuid.UUID, reason string, at time.Time) error {
+	query := `
+		UPDATE refresh_tokens
+		SET is_revoked = true, revoked_at = $1, revoked_reason = $2
+		WHERE family_id = $3 AND COALESCE(is_revoked, false) = false
+	`
+
+	_, err := tx.Exec(query, at, reason, familyID)
+	return err
+}
+
+func (r *authRepository) RevokeUserRefreshTokens(tx *sqlx.Tx, userUUID uuid.UUID, reason string, at time.Time) error {
+	query := `
+		UPDATE refresh_tokens
+		SET is_revoked = true, revoked_at = $1, revoked_reason = $2
+		WHERE user_uuid = $3 AND COALESCE(is_revoked, false) = false
+	`
+
+	_, err := tx.Exec(query, at, reason, userUUID)
+	return err
+}
+
+func (r *authRepository) SaveUserSession(tx *sqlx.Tx, session entity.UserSession) error {
+	query := `
+		INSERT INTO user_sessions (session_id, session_uuid, user_uuid, device_name, platform, ip_address, user_agent, created_at, last_used_at, expires_at)
+		VALUES (:session_id, :session_uuid, :user_uuid, :device_name, :platform, :ip_address, :user_agent, :created_at, :last_used_at, :expires_at)`
+
+	_, err := tx.NamedExec(query, session)
+	return err
+}
+
+func (r *authRepository) FetchActiveSessions(userUUID string) ([]dto.UserSessionResponseDTO, error) {
+	query := `
+		SELECT
+			session_uuid,
+			COALESCE(device_name, '') AS device_name,
+			COALESCE(platform, '') AS platform,
+			COALESCE(ip_address, '') AS ip_address,
+			COALESCE(user_agent, '') AS user_agent,
+			fcm_token IS NOT NULL AS has_push_token,
+			TO_CHAR(created_at, 'YYYY-MM-DD HH24:MI:SS') AS created_at,
+			TO_CHAR(last_used_at, 'YYYY-MM-DD HH24:MI:SS') AS last_used_at,
+			TO_CHAR(expires_at, 'YYYY-MM-DD HH24:MI:SS') AS expires_at
+		FROM user_sessions
+		WHERE user_uuid = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC
+	`
+
+	var sessions []dto.UserSessionResponseDTO
+	if err := r.DB.Select(&sessions, query, userUUID); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return sessions, nil
 }
 
-func (r *authRepository) UpdateUserStatus(userUUID, status string, lastActive time.Time) error {
+func (r *authRepository) TouchSession(tx *sqlx.Tx, sessionUUID uuid.UUID, at time.Time) error {
+	_, err := tx.Exec(`UPDATE user_sessions SET last_used_at = $1 WHERE session_uuid = $2`, at, sessionUUID)
+	return err
+}
+
+// False when the user has no such active session
+func (r *authRepository) RevokeSession(tx *sqlx.Tx, sessionUUID, userUUID uuid.UUID, reason string, at time.Time) (bool, error) {
 	query := `
-		UPDATE users
-		SET user_status = $1, user_last_active = $2
-		WHERE user_uuid = $3
+		UPDATE user_sessions
+		SET revoked_at = $1, revoked_reason = $2, fcm_token = NULL
+		WHERE session_uuid = $3 AND user_uuid = $4 AND revoked_at IS NULL
 	`
 
-	_, err := r.DB.Exec(query, status, lastActive, userUUID)
+	result, err := tx.Exec(query, at, reason, sessionUUID, userUUID)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	return nil
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
 }
 
-func (r *authRepository) UpdateRefreshToken(userUUID, newRefreshToken string) error {
-	queryUpdate := `
-		UPDATE refresh_tokens
-		SET refresh_token = $2, last_used_at = NOW()
-		WHERE user_uuid = $1
+func (r *authRepository) RevokeUserSessions(tx *sqlx.Tx, userUUID uuid.UUID, reason string, at time.Time) ([]uuid.UUID, error) {
+	query := `
+		UPDATE user_sessions
+		SET revoked_at = $1, revoked_reason = $2, fcm_token = NULL
+		WHERE user_uuid = $3 AND revoked_at IS NULL
+		RETURNING session_uuid
 	`
-	_, err := r.DB.Exec(queryUpdate, userUUID, newRefreshToken)
-	if err != nil {
-		return err
+
+	var sessionUUIDs []uuid.UUID
+	if err := tx.Select(&sessionUUIDs, query, at, reason, userUUID); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return sessionUUIDs, nil
 }
 
-func (r *authRepository) SaveDeviceToken(tokendata entity.FCMToken) error {
+// A push token belongs to one device, so any other session holding it, e.g.
+// another account signed in earlier on the same phone, gives it up
+func (r *authRepository) SetSessionFCMToken(sessionUUID uuid.UUID, fcmToken string) (bool, error) {
 	query := `
-		INSERT INTO fcm_tokens (id, user_uuid, device_token, created_at)
-		VALUES ($1, $2, $3, NOW())
-		ON CONFLICT (user_uuid)
-		DO UPDATE SET device_token = $2, updated_at = NOW()
+		WITH released AS (
+			UPDATE user_sessions SET fcm_token = NULL
+			WHERE fcm_token = $2 AND session_uuid <> $1
+		)
+		UPDATE user_sessions
+		SET fcm_token = $2
+		WHERE session_uuid = $1 AND revoked_at IS NULL
 	`
-	_, err := r.DB.Exec(query, tokendata.ID, tokendata.UserUUID, tokendata.DeviceToken)
+
+	result, err := r.DB.Exec(query, sessionUUID, fcmToken)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	return nil
-}
\ No newline at end of file
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
@@ -1,16 +1,31 @@
 package repositories
 
 import (
+	"shuttle/models/dto"
 	"shuttle/models/entity"
 
 	"github.com/jmoiron/sqlx"
 )
 
 type ChildernRepositoryInterface interface {
+	BeginTransaction() (*sqlx.Tx, error)
+
 	FetchAllChilderns(id string) ([]entity.Student, error)
 	FetchSpecChildern(id string) (entity.Student, error)
 	UpdateChildern(student entity.Student, studentUUID string) error
 	UpdateChildernStatus(student entity.Student, studentUUID string) error
+
+	IsParentOfStudent(parentUUID, studentUUID string) (bool, error)
+	HasOverlappingAbsence(studentUUID, startDate, endDate string) (bool, error)
+	SaveAbsence(tx *sqlx.Tx, absence entity.StudentAbsence) error
+	FetchAbsences(studentUUID, fromDate string) ([]dto.StudentAbsenceResponseDTO, error)
+	FetchAbsence(absenceUUID string) (entity.StudentAbsence, error)
+	CancelAbsence(tx *sqlx.Tx, absenceUUID, cancelledBy string) error
+	ShortenAbsence(tx *sqlx.Tx, absenceUUID, endDate, cancelledBy string) error
+	RemoveFromScheduledTrips(tx *sqlx.Tx, studentUUID, startDate, endDate string) error
+	FetchScheduledTripsToRejoin(tx *sqlx.Tx, studentUUID, startDate, endDate string) ([]entity.TripManifest, error)
+	SaveTripManifest(tx *sqlx.Tx, manifest entity.TripManifest) error
+	RenumberTripManifest(tx *sqlx.Tx, tripUUID string) error
 }
 
 type childernRepository struct {
@@ -23,6 +38,10 @@ func NewChildernRepository(DB *sqlx.DB) ChildernRepositoryInterface {
 	}
 }
 
+func (repo *childernRepository) BeginTransaction() (*sqlx.Tx, error) {
+	return repo.DB.Beginx()
+}
+
 func (repositories *childernRepository) FetchAllChilderns(id string) ([]entity.Student, error) {
 	var childerns []entity.Student
 
@@ -164,4 +183,164 @@ func (repo *childernRepository) UpdateChildernStatus(student entity.Student, stu
 		studentUUID,
 	)
 	return err
-}
\ No newline at end of file
+}
+
+func (repo *childernRepository) IsParentOfStudent(parentUUID, studentUUID string) (bool, error) {
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM students WHERE student_uuid = $1 AND parent_uuid = $2)`
+	err := repo.DB.Get(&exists, query, studentUUID, parentUUID)
+	return exists, err
+}
+
+func (repo *childernRepository) HasOverlappingAbsence(studentUUID, startDate, endDate string) (bool, error) {
+	var exists bool
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM student_absences
+			WHERE student_uuid = $1 AND cancelled_at IS NULL
+			AND start_date <= $3::date AND end_date >= $2::date
+		)
+	`
+	err := repo.DB.Get(&exists, query, studentUUID, startDate, endDate)
+	return exists, err
+}
+
+func (repo *childernRepository) SaveAbsence(tx *sqlx.Tx, absence entity.StudentAbsence) error {
+	query := `
+		INSERT INTO student_absences (absence_id, absence_uuid, student_uuid, parent_uuid, start_date, end_date, reason, created_at, created_by)
+		VALUES (:absence_id, :absence_uuid, :student_uuid, :parent_uuid, :start_date, :end_date, :reason, :created_at, :created_by)`
+
+	_, err := tx.NamedExec(query, absence)
+	return err
+}
+
+// Absences that have not ended before fromDate, soonest first
+func (repo *childernRepository) FetchAbsences(studentUUID, fromDate string) ([]dto.StudentAbsenceResponseDTO, error) {
+	query := `
+		SELECT
+			absence_uuid,
+			student_uuid,
+			TO_CHAR(start_date, 'YYYY-MM-DD') AS start_date,
+			TO_CHAR(end_date, 'YYYY-MM-DD') AS end_date,
+			COALESCE(reason, '') AS reason,
+			TO_CHAR(created_at, 'YYYY-MM-DD HH24:MI:SS') AS created_at
+		FROM student_absences
+		WHERE student_uuid = $1 AND cancelled_at IS NULL AND end_date >= $2::date
+		ORDER BY start_date ASC
+	`
+
+	var absences []dto.StudentAbsenceResponseDTO
+	if err := repo.DB.Select(&absences, query, studentUUID, fromDate); err != nil {
+		return nil, err
+	}
+	return absences, nil
+}
+
+func (repo *childernRepository) FetchAbsence(absenceUUID string) (entity.StudentAbsence, error) {
+	query := `
+		SELECT
+			absence_id,
+			absence_uuid,
+			student_uuid,
+			parent_uuid,
+			TO_CHAR(start_date, 'YYYY-MM-DD') AS start_date,
+			TO_CHAR(end_date, 'YYYY-MM-DD') AS end_date,
+			reason,
+			created_at,
+			created_by,
+			cancelled_at,
+			cancelled_by
+		FROM student_absences
+		WHERE absence_uuid = $1
+	`
+
+	var absence entity.StudentAbsence
+	err := repo.DB.Get(&absence, query, absenceUUID)
+	return absence, err
+}
+
+func (repo *childernRepository) CancelAbsence(tx *sqlx.Tx, absenceUUID, cancelledBy string) error {
+	query := `UPDATE student_absences SET cancelled_at = NOW(), cancelled_by = $1 WHERE absence_uuid = $2`
+	_, err := tx.Exec(query, cancelledBy, absenceUUID)
+	return err
+}
+
+// The absence stays on record for the days already missed, cancelled_by notes who cut it short
+func (repo *childernRepository) ShortenAbsence(tx *sqlx.Tx, absenceUUID, endDate, cancelledBy string) error {
+	query := `UPDATE student_absences SET end_date = $1::date, cancelled_by = $2 WHERE absence_uuid = $3`
+	_, err := tx.Exec(query, endDate, cancelledBy, absenceUUID)
+	return err
+}
+
+// Take the student off manifests of trips in the range that have not started yet
+func (repo *childernRepository) RemoveFromScheduledTrips(tx *sqlx.Tx, studentUUID, startDate, endDate string) error {
+	query := `
+		DELETE FROM trip_manifest tm
+		USING trips t
+		WHERE tm.trip_uuid = t.trip_uuid
+		AND tm.student_uuid = $1
+		AND t.status = 'scheduled'
+		AND t.trip_date BETWEEN $2::date AND $3::date
+	`
+	_, err := tx.Exec(query, studentUUID, startDate, endDate)
+	return err
+}
+
+// Scheduled trips in the range the student rides on but is missing from. The
+// stop order is the raw route order, RenumberTripManifest fixes it up after insert
+func (repo *childernRepository) FetchScheduledTripsToRejoin(tx *sqlx.Tx, studentUUID, startDate, endDate string) ([]entity.TripManifest, error) {
+	query := `
+		SELECT t.trip_uuid, ra.student_uuid, COALESCE(ra.student_order, 0) AS stop_order
+		FROM trips t
+		JOIN route_assignment ra ON ra.route_uuid = t.route_name_uuid AND ra.driver_uuid = t.driver_uuid
+		WHERE ra.student_uuid = $1 AND ra.deleted_at IS NULL
+		AND t.status = 'scheduled'
+		AND t.trip_date BETWEEN $2::date AND $3::date
+		AND student_effective_status(ra.student_uuid, t.trip_date) = 'present'
+		AND NOT EXISTS (
+			SELECT 1 FROM trip_manifest tm
+			WHERE tm.trip_uuid = t.trip_uuid AND tm.student_uuid = ra.student_uuid
+		)
+	`
+
+	var manifests []entity.TripManifest
+	if err := tx.Select(&manifests, query, studentUUID, startDate, endDate); err != nil {
+		return nil, err
+	}
+	return manifests, nil
+}
+
+func (repo *childernRepository) SaveTripManifest(tx *sqlx.Tx, manifest entity.TripManifest) error {
+	query := `
+		INSERT INTO trip_manifest (manifest_id, trip_uuid, student_uuid, stop_order, created_at)
+		VALUES (:manifest_id, :trip_uuid, :student_uuid, :stop_order, :created_at)`
+
+	_, err := tx.NamedExec(query, manifest)
+	return err
+}
+
+// Number the trip's stops 1..n the way the trip generator does: by route
+// order, reversed on the way home
+func (repo *childernRepository) RenumberTripManifest(tx *sqlx.Tx, tripUUID string) error {
+	query := `
+		UPDATE trip_manifest tm SET stop_order = o.stop_order
+		FROM (
+			SELECT
+				m.manifest_id,
+				ROW_NUMBER() OVER (
+					ORDER BY
+						CASE WHEN ra.student_uuid IS NULL THEN 1 ELSE 0 END,
+						CASE WHEN t.direction = 'to_home' THEN -COALESCE(ra.student_order, 0) ELSE COALESCE(ra.student_order, 0) END,
+						m.stop_order
+				) AS stop_order
+			FROM trip_manifest m
+			JOIN trips t ON t.trip_uuid = m.trip_uuid
+			LEFT JOIN route_assignment ra ON ra.route_uuid = t.route_name_uuid AND ra.driver_uuid = t.driver_uuid
+				AND ra.student_uuid = m.student_uuid AND ra.deleted_at IS NULL
+			WHERE m.trip_uuid = $1
+		) o
+		WHERE tm.manifest_id = o.manifest_id
+	`
+	_, err := tx.Exec(query, tripUUID)
+	return err
+}
@@ -0,0 +1,93 @@
+package repositories
+
+import (
+	"time"
+
+	"shuttle/models/entity"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type LocationRepositoryInterface interface {
+	SaveLocation(location entity.ShuttleLocation) error
+	FetchTrailByShuttle(shuttleUUID uuid.UUID, from, to time.Time) ([]entity.ShuttleLocation, error)
+	FetchLatestLocation(shuttleUUID uuid.UUID) (entity.ShuttleLocation, error)
+	FetchRecentLocationsByUser(userUUID uuid.UUID, since time.Time) ([]entity.ShuttleLocation, error)
+}
+
+type locationRepository struct {
+	DB *sqlx.DB
+}
+
+func NewLocationRepository(DB *sqlx.DB) LocationRepositoryInterface {
+	return &locationRepository{
+		DB: DB,
+	}
+}
+
+func (r *locationRepository) SaveLocation(location entity.ShuttleLocation) error {
+	query := `
+		INSERT INTO shuttle_locations (location_id, shuttle_uuid, user_uuid, latitude, longitude, speed, heading, recorded_at)
+		VALUES (:location_id, :shuttle_uuid, :user_uuid, :latitude, :longitude, :speed, :heading, :recorded_at)
+	`
+
+	_, err := r.DB.NamedExec(query, location)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *locationRepository) FetchTrailByShuttle(shuttleUUID uuid.UUID, from, to time.Time) ([]entity.ShuttleLocation, error) {
+	query := `
+		SELECT location_id, shuttle_uuid, user_uuid, latitude, longitude, speed, heading, recorded_at, created_at
+		FROM shuttle_locations
+		WHERE shuttle_uuid = $1 AND recorded_at BETWEEN $2 AND $3
+		ORDER BY recorded_at ASC
+	`
+
+	var locations []entity.ShuttleLocation
+	err := r.DB.Select(&locations, query, shuttleUUID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return locations, nil
+}
+
+func (r *locationRepository) FetchLatestLocation(shuttleUUID uuid.UUID) (entity.ShuttleLocation, error) {
+	query := `
+		SELECT location_id, shuttle_uuid, user_uuid, latitude, longitude, speed, heading, recorded_at, created_at
+		FROM shuttle_locations
+		WHERE shuttle_uuid = $1
+		ORDER BY recorded_at DESC
+		LIMIT 1
+	`
+
+	var location entity.ShuttleLocation
+	err := r.DB.Get(&location, query, shuttleUUID)
+	if err != nil {
+		return entity.ShuttleLocation{}, err
+	}
+
+	return location, nil
+}
+
+func (r *locationRepository) FetchRecentLocationsByUser(userUUID uuid.UUID, since time.Time) ([]entity.ShuttleLocation, error) {
+	query := `
+		SELECT location_id, shuttle_uuid, user_uuid, latitude, longitude, speed, heading, recorded_at, created_at
+		FROM shuttle_locations
+		WHERE user_uuid = $1 AND recorded_at >= $2
+		ORDER BY recorded_at ASC
+	`
+
+	var locations []entity.ShuttleLocation
+	err := r.DB.Select(&locations, query, userUUID, since)
+	if err != nil {
+		return nil, err
+	}
+
+	return locations, nil
+}
@@ -0,0 +1,141 @@
+package repositories
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"shuttle/models/dto"
+	"shuttle/models/entity"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type LoginAttemptRepositoryInterface interface {
+	SaveLoginAttempt(attempt entity.LoginAttempt) error
+	FetchAccountFailures(email string, since time.Time) (dto.LoginFailureStatsDTO, error)
+	FetchIPFailures(ipAddress string, since time.Time) (dto.LoginFailureStatsDTO, error)
+	FetchLoginAttempts(offset, limit int, filter dto.LoginAttemptFilter) ([]dto.LoginAttemptResponseDTO, error)
+	CountLoginAttempts(filter dto.LoginAttemptFilter) (int, error)
+}
+
+type loginAttemptRepository struct {
+	DB *sqlx.DB
+}
+
+func NewLoginAttemptRepository(DB *sqlx.DB) LoginAttemptRepositoryInterface {
+	return &loginAttemptRepository{
+		DB: DB,
+	}
+}
+
+func (r *loginAttemptRepository) SaveLoginAttempt(attempt entity.LoginAttempt) error {
+	query := `
+		INSERT INTO login_attempts (attempt_id, email, user_uuid, ip_address, user_agent, succeeded, failure_reason, attempted_at)
+		VALUES (:attempt_id, :email, :user_uuid, :ip_address, :user_agent, :succeeded, :failure_reason, :attempted_at)`
+
+	_, err := r.DB.NamedExec(query, attempt)
+	return err
+}
+
+// Wrong passwords for the account since its last successful login. Attempts
+// refused while locked don't count, so waiting out a lockout is enough.
+func (r *loginAttemptRepository) FetchAccountFailures(email string, since time.Time) (dto.LoginFailureStatsDTO, error) {
+	query := `
+		SELECT COUNT(*) AS failures, MAX(attempted_at) AS last_failed_at
+		FROM login_attempts
+		WHERE email = $1 AND succeeded = false AND failure_reason = 'invalid_credentials'
+		AND attempted_at > GREATEST($2, (SELECT MAX(attempted_at) FROM login_attempts WHERE email = $1 AND succeeded = true))
+	`
+
+	var stats dto.LoginFailureStatsDTO
+	if err := r.DB.Get(&stats, query, email, since); err != nil {
+		return dto.LoginFailureStatsDTO{}, err
+	}
+
+	return stats, nil
+}
+
+// Wrong passwords from the address across all accounts; a success doesn't
+// reset it, one valid account mustn't cover guessing at the others
+func (r *loginAttemptRepository) FetchIPFailures(ipAddress string, since time.Time) (dto.LoginFailureStatsDTO, error) {
+	query := `
+		SELECT COUNT(*) AS failures, MAX(attempted_at) AS last_failed_at
+		FROM login_attempts
+		WHERE ip_address = $1 AND succeeded = false AND failure_reason = 'invalid_credentials'
+		AND attempted_at > $2
+	`
+
+	var stats dto.LoginFailureStatsDTO
+	if err := r.DB.Get(&stats, query, ipAddress, since); err != nil {
+		return dto.LoginFailureStatsDTO{}, err
+	}
+
+	return stats, nil
+}
+
+func loginAttemptConditions(filter dto.LoginAttemptFilter) (string, []interface{}) {
+	conditions := []string{"1 = 1"}
+	args := []interface{}{}
+
+	if filter.Email != "" {
+		args = append(args, filter.Email)
+		conditions = append(conditions, fmt.Sprintf("email = $%d", len(args)))
+	}
+	if filter.IPAddress != "" {
+		args = append(args, filter.IPAddress)
+		conditions = append(conditions, fmt.Sprintf("ip_address = $%d", len(args)))
+	}
+	if filter.Succeeded != "" {
+		args = append(args, filter.Succeeded == "true")
+		conditions = append(conditions, fmt.Sprintf("succeeded = $%d", len(args)))
+	}
+	if filter.DateFrom != "" {
+		args = append(args, filter.DateFrom)
+		conditions = append(conditions, fmt.Sprintf("attempted_at::date >= $%d", len(args)))
+	}
+	if filter.DateTo != "" {
+		args = append(args, filter.DateTo)
+		conditions = append(conditions, fmt.Sprintf("attempted_at::date <= $%d", len(args)))
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+func (r *loginAttemptRepository) FetchLoginAttempts(offset, limit int, filter dto.LoginAttemptFilter) ([]dto.LoginAttemptResponseDTO, error) {
+	where, args := loginAttemptConditions(filter)
+	args = append(args, limit, offset)
+
+	query := fmt.Sprintf(`
+		SELECT
+			attempt_id::text AS attempt_id,
+			email,
+			COALESCE(user_uuid::text, '') AS user_uuid,
+			ip_address,
+			COALESCE(user_agent, '') AS user_agent,
+			succeeded,
+			COALESCE(failure_reason, '') AS failure_reason,
+			TO_CHAR(attempted_at, 'YYYY-MM-DD HH24:MI:SS') AS attempted_at
+		FROM login_attempts
+		WHERE %s
+		ORDER BY attempted_at DESC
+		LIMIT $%d OFFSET $%d`, where, len(args)-1, len(args))
+
+	var attempts []dto.LoginAttemptResponseDTO
+	if err := r.DB.Select(&attempts, query, args...); err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
+
+func (r *loginAttemptRepository) CountLoginAttempts(filter dto.LoginAttemptFilter) (int, error) {
+	where, args := loginAttemptConditions(filter)
+
+	var total int
+	if err := r.DB.Get(&total, fmt.Sprintf(`SELECT COUNT(*) FROM login_attempts WHERE %s`, where), args...); err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
@@ -0,0 +1,94 @@
+package repositories
+
+import (
+	"time"
+
+	"shuttle/models/entity"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type PasswordResetRepositoryInterface interface {
+	BeginTransaction() (*sqlx.Tx, error)
+	SavePasswordResetToken(token entity.PasswordResetToken) error
+	HasRecentPasswordResetToken(userUUID uuid.UUID, since time.Time) (bool, error)
+	FetchPasswordResetTokenForUpdate(tx *sqlx.Tx, tokenHash string) (entity.PasswordResetToken, error)
+	ConsumePasswordResetTokens(tx *sqlx.Tx, userUUID uuid.UUID, at time.Time) error
+	UpdateUserPassword(tx *sqlx.Tx, userUUID uuid.UUID, hashedPassword string) error
+}
+
+type passwordResetRepository struct {
+	DB *sqlx.DB
+}
+
+func NewPasswordResetRepository(DB *sqlx.DB) PasswordResetRepositoryInterface {
+	return &passwordResetRepository{
+		DB: DB,
+	}
+}
+
+func (r *passwordResetRepository) BeginTransaction() (*sqlx.Tx, error) {
+	return r.DB.Beginx()
+}
+
+// A new token replaces any the user still had outstanding
+func (r *passwordResetRepository) SavePasswordResetToken(token entity.PasswordResetToken) error {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := r.ConsumePasswordResetTokens(tx, token.UserUUID, token.CreatedAt); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO password_reset_tokens (token_id, user_uuid, token_hash, requested_ip, created_at, expires_at)
+		VALUES (:token_id, :user_uuid, :token_hash, :requested_ip, :created_at, :expires_at)`
+
+	if _, err := tx.NamedExec(query, token); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *passwordResetRepository) HasRecentPasswordResetToken(userUUID uuid.UUID, since time.Time) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM password_reset_tokens WHERE user_uuid = $1 AND created_at > $2)`
+
+	var exists bool
+	if err := r.DB.Get(&exists, query, userUUID, since); err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// Lock the token so it can't be redeemed twice at the same time
+func (r *passwordResetRepository) FetchPasswordResetTokenForUpdate(tx *sqlx.Tx, tokenHash string) (entity.PasswordResetToken, error) {
+	query := `
+		SELECT token_id, user_uuid, token_hash, requested_ip, created_at, expires_at, used_at
+		FROM password_reset_tokens
+		WHERE token_hash = $1
+		FOR UPDATE
+	`
+
+	var token entity.PasswordResetToken
+	if err := tx.Get(&token, query, tokenHash); err != nil {
+		return entity.PasswordResetToken{}, err
+	}
+
+	return token, nil
+}
+
+func (r *passwordResetRepository) ConsumePasswordResetTokens(tx *sqlx.Tx, userUUID uuid.UUID, at time.Time) error {
+	_, err := tx.Exec(`UPDATE password_reset_tokens SET used_at = $1 WHERE user_uuid = $2 AND used_at IS NULL`, at, userUUID)
+	return err
+}
+
+func (r *passwordResetRepository) UpdateUserPassword(tx *sqlx.Tx, userUUID uuid.UUID, hashedPassword string) error {
+	_, err := tx.Exec(`UPDATE users SET user_password = $1 WHERE user_uuid = $2`, hashedPassword, userUUID)
+	return err
+}
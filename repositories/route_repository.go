@@ -6,8 +6,10 @@ import (
 	"time"
 	"shuttle/models/dto"
 	"shuttle/models/entity"
+	"shuttle/models/geo"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 type RouteRepositoryInterface interface {
@@ -15,6 +17,12 @@ type RouteRepositoryInterface interface {
 	FetchAllRoutesByAS(offset, limit int, sortField, sortDirection, schoolUUID string) ([]dto.RoutesResponseDTO, error)
 	FetchSpecRouteByAS(route_name_UUID, driverUUID string) ([]entity.RouteAssignment, error)
 	FetchAllRoutesByDriver(driverUUID string) ([]dto.RouteResponseByDriverDTO, error)
+	FetchRouteStopsByDriver(driverUUID string) ([]dto.RouteStopDTO, error)
+	FetchStudentPoints(schoolUUID string, studentUUIDs []string) ([]dto.RouteStudentPointDTO, error)
+	FetchSchoolPoint(schoolUUID string) (geo.NullGeoPoint, error)
+	FetchRouteGeoStops(routeNameUUID, schoolUUID string) ([]dto.RouteGeoStopDTO, error)
+	FetchRouteTrail(routeNameUUID, tripDate string) ([]dto.RouteTrailPointDTO, error)
+	DriverExists(driverUUID string) (bool, error)
 
 	AddRoutes(tx *sql.Tx, route entity.Routes) (string, error)
 	AddRouteAssignment(tx *sql.Tx, assignment entity.RouteAssignment) error
@@ -32,6 +40,11 @@ type RouteRepositoryInterface interface {
 
 	GetDriverUUIDByRouteName(routeNameUUID string) (string, error)
 	ValidateDriverVehicle(driverUUID string) (bool, error)
+	FetchRouteSchedules(routeNameUUID string) ([]dto.RouteScheduleResponseDTO, error)
+	AddRouteSchedule(tx *sql.Tx, schedule entity.RouteSchedule) error
+	DeleteRouteSchedules(tx *sql.Tx, routeNameUUID, username string) error
+	FetchDriverVehicleCapacity(tx *sql.Tx, driverUUID string) (dto.VehicleCapacityDTO, error)
+	CountDriverStudentsExcept(tx *sql.Tx, driverUUID string, studentUUIDs []string) (int, error)
 
 	RouteExists(tx *sql.Tx, routenameUUID, schoolUUID string) (bool, error)
 }
@@ -75,7 +88,21 @@ func (r *routeRepository) FetchAllRoutesByAS(offset, limit int, sortField, sortD
 		created_at, 
 		created_by, 
 		updated_at, 
-		updated_by
+		updated_by,
+		(
+			SELECT COUNT(DISTINCT ra.student_uuid)
+			FROM route_assignment ra
+			WHERE ra.route_uuid = routes.route_name_uuid AND ra.deleted_at IS NULL
+		) AS assigned_students,
+		(
+			SELECT COALESCE(SUM(v.vehicle_seats), 0)
+			FROM vehicles v
+			WHERE v.deleted_at IS NULL AND v.driver_uuid IN (
+				SELECT ra.driver_uuid
+				FROM route_assignment ra
+				WHERE ra.route_uuid = routes.route_name_uuid AND ra.deleted_at IS NULL
+			)
+		) AS seat_capacity
 	FROM routes
 	WHERE school_uuid = $1
 	ORDER BY %s %s
@@ -103,6 +130,8 @@ func (r *routeRepository) FetchAllRoutesByAS(offset, limit int, sortField, sortD
 			&createdBy,
 			&updatedAt,
 			&updatedBy,
+			&route.AssignedStudents,
+			&route.SeatCapacity,
 		)
 		if err != nil {
 			return nil, err
@@ -201,19 +230,36 @@ func (repo *routeRepository) FetchAllRoutesByDriver(driverUUID string) ([]dto.Ro
 			r.school_uuid,
 			s.student_first_name,
 			s.student_last_name,
-			s.student_status,
+			student_effective_status(s.student_uuid, CURRENT_DATE) AS student_status,
 			s.student_address,
 			s.student_pickup_point,
 			st.shuttle_uuid,
 			st.status AS shuttle_status,
 			sc.school_name,
-			sc.school_point
+			sc.school_point,
+			COALESCE(r.student_order, 0) AS student_order,
+			rs.schedule_uuid,
+			rs.direction AS trip_direction,
+			TO_CHAR(rs.departure_time, 'HH24:MI') AS departure_time
 		FROM route_assignment r
 		LEFT JOIN students s ON r.student_uuid = s.student_uuid
 		LEFT JOIN schools sc ON r.school_uuid = sc.school_uuid
 		LEFT JOIN shuttle st ON r.student_uuid = st.student_uuid AND DATE(st.created_at) = CURRENT_DATE
-		WHERE r.driver_uuid = $1 AND s.student_status = 'present'
-		ORDER BY r.created_at ASC
+		LEFT JOIN route_schedules rs ON rs.route_name_uuid = r.route_uuid
+			AND rs.deleted_at IS NULL
+			AND EXTRACT(ISODOW FROM CURRENT_DATE)::INTEGER = ANY(rs.active_days)
+			AND (rs.term_start IS NULL OR rs.term_start <= CURRENT_DATE)
+			AND (rs.term_end IS NULL OR rs.term_end >= CURRENT_DATE)
+		WHERE r.driver_uuid = $1 AND student_effective_status(s.student_uuid, CURRENT_DATE) = 'present'
+		-- Routes without any schedule predate schedules and run every day
+		AND (rs.schedule_uuid IS NOT NULL OR NOT EXISTS (
+			SELECT 1 FROM route_schedules x
+			WHERE x.route_name_uuid = r.route_uuid AND x.deleted_at IS NULL
+		))
+		ORDER BY
+			rs.departure_time ASC NULLS LAST,
+			CASE WHEN rs.direction = 'to_home' THEN -COALESCE(r.student_order, 0) ELSE COALESCE(r.student_order, 0) END ASC,
+			r.created_at ASC
 	`
 	var routes []dto.RouteResponseByDriverDTO
 	err := repo.DB.Select(&routes, query, driverUUID)
@@ -223,6 +269,144 @@ func (repo *routeRepository) FetchAllRoutesByDriver(driverUUID string) ([]dto.Ro
 	return routes, nil
 }
 
+func (repo *routeRepository) FetchRouteStopsByDriver(driverUUID string) ([]dto.RouteStopDTO, error) {
+	query := `
+		SELECT
+			r.student_uuid,
+			s.parent_uuid,
+			s.student_first_name,
+			s.student_pickup_point,
+			CAST(r.student_order AS TEXT)::INTEGER AS student_order,
+			COALESCE(st.shuttle_uuid::text, '') AS shuttle_uuid,
+			COALESCE(st.status::text, 'home') AS shuttle_status,
+			sc.school_point
+		FROM route_assignment r
+		JOIN students s ON r.student_uuid = s.student_uuid
+		JOIN schools sc ON r.school_uuid = sc.school_uuid
+		LEFT JOIN LATERAL (
+			SELECT shuttle_uuid, status
+			FROM shuttle
+			WHERE student_uuid = r.student_uuid AND DATE(created_at) = CURRENT_DATE AND deleted_at IS NULL
+			ORDER BY created_at DESC
+			LIMIT 1
+		) st ON TRUE
+		WHERE r.driver_uuid = $1 AND r.deleted_at IS NULL AND student_effective_status(s.student_uuid, CURRENT_DATE) = 'present'
+		ORDER BY student_order ASC
+	`
+	var stops []dto.RouteStopDTO
+	err := repo.DB.Select(&stops, query, driverUUID)
+	if err != nil {
+		return nil, err
+	}
+	return stops, nil
+}
+
+// Same rows as FetchSpecRouteByAS, scoped to the school and with the point columns.
+// A route without assignments still yields one row so the school can be drawn.
+func (repo *routeRepository) FetchRouteGeoStops(routeNameUUID, schoolUUID string) ([]dto.RouteGeoStopDTO, error) {
+	query := `
+		SELECT
+			r.route_name_uuid,
+			r.route_name,
+			sc.school_name,
+			sc.school_point,
+			COALESCE(ra.driver_uuid::text, '') AS driver_uuid,
+			COALESCE(s.student_uuid::text, '') AS student_uuid,
+			COALESCE(s.student_first_name, '') AS student_first_name,
+			COALESCE(s.student_last_name, '') AS student_last_name,
+			COALESCE(s.student_address, '') AS student_address,
+			s.student_pickup_point,
+			COALESCE(ra.student_order, 0) AS student_order
+		FROM routes r
+		JOIN schools sc ON sc.school_uuid = r.school_uuid
+		LEFT JOIN route_assignment ra ON ra.route_name_uuid = r.route_name_uuid AND ra.deleted_at IS NULL
+		LEFT JOIN students s ON s.student_uuid = ra.student_uuid
+		WHERE r.route_name_uuid = $1 AND r.school_uuid = $2 AND r.deleted_at IS NULL
+		ORDER BY ra.driver_uuid, COALESCE(ra.student_order, 0) ASC
+	`
+	var stops []dto.RouteGeoStopDTO
+	err := repo.DB.Select(&stops, query, routeNameUUID, schoolUUID)
+	if err != nil {
+		return nil, err
+	}
+	return stops, nil
+}
+
+// Positions streamed by the driver while each of the route's trips on the date was under way
+func (repo *routeRepository) FetchRouteTrail(routeNameUUID, tripDate string) ([]dto.RouteTrailPointDTO, error) {
+	query := `
+		SELECT
+			t.trip_uuid,
+			t.driver_uuid,
+			t.direction,
+			l.latitude,
+			l.longitude,
+			l.recorded_at
+		FROM trips t
+		JOIN LATERAL (
+			SELECT DISTINCT ON (recorded_at) latitude, longitude, recorded_at
+			FROM shuttle_locations
+			WHERE user_uuid = t.driver_uuid
+			AND recorded_at BETWEEN t.started_at AND COALESCE(t.finished_at, NOW())
+			ORDER BY recorded_at
+		) l ON TRUE
+		WHERE t.route_name_uuid = $1 AND t.trip_date = $2 AND t.started_at IS NOT NULL
+		ORDER BY t.started_at ASC, l.recorded_at ASC
+	`
+	var points []dto.RouteTrailPointDTO
+	err := repo.DB.Select(&points, query, routeNameUUID, tripDate)
+	if err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+func (repo *routeRepository) FetchStudentPoints(schoolUUID string, studentUUIDs []string) ([]dto.RouteStudentPointDTO, error) {
+	query := `
+		SELECT
+			s.student_uuid,
+			s.student_first_name,
+			s.student_last_name,
+			s.student_pickup_point
+		FROM students s
+		WHERE s.school_uuid = $1 AND s.student_uuid::text = ANY($2) AND s.deleted_at IS NULL
+	`
+	var students []dto.RouteStudentPointDTO
+	err := repo.DB.Select(&students, query, schoolUUID, pq.Array(studentUUIDs))
+	if err != nil {
+		return nil, err
+	}
+	return students, nil
+}
+
+func (repo *routeRepository) FetchSchoolPoint(schoolUUID string) (geo.NullGeoPoint, error) {
+	query := `SELECT school_point FROM schools WHERE school_uuid = $1 AND deleted_at IS NULL`
+
+	var point geo.NullGeoPoint
+	if err := repo.DB.Get(&point, query, schoolUUID); err != nil {
+		return geo.NullGeoPoint{}, err
+	}
+	return point, nil
+}
+
+func (repo *routeRepository) DriverExists(driverUUID string) (bool, error) {
+	query := `
+		SELECT 1
+		FROM driver_details dd
+		JOIN users u ON u.user_uuid = dd.user_uuid
+		WHERE dd.user_uuid = $1 AND u.deleted_at IS NULL
+	`
+	var exists int
+	err := repo.DB.Get(&exists, query, driverUUID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 func (r *routeRepository) ValidateDriverVehicle(driverUUID string) (bool, error) {
 	query := `
 		SELECT 
@@ -247,6 +431,138 @@ func (r *routeRepository) ValidateDriverVehicle(driverUUID string) (bool, error)
 	return true, nil
 }
 
+func (repo *routeRepository) FetchRouteSchedules(routeNameUUID string) ([]dto.RouteScheduleResponseDTO, error) {
+	query := `
+		SELECT
+			schedule_uuid,
+			direction,
+			TO_CHAR(departure_time, 'HH24:MI'),
+			active_days,
+			COALESCE(TO_CHAR(term_start, 'YYYY-MM-DD'), ''),
+			COALESCE(TO_CHAR(term_end, 'YYYY-MM-DD'), '')
+		FROM route_schedules
+		WHERE route_name_uuid = $1 AND deleted_at IS NULL
+		ORDER BY departure_time ASC
+	`
+
+	rows, err := repo.DB.Query(query, routeNameUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch route schedules: %w", err)
+	}
+	defer rows.Close()
+
+	var schedules []dto.RouteScheduleResponseDTO
+	for rows.Next() {
+		var schedule dto.RouteScheduleResponseDTO
+		var activeDays pq.Int64Array
+		if err := rows.Scan(
+			&schedule.ScheduleUUID,
+			&schedule.Direction,
+			&schedule.DepartureTime,
+			&activeDays,
+			&schedule.TermStart,
+			&schedule.TermEnd,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan route schedule: %w", err)
+		}
+		schedule.ActiveDays = activeDays
+		schedules = append(schedules, schedule)
+	}
+
+	return schedules, rows.Err()
+}
+
+func (repo *routeRepository) AddRouteSchedule(tx *sql.Tx, schedule entity.RouteSchedule) error {
+	query := `
+		INSERT INTO route_schedules (
+			schedule_id,
+			schedule_uuid,
+			route_name_uuid,
+			school_uuid,
+			direction,
+			departure_time,
+			active_days,
+			term_start,
+			term_end,
+			created_at,
+			created_by
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`
+
+	_, err := tx.Exec(query,
+		schedule.ScheduleID,
+		schedule.ScheduleUUID,
+		schedule.RouteNameUUID,
+		schedule.SchoolUUID,
+		schedule.Direction,
+		schedule.DepartureTime,
+		schedule.ActiveDays,
+		schedule.TermStart,
+		schedule.TermEnd,
+		schedule.CreatedAt.Time,
+		schedule.CreatedBy.String,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert route schedule: %w", err)
+	}
+	return nil
+}
+
+// Soft-delete so generated trips keep their schedule; trips of these schedules
+// that have not started are cancelled and regenerated from the new ones
+func (repo *routeRepository) DeleteRouteSchedules(tx *sql.Tx, routeNameUUID, username string) error {
+	query := `
+		UPDATE route_schedules SET deleted_at = NOW(), deleted_by = $2
+		WHERE route_name_uuid = $1 AND deleted_at IS NULL
+	`
+	if _, err := tx.Exec(query, routeNameUUID, username); err != nil {
+		return fmt.Errorf("failed to delete route schedules: %w", err)
+	}
+
+	query = `
+		UPDATE trips SET status = 'cancelled', updated_at = NOW(), updated_by = $2
+		WHERE route_name_uuid = $1 AND status = 'scheduled' AND trip_date >= CURRENT_DATE
+		AND schedule_uuid IN (SELECT schedule_uuid FROM route_schedules WHERE route_name_uuid = $1 AND deleted_at IS NOT NULL)
+	`
+	if _, err := tx.Exec(query, routeNameUUID, username); err != nil {
+		return fmt.Errorf("failed to cancel trips of deleted schedules: %w", err)
+	}
+	return nil
+}
+
+// The vehicle the driver drives; sql.ErrNoRows when none is linked
+func (r *routeRepository) FetchDriverVehicleCapacity(tx *sql.Tx, driverUUID string) (dto.VehicleCapacityDTO, error) {
+	query := `
+		SELECT vehicle_uuid, vehicle_name, vehicle_number, vehicle_seats
+		FROM vehicles
+		WHERE driver_uuid = $1 AND deleted_at IS NULL
+		LIMIT 1
+	`
+
+	capacity := dto.VehicleCapacityDTO{DriverUUID: driverUUID}
+	err := tx.QueryRow(query, driverUUID).Scan(&capacity.VehicleUUID, &capacity.VehicleName, &capacity.VehicleNumber, &capacity.VehicleSeats)
+	if err != nil {
+		return dto.VehicleCapacityDTO{}, err
+	}
+
+	return capacity, nil
+}
+
+// Students the driver already carries on any route, leaving out the given ones
+func (r *routeRepository) CountDriverStudentsExcept(tx *sql.Tx, driverUUID string, studentUUIDs []string) (int, error) {
+	query := `
+		SELECT COUNT(DISTINCT student_uuid)
+		FROM route_assignment
+		WHERE driver_uuid = $1 AND deleted_at IS NULL AND NOT (student_uuid::text = ANY($2))
+	`
+
+	var count int
+	if err := tx.QueryRow(query, driverUUID, pq.Array(studentUUIDs)).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count driver students: %w", err)
+	}
+	return count, nil
+}
+
 func (r *routeRepository) AddRoutes(tx *sql.Tx, route entity.Routes) (string, error) {
 	var routeNameUUID string
 	query := `
@@ -144,7 +144,7 @@ func (repositories *schoolRepository) FetchSpecSchool(id string) (entity.School,
 
 	query := `
 		SELECT s.school_uuid, s.school_name, s.school_address, s.school_contact, s.school_email, s.school_description, s.school_point, s.created_at,
-			s.created_by, s.updated_at, s.updated_by, 
+			s.created_by, s.updated_at, s.updated_by, s.school_approach_radius, s.school_arrival_radius,
 			COALESCE(
 				STRING_AGG(
 					CASE
@@ -197,7 +197,7 @@ func (repositories *schoolRepository) FetchSpecSchool(id string) (entity.School,
 
 	err := repositories.DB.QueryRowx(query, id).Scan(
 		&school.UUID, &school.Name, &school.Address, &school.Contact, &school.Email, &school.Description, &school.Point, &school.CreatedAt,
-		&school.CreatedBy, &school.UpdatedAt, &school.UpdatedBy, &userUUIDs, &adminSchoolUUIDs, &firstNames, &lastNames,
+		&school.CreatedBy, &school.UpdatedAt, &school.UpdatedBy, &school.ApproachRadius, &school.ArrivalRadius, &userUUIDs, &adminSchoolUUIDs, &firstNames, &lastNames,
 	)
 	if err != nil {
 		return entity.School{}, []entity.SchoolAdminDetails{}, err
@@ -226,15 +226,10 @@ func (repositories *schoolRepository) FetchSpecSchool(id string) (entity.School,
 }
 
 func (r *schoolRepository) SaveSchool(school entity.School) error {
-	var point interface{}
-	if school.Point.Valid {
-		point = school.Point.String
-	} else {
-		point = nil
-	}
-
-	query := `INSERT INTO schools (school_id, school_uuid, school_name, school_address, school_contact, school_email, school_description, school_point, created_by)
-			  VALUES (:school_id, :school_uuid, :school_name, :school_address, :school_contact, :school_email, :school_description, :school_point, :created_by)`
+	// Zero radii fall back to the column defaults
+	query := `INSERT INTO schools (school_id, school_uuid, school_name, school_address, school_contact, school_email, school_description, school_point, created_by, school_approach_radius, school_arrival_radius)
+			  VALUES (:school_id, :school_uuid, :school_name, :school_address, :school_contact, :school_email, :school_description, COALESCE(CAST(:school_point AS JSON), CAST('{}' AS JSON)), :created_by,
+			  	COALESCE(NULLIF(:school_approach_radius, 0), 500), COALESCE(NULLIF(:school_arrival_radius, 0), 150))`
 	
 	_, err := r.DB.NamedExec(query, map[string]interface{}{
 		"school_id":        school.ID,
@@ -244,8 +239,10 @@ func (r *schoolRepository) SaveSchool(school entity.School) error {
 		"school_contact":   school.Contact,
 		"school_email":     school.Email,
 		"school_description": school.Description,
-		"school_point":     point,
+		"school_point":     school.Point,
 		"created_by":       school.CreatedBy,
+		"school_approach_radius": school.ApproachRadius,
+		"school_arrival_radius":  school.ArrivalRadius,
 	})
 	if err != nil {
 		return err
@@ -256,7 +253,9 @@ func (r *schoolRepository) SaveSchool(school entity.School) error {
 
 func (r *schoolRepository) UpdateSchool(school entity.School) error {
 	query := `
-		UPDATE schools SET school_name = :school_name, school_address = :school_address, school_contact = :school_contact, school_email = :school_email, school_description = :school_description, school_point = :school_point, updated_at = :updated_at, updated_by = :updated_by 
+		UPDATE schools SET school_name = :school_name, school_address = :school_address, school_contact = :school_contact, school_email = :school_email, school_description = :school_description, school_point = COALESCE(CAST(:school_point AS JSON), school_point), updated_at = :updated_at, updated_by = :updated_by,
+			school_approach_radius = COALESCE(NULLIF(:school_approach_radius, 0), school_approach_radius),
+			school_arrival_radius = COALESCE(NULLIF(:school_arrival_radius, 0), school_arrival_radius)
 		WHERE school_uuid = :school_uuid`
 	_, err := r.DB.NamedExec(query, school)
 	if err != nil {
@@ -2,11 +2,11 @@ package repositories
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"log"
 	"shuttle/models/dto"
 	"shuttle/models/entity"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
@@ -17,13 +17,26 @@ type ShuttleRepositoryInterface interface {
 	CountShuttlesByParent(parentUUID uuid.UUID) (int, error)
 	CountShuttleByDate(date string) (int, error)
 	CheckIfExistInShuttle(userUUID uuid.UUID, shuttleUUID uuid.UUID) (bool, error)
+	CheckShuttleInSchool(shuttleUUID uuid.UUID, schoolUUID string) (bool, error)
+	IsShuttleDriver(driverUUID uuid.UUID, shuttleUUID uuid.UUID) (bool, error)
 
 	FetchShuttleTrackByParent(parentUUID uuid.UUID) ([]dto.ShuttleResponse, error)
 	FetchAllShuttleByParent(offset, limit int, sortField, sortDirection string, parentUUID uuid.UUID) ([]dto.ShuttleAllResponse, error)
 	FetchAllShuttleByDriver(driverUUID uuid.UUID) ([]dto.ShuttleAllResponse, error)
 	GetSpecShuttle(shuttleUUID uuid.UUID) ([]dto.ShuttleSpecResponse, error)
+	FetchShuttleGeofenceContext(shuttleUUID uuid.UUID) (dto.ShuttleGeofenceContext, error)
 	SaveShuttle(shuttle entity.Shuttle) error
-	UpdateShuttleStatus(shuttleUUID uuid.UUID, status string) error
+	BeginTransaction() (*sqlx.Tx, error)
+	FetchShuttleStatusForUpdate(tx *sqlx.Tx, shuttleUUID uuid.UUID, driverUUID string) (string, error)
+	UpdateShuttleStatus(tx *sqlx.Tx, shuttleUUID uuid.UUID, status string) (dto.ShuttleStatusChange, error)
+	SaveShuttleStatusHistory(tx *sqlx.Tx, history entity.ShuttleStatusHistory) error
+	FetchShuttleStatusHistory(shuttleUUID uuid.UUID) ([]entity.ShuttleStatusHistory, error)
+	FetchShuttleHandoverContext(tx *sqlx.Tx, shuttleUUID uuid.UUID) (dto.ShuttleHandoverContext, error)
+	FetchLastHandoverType(tx *sqlx.Tx, shuttleUUID uuid.UUID) (string, error)
+	FetchInProgressTripByShuttle(tx *sqlx.Tx, shuttleUUID uuid.UUID) (uuid.NullUUID, error)
+	SaveStudentHandover(tx *sqlx.Tx, handover entity.StudentHandover) error
+	FetchStudentHandovers(offset, limit int, schoolUUID string, filter dto.StudentHandoverFilter) ([]dto.StudentHandoverRecord, error)
+	CountStudentHandovers(schoolUUID string, filter dto.StudentHandoverFilter) (int, error)
 }
 
 type ShuttleRepository struct {
@@ -110,6 +123,46 @@ func (r *ShuttleRepository) CheckIfExistInShuttle(userUUID uuid.UUID, shuttleUUI
 	return true, nil
 }
 
+func (r *ShuttleRepository) CheckShuttleInSchool(shuttleUUID uuid.UUID, schoolUUID string) (bool, error) {
+	query := `
+		SELECT 1
+		FROM shuttle st
+		JOIN students s
+			ON st.student_uuid = s.student_uuid
+		WHERE st.shuttle_uuid = $1 AND s.school_uuid = $2
+	`
+
+	var exists int
+	err := r.DB.Get(&exists, query, shuttleUUID, schoolUUID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *ShuttleRepository) IsShuttleDriver(driverUUID uuid.UUID, shuttleUUID uuid.UUID) (bool, error) {
+	query := `
+		SELECT 1
+		FROM shuttle st
+		WHERE st.driver_uuid = $1 AND st.shuttle_uuid = $2
+	`
+
+	var exists int
+	err := r.DB.Get(&exists, query, driverUUID, shuttleUUID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
 func (r *ShuttleRepository) FetchShuttleTrackByParent(parentUUID uuid.UUID) ([]dto.ShuttleResponse, error) {
 	log.Println("Executing query to fetch shuttle track for parentUUID:", parentUUID)
 
@@ -123,6 +176,7 @@ func (r *ShuttleRepository) FetchShuttleTrackByParent(parentUUID uuid.UUID) ([]d
 			s.school_uuid,
 			sc.school_name,
 			st.status AS shuttle_status,
+			st.driver_uuid,
 			st.created_at,
 			CURRENT_DATE AS current_date
 		FROM shuttle st
@@ -255,20 +309,37 @@ func (r *ShuttleRepository) GetSpecShuttle(shuttleUUID uuid.UUID) ([]dto.Shuttle
 		return nil, fmt.Errorf("failed to fetch shuttle data from database: %w", err)
 	}
 
-	log.Println("Shuttle data fetched from database:", shuttles)
-	for i := range shuttles {
-		if err := json.Unmarshal([]byte(shuttles[i].StudentPickupPoint), &shuttles[i].StudentPickupPoint); err != nil {
-			log.Println("Error unmarshalling StudentPickupPoint:", err)
-		}
-		if err := json.Unmarshal([]byte(shuttles[i].SchoolPoint), &shuttles[i].SchoolPoint); err != nil {
-			log.Println("Error unmarshalling SchoolPoint:", err)
-		}
-	}
-
 	log.Println("Successfully processed shuttle data:", shuttles)
 	return shuttles, nil
 }
 
+func (r *ShuttleRepository) FetchShuttleGeofenceContext(shuttleUUID uuid.UUID) (dto.ShuttleGeofenceContext, error) {
+	query := `
+		SELECT
+			st.shuttle_uuid,
+			st.student_uuid,
+			st.driver_uuid,
+			s.parent_uuid,
+			s.student_first_name,
+			st.status AS shuttle_status,
+			s.student_pickup_point,
+			sc.school_point,
+			sc.school_approach_radius,
+			sc.school_arrival_radius
+		FROM shuttle st
+		JOIN students s ON s.student_uuid = st.student_uuid
+		JOIN schools sc ON sc.school_uuid = s.school_uuid
+		WHERE st.shuttle_uuid = $1 AND st.deleted_at IS NULL
+	`
+
+	var context dto.ShuttleGeofenceContext
+	if err := r.DB.Get(&context, query, shuttleUUID); err != nil {
+		return dto.ShuttleGeofenceContext{}, err
+	}
+
+	return context, nil
+}
+
 func (r *ShuttleRepository) SaveShuttle(shuttle entity.Shuttle) error {
 	// Log: Logging query execution details
 	log.Printf("SaveShuttle: Preparing to execute query for shuttleID %d", shuttle.ShuttleID)
@@ -294,26 +365,232 @@ func (r *ShuttleRepository) SaveShuttle(shuttle entity.Shuttle) error {
 	return nil
 }
 
-func (r *ShuttleRepository) UpdateShuttleStatus(shuttleUUID uuid.UUID, status string) error {
-	query := `
-		UPDATE shuttle
-		SET status = :status, updated_at = NOW()
-		WHERE shuttle_uuid = :shuttle_uuid`
+func (r *ShuttleRepository) BeginTransaction() (*sqlx.Tx, error) {
+	return r.DB.Beginx()
+}
+
+// Lock the shuttle row so concurrent status updates are applied one after another
+// Only the shuttle's own driver gets a row, anyone else sees sql.ErrNoRows
+func (r *ShuttleRepository) FetchShuttleStatusForUpdate(tx *sqlx.Tx, shuttleUUID uuid.UUID, driverUUID string) (string, error) {
+	query := `SELECT status FROM shuttle WHERE shuttle_uuid = $1 AND driver_uuid = $2 AND deleted_at IS NULL FOR UPDATE`
+
+	var status string
+	if err := tx.Get(&status, query, shuttleUUID, driverUUID); err != nil {
+		return "", err
+	}
 
-	data := map[string]interface{}{
-		"status":       status,
-		"shuttle_uuid": shuttleUUID,
+	return status, nil
+}
+
+// Returns the status before and after the update so callers can emit the transition
+func (r *ShuttleRepository) UpdateShuttleStatus(tx *sqlx.Tx, shuttleUUID uuid.UUID, status string) (dto.ShuttleStatusChange, error) {
+	query := `
+		WITH old AS (
+			SELECT shuttle_uuid, student_uuid, status
+			FROM shuttle
+			WHERE shuttle_uuid = $2
+			FOR UPDATE
+		)
+		UPDATE shuttle st
+		SET status = $1, updated_at = NOW()
+		FROM old
+		LEFT JOIN students s ON s.student_uuid = old.student_uuid
+		WHERE st.shuttle_uuid = old.shuttle_uuid
+		RETURNING
+			st.shuttle_uuid,
+			st.student_uuid,
+			COALESCE(s.parent_uuid::text, '') AS parent_uuid,
+			old.status AS old_status,
+			st.status AS new_status,
+			st.updated_at AS changed_at`
+
+	var change dto.ShuttleStatusChange
+	if err := tx.Get(&change, query, status, shuttleUUID); err != nil {
+		return dto.ShuttleStatusChange{}, err
 	}
 
-	result, err := r.DB.NamedExec(query, data)
+	return change, nil
+}
+
+func (r *ShuttleRepository) SaveShuttleStatusHistory(tx *sqlx.Tx, history entity.ShuttleStatusHistory) error {
+	query := `
+		INSERT INTO shuttle_status_history (history_id, shuttle_uuid, from_status, to_status, changed_by, latitude, longitude, changed_at)
+		VALUES (:history_id, :shuttle_uuid, :from_status, :to_status, :changed_by, :latitude, :longitude, :changed_at)`
+
+	_, err := tx.NamedExec(query, history)
 	if err != nil {
 		return err
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		return sql.ErrNoRows
+	return nil
+}
+
+func (r *ShuttleRepository) FetchShuttleStatusHistory(shuttleUUID uuid.UUID) ([]entity.ShuttleStatusHistory, error) {
+	query := `
+		SELECT history_id, shuttle_uuid, from_status, to_status, changed_by, latitude, longitude, changed_at
+		FROM shuttle_status_history
+		WHERE shuttle_uuid = $1
+		ORDER BY changed_at ASC`
+
+	var history []entity.ShuttleStatusHistory
+	if err := r.DB.Select(&history, query, shuttleUUID); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+// Locks the shuttle row so two confirmations for the same student cannot interleave
+func (r *ShuttleRepository) FetchShuttleHandoverContext(tx *sqlx.Tx, shuttleUUID uuid.UUID) (dto.ShuttleHandoverContext, error) {
+	query := `
+		SELECT
+			st.shuttle_uuid,
+			st.student_uuid,
+			st.driver_uuid,
+			COALESCE(s.parent_uuid::text, '') AS parent_uuid,
+			s.student_first_name
+		FROM shuttle st
+		JOIN students s ON s.student_uuid = st.student_uuid
+		WHERE st.shuttle_uuid = $1 AND st.deleted_at IS NULL
+		FOR UPDATE OF st
+	`
+
+	var context dto.ShuttleHandoverContext
+	if err := tx.Get(&context, query, shuttleUUID); err != nil {
+		return dto.ShuttleHandoverContext{}, err
+	}
+
+	return context, nil
+}
+
+// Returns an empty string when the student has not been handed over yet on this shuttle
+func (r *ShuttleRepository) FetchLastHandoverType(tx *sqlx.Tx, shuttleUUID uuid.UUID) (string, error) {
+	query := `
+		SELECT handover_type
+		FROM student_handovers
+		WHERE shuttle_uuid = $1
+		ORDER BY confirmed_at DESC, handover_id DESC
+		LIMIT 1`
+
+	var handoverType string
+	err := tx.Get(&handoverType, query, shuttleUUID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return handoverType, nil
+}
+
+func (r *ShuttleRepository) FetchInProgressTripByShuttle(tx *sqlx.Tx, shuttleUUID uuid.UUID) (uuid.NullUUID, error) {
+	query := `
+		SELECT t.trip_uuid
+		FROM trip_manifest m
+		JOIN trips t ON t.trip_uuid = m.trip_uuid
+		WHERE m.shuttle_uuid = $1 AND t.status = 'in_progress'
+		ORDER BY t.started_at DESC
+		LIMIT 1`
+
+	var tripUUID uuid.NullUUID
+	err := tx.Get(&tripUUID, query, shuttleUUID)
+	if err == sql.ErrNoRows {
+		return uuid.NullUUID{}, nil
+	}
+	if err != nil {
+		return uuid.NullUUID{}, err
+	}
+
+	return tripUUID, nil
+}
+
+func (r *ShuttleRepository) SaveStudentHandover(tx *sqlx.Tx, handover entity.StudentHandover) error {
+	query := `
+		INSERT INTO student_handovers (handover_id, handover_uuid, shuttle_uuid, trip_uuid, student_uuid, driver_uuid, handover_type, latitude, longitude, proof_type, proof_file, notes, confirmed_at)
+		VALUES (:handover_id, :handover_uuid, :shuttle_uuid, :trip_uuid, :student_uuid, :driver_uuid, :handover_type, :latitude, :longitude, :proof_type, :proof_file, :notes, :confirmed_at)`
+
+	_, err := tx.NamedExec(query, handover)
+	if err != nil {
+		return err
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+func studentHandoverConditions(schoolUUID string, filter dto.StudentHandoverFilter) (string, []interface{}) {
+	conditions := []string{"s.school_uuid = $1"}
+	args := []interface{}{schoolUUID}
+
+	if filter.StudentUUID != "" {
+		args = append(args, filter.StudentUUID)
+		conditions = append(conditions, fmt.Sprintf("h.student_uuid = $%d", len(args)))
+	}
+	if filter.ShuttleUUID != "" {
+		args = append(args, filter.ShuttleUUID)
+		conditions = append(conditions, fmt.Sprintf("h.shuttle_uuid = $%d", len(args)))
+	}
+	if filter.DateFrom != "" {
+		args = append(args, filter.DateFrom)
+		conditions = append(conditions, fmt.Sprintf("h.confirmed_at::date >= $%d", len(args)))
+	}
+	if filter.DateTo != "" {
+		args = append(args, filter.DateTo)
+		conditions = append(conditions, fmt.Sprintf("h.confirmed_at::date <= $%d", len(args)))
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+func (r *ShuttleRepository) FetchStudentHandovers(offset, limit int, schoolUUID string, filter dto.StudentHandoverFilter) ([]dto.StudentHandoverRecord, error) {
+	where, args := studentHandoverConditions(schoolUUID, filter)
+	args = append(args, limit, offset)
+
+	query := fmt.Sprintf(`
+		SELECT
+			h.handover_uuid,
+			h.shuttle_uuid,
+			h.trip_uuid::text AS trip_uuid,
+			h.student_uuid,
+			s.student_first_name,
+			s.student_last_name,
+			h.driver_uuid,
+			COALESCE(dd.user_first_name, '') AS driver_first_name,
+			COALESCE(dd.user_last_name, '') AS driver_last_name,
+			h.handover_type,
+			h.latitude,
+			h.longitude,
+			h.proof_type,
+			h.proof_file,
+			h.notes,
+			h.confirmed_at
+		FROM student_handovers h
+		JOIN students s ON s.student_uuid = h.student_uuid
+		LEFT JOIN driver_details dd ON dd.user_uuid = h.driver_uuid
+		WHERE %s
+		ORDER BY h.confirmed_at DESC
+		LIMIT $%d OFFSET $%d`, where, len(args)-1, len(args))
+
+	var handovers []dto.StudentHandoverRecord
+	if err := r.DB.Select(&handovers, query, args...); err != nil {
+		return nil, err
+	}
+
+	return handovers, nil
+}
+
+func (r *ShuttleRepository) CountStudentHandovers(schoolUUID string, filter dto.StudentHandoverFilter) (int, error) {
+	where, args := studentHandoverConditions(schoolUUID, filter)
+
+	query := fmt.Sprintf(`
+		SELECT COUNT(*)
+		FROM student_handovers h
+		JOIN students s ON s.student_uuid = h.student_uuid
+		WHERE %s`, where)
+
+	var total int
+	if err := r.DB.Get(&total, query, args...); err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
@@ -0,0 +1,34 @@
+package repositories
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	postGISOnce      sync.Once
+	postGISAvailable bool
+)
+
+// Checked once per process; PostGIS is optional and installed by the spatial migration when the server has it
+func hasPostGIS(db *sqlx.DB) bool {
+	postGISOnce.Do(func() {
+		query := `SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'postgis')`
+		if err := db.Get(&postGISAvailable, query); err != nil {
+			log.Printf("Failed to detect PostGIS, using haversine distances: %v", err)
+			postGISAvailable = false
+		}
+	})
+	return postGISAvailable
+}
+
+// SQL expression for the distance in meters between two lat/lng pairs
+func distanceSQL(db *sqlx.DB, latA, lngA, latB, lngB string) string {
+	if hasPostGIS(db) {
+		return fmt.Sprintf("ST_Distance(ST_MakePoint(%s, %s)::geography, ST_MakePoint(%s, %s)::geography)", lngA, latA, lngB, latB)
+	}
+	return fmt.Sprintf("haversine_meters(%s, %s, %s, %s)", latA, lngA, latB, lngB)
+}
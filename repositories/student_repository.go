@@ -3,10 +3,13 @@ package repositories
 import (
 	"fmt"
 	"log"
+	"shuttle/models/dto"
 	"shuttle/models/entity"
+	"shuttle/models/geo"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 type StudentRepositoryInterface interface {
@@ -16,6 +19,7 @@ type StudentRepositoryInterface interface {
 	FetchAllStudentsWithParents(offset int, limit int, sortField string, sortDirection string, schoolUUID string) ([]entity.Student, []entity.ParentDetails, error)
 	FetchSpecStudentWithParents(studentUUID uuid.UUID, schoolUUID string) (entity.Student, entity.ParentDetails, error)
 	FetchAvailableStudent(schoolUUID string) ([]entity.Student, error)
+	FetchStudentsNearPoints(schoolUUID string, points []geo.GeoPoint, radiusMeters float64, studentUUIDs []string, limit int) ([]dto.StudentDistanceDTO, error)
 	SaveStudent(student entity.Student) error
 	UpdateStudent(student entity.Student) error
 	DeleteStudentWithParents(studentUUID uuid.UUID, schoolUUID, username string) error
@@ -242,7 +246,7 @@ func (repo *StudentRepository) SaveStudent(student entity.Student) error {
 		student.Grade, 
 		student.Status,
 		student.StudentAddress, 
-		student.StudentPickupPoint.String, // Menggunakan String untuk menyimpan nilai JSON
+		student.StudentPickupPoint,
 		student.CreatedBy,
 	)
 	if err != nil {
@@ -279,7 +283,7 @@ func (repo *StudentRepository) UpdateStudent(student entity.Student) error {
 		student.Gender, 
 		student.Grade, 
 		student.StudentAddress, 
-		student.StudentPickupPoint.String, // Menggunakan String untuk menyimpan nilai JSON
+		student.StudentPickupPoint,
 		student.UpdatedBy, 
 		student.UUID, 
 		student.SchoolUUID,
@@ -302,3 +306,57 @@ func (repo *StudentRepository) DeleteStudentWithParents(studentUUID uuid.UUID, s
 
 	return nil
 }
+
+// Present students of the school whose pickup point lies within the radius of
+// any of the points, nearest first. NearestIndex is the index of the closest
+// point. A nil studentUUIDs means every student may match.
+func (repo *StudentRepository) FetchStudentsNearPoints(schoolUUID string, points []geo.GeoPoint, radiusMeters float64, studentUUIDs []string, limit int) ([]dto.StudentDistanceDTO, error) {
+	latitudes := make([]float64, len(points))
+	longitudes := make([]float64, len(points))
+	for i, point := range points {
+		latitudes[i], longitudes[i] = point.Latitude, point.Longitude
+	}
+
+	distance := distanceSQL(repo.db,
+		"json_point_coordinate(s.student_pickup_point, 'latitude')", "json_point_coordinate(s.student_pickup_point, 'longitude')",
+		"c.latitude", "c.longitude")
+
+	query := fmt.Sprintf(`
+		SELECT
+			s.student_uuid,
+			s.student_first_name,
+			s.student_last_name,
+			COALESCE(s.student_address, '') AS student_address,
+			s.student_pickup_point,
+			nearest.distance_meters,
+			nearest.nearest_index
+		FROM students s
+		CROSS JOIN LATERAL (
+			SELECT %s AS distance_meters, (c.idx - 1)::INTEGER AS nearest_index
+			FROM unnest($2::DOUBLE PRECISION[], $3::DOUBLE PRECISION[]) WITH ORDINALITY AS c(latitude, longitude, idx)
+			ORDER BY 1 ASC
+			LIMIT 1
+		) nearest
+		WHERE s.school_uuid = $1 AND s.deleted_at IS NULL
+		AND student_effective_status(s.student_uuid, CURRENT_DATE) = 'present'
+		AND json_point_coordinate(s.student_pickup_point, 'latitude') IS NOT NULL
+		AND json_point_coordinate(s.student_pickup_point, 'longitude') IS NOT NULL
+		AND ($5::TEXT[] IS NULL OR s.student_uuid::TEXT = ANY($5))
+		AND nearest.distance_meters <= $4
+		ORDER BY nearest.distance_meters ASC
+		LIMIT $6
+	`, distance)
+
+	var studentFilter interface{}
+	if studentUUIDs != nil {
+		studentFilter = pq.Array(studentUUIDs)
+	}
+
+	var students []dto.StudentDistanceDTO
+	err := repo.db.Select(&students, query, schoolUUID, pq.Array(latitudes), pq.Array(longitudes), radiusMeters, studentFilter, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return students, nil
+}
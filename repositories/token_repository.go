@@ -0,0 +1,100 @@
+package repositories
+
+import (
+	"time"
+
+	"shuttle/models/entity"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type TokenRepositoryInterface interface {
+	SaveRevokedToken(token entity.RevokedToken) error
+	SaveRevokedTokens(tx *sqlx.Tx, tokens []entity.RevokedToken) error
+	IsAnyTokenRevoked(ids []string) (bool, error)
+	FetchActiveRevokedTokens(now time.Time) ([]entity.RevokedToken, error)
+	DeleteExpiredRevokedTokens(now time.Time) (int64, error)
+	DeleteExpiredSessions(now time.Time) (int64, error)
+}
+
+type tokenRepository struct {
+	DB *sqlx.DB
+}
+
+func NewTokenRepository(DB *sqlx.DB) TokenRepositoryInterface {
+	return &tokenRepository{
+		DB: DB,
+	}
+}
+
+// Revoking the same token twice keeps the first record
+func (r *tokenRepository) SaveRevokedToken(token entity.RevokedToken) error {
+	query := `
+		INSERT INTO revoked_tokens (jti, user_uuid, expires_at, revoked_at)
+		VALUES (:jti, :user_uuid, :expires_at, :revoked_at)
+		ON CONFLICT (jti) DO NOTHING`
+
+	_, err := r.DB.NamedExec(query, token)
+	return err
+}
+
+// Same as SaveRevokedToken, inside the caller's transaction
+func (r *tokenRepository) SaveRevokedTokens(tx *sqlx.Tx, tokens []entity.RevokedToken) error {
+	query := `
+		INSERT INTO revoked_tokens (jti, user_uuid, expires_at, revoked_at)
+		VALUES (:jti, :user_uuid, :expires_at, :revoked_at)
+		ON CONFLICT (jti) DO NOTHING`
+
+	for _, token := range tokens {
+		if _, err := tx.NamedExec(query, token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *tokenRepository) IsAnyTokenRevoked(ids []string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = ANY($1::uuid[]))`
+
+	var revoked bool
+	if err := r.DB.Get(&revoked, query, pq.Array(ids)); err != nil {
+		return false, err
+	}
+
+	return revoked, nil
+}
+
+func (r *tokenRepository) FetchActiveRevokedTokens(now time.Time) ([]entity.RevokedToken, error) {
+	query := `
+		SELECT jti, user_uuid, expires_at, revoked_at
+		FROM revoked_tokens
+		WHERE expires_at > $1
+	`
+
+	var tokens []entity.RevokedToken
+	if err := r.DB.Select(&tokens, query, now); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (r *tokenRepository) DeleteExpiredRevokedTokens(now time.Time) (int64, error) {
+	result, err := r.DB.Exec(`DELETE FROM revoked_tokens WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Sessions past their absolute expiry, their refresh token families go with them
+func (r *tokenRepository) DeleteExpiredSessions(now time.Time) (int64, error) {
+	result, err := r.DB.Exec(`DELETE FROM user_sessions WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
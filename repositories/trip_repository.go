@@ -0,0 +1,314 @@
+package repositories
+
+import (
+	"database/sql"
+	"time"
+
+	"shuttle/models/dto"
+	"shuttle/models/entity"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type TripRepositoryInterface interface {
+	BeginTransaction() (*sqlx.Tx, error)
+
+	FetchTripCandidates(date, schoolUUID string) ([]dto.TripCandidateDTO, error)
+	FetchTripStudents(tx *sqlx.Tx, routeNameUUID, driverUUID, date string) ([]dto.TripStudentDTO, error)
+	SaveTrip(tx *sqlx.Tx, trip entity.Trip) (bool, error)
+	SaveTripManifest(tx *sqlx.Tx, manifest entity.TripManifest) error
+
+	FetchTripsByDriver(driverUUID, date string) ([]dto.TripResponseDTO, error)
+	FetchTripManifest(tripUUID string) ([]dto.TripManifestResponseDTO, error)
+	FetchTripForUpdate(tx *sqlx.Tx, tripUUID string) (entity.Trip, error)
+	FetchTripManifestEntries(tx *sqlx.Tx, tripUUID string) ([]entity.TripManifest, error)
+	HasTripInProgress(tx *sqlx.Tx, driverUUID, exceptTripUUID string) (bool, error)
+	UpdateTripStatus(tx *sqlx.Tx, tripUUID, status string, at time.Time) error
+
+	FetchTodayShuttle(tx *sqlx.Tx, studentUUID, driverUUID string) (string, error)
+	SaveTripShuttle(tx *sqlx.Tx, shuttle entity.Shuttle) error
+	LinkManifestShuttle(tx *sqlx.Tx, manifestID int64, shuttleUUID string) error
+
+	CountTripsByDate(date, status string) (int, error)
+}
+
+type TripRepository struct {
+	DB *sqlx.DB
+}
+
+func NewTripRepository(DB *sqlx.DB) TripRepositoryInterface {
+	return &TripRepository{
+		DB: DB,
+	}
+}
+
+func (r *TripRepository) BeginTransaction() (*sqlx.Tx, error) {
+	return r.DB.Beginx()
+}
+
+// Every route and driver that runs on the date: one row per schedule active
+// that weekday and term, or a single row for routes without schedules
+func (r *TripRepository) FetchTripCandidates(date, schoolUUID string) ([]dto.TripCandidateDTO, error) {
+	query := `
+		SELECT DISTINCT
+			ra.route_uuid AS route_name_uuid,
+			ra.driver_uuid,
+			rt.school_uuid,
+			rs.schedule_uuid::text AS schedule_uuid,
+			rs.direction::text AS direction,
+			TO_CHAR(rs.departure_time, 'HH24:MI') AS departure_time
+		FROM route_assignment ra
+		JOIN routes rt ON rt.route_name_uuid = ra.route_uuid AND rt.deleted_at IS NULL
+		LEFT JOIN route_schedules rs ON rs.route_name_uuid = rt.route_name_uuid AND rs.deleted_at IS NULL
+		WHERE ra.deleted_at IS NULL
+		AND ($2 = '' OR rt.school_uuid::text = $2)
+		AND (
+			rs.schedule_uuid IS NULL
+			OR (
+				EXTRACT(ISODOW FROM $1::date)::INTEGER = ANY(rs.active_days)
+				AND (rs.term_start IS NULL OR rs.term_start <= $1::date)
+				AND (rs.term_end IS NULL OR rs.term_end >= $1::date)
+			)
+		)
+	`
+
+	var candidates []dto.TripCandidateDTO
+	if err := r.DB.Select(&candidates, query, date, schoolUUID); err != nil {
+		return nil, err
+	}
+
+	return candidates, nil
+}
+
+// Students on the driver's part of the route who ride on the date, in route order
+func (r *TripRepository) FetchTripStudents(tx *sqlx.Tx, routeNameUUID, driverUUID, date string) ([]dto.TripStudentDTO, error) {
+	query := `
+		SELECT ra.student_uuid, COALESCE(ra.student_order, 0) AS student_order
+		FROM route_assignment ra
+		JOIN students s ON s.student_uuid = ra.student_uuid
+		WHERE ra.route_uuid = $1 AND ra.driver_uuid = $2
+		AND ra.deleted_at IS NULL AND student_effective_status(s.student_uuid, $3::date) = 'present'
+		ORDER BY COALESCE(ra.student_order, 0) ASC
+	`
+
+	var students []dto.TripStudentDTO
+	if err := tx.Select(&students, query, routeNameUUID, driverUUID, date); err != nil {
+		return nil, err
+	}
+
+	return students, nil
+}
+
+// Insert the trip unless it was already generated; false means it existed
+func (r *TripRepository) SaveTrip(tx *sqlx.Tx, trip entity.Trip) (bool, error) {
+	query := `
+		INSERT INTO trips (trip_id, trip_uuid, route_name_uuid, schedule_uuid, school_uuid, driver_uuid, trip_date, direction, departure_time, status, created_at, created_by)
+		VALUES (:trip_id, :trip_uuid, :route_name_uuid, :schedule_uuid, :school_uuid, :driver_uuid, :trip_date, :direction, :departure_time, :status, :created_at, :created_by)
+		ON CONFLICT (route_name_uuid, driver_uuid, trip_date, COALESCE(schedule_uuid, '00000000-0000-0000-0000-000000000000'::uuid)) DO NOTHING`
+
+	result, err := tx.NamedExec(query, trip)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (r *TripRepository) SaveTripManifest(tx *sqlx.Tx, manifest entity.TripManifest) error {
+	query := `
+		INSERT INTO trip_manifest (manifest_id, trip_uuid, student_uuid, stop_order, created_at)
+		VALUES (:manifest_id, :trip_uuid, :student_uuid, :stop_order, :created_at)`
+
+	_, err := tx.NamedExec(query, manifest)
+	return err
+}
+
+func (r *TripRepository) FetchTripsByDriver(driverUUID, date string) ([]dto.TripResponseDTO, error) {
+	query := `
+		SELECT
+			t.trip_uuid,
+			t.route_name_uuid,
+			COALESCE(rt.route_name, '') AS route_name,
+			COALESCE(t.schedule_uuid::text, '') AS schedule_uuid,
+			t.driver_uuid,
+			TO_CHAR(t.trip_date, 'YYYY-MM-DD') AS trip_date,
+			COALESCE(t.direction::text, '') AS direction,
+			COALESCE(TO_CHAR(t.departure_time, 'HH24:MI'), '') AS departure_time,
+			t.status,
+			COALESCE(TO_CHAR(t.started_at, 'YYYY-MM-DD HH24:MI:SS'), '') AS started_at,
+			COALESCE(TO_CHAR(t.finished_at, 'YYYY-MM-DD HH24:MI:SS'), '') AS finished_at
+		FROM trips t
+		LEFT JOIN routes rt ON rt.route_name_uuid = t.route_name_uuid
+		WHERE t.driver_uuid = $1 AND t.trip_date = $2::date
+		ORDER BY t.departure_time ASC NULLS LAST, t.created_at ASC
+	`
+
+	var trips []dto.TripResponseDTO
+	if err := r.DB.Select(&trips, query, driverUUID, date); err != nil {
+		return nil, err
+	}
+
+	return trips, nil
+}
+
+func (r *TripRepository) FetchTripManifest(tripUUID string) ([]dto.TripManifestResponseDTO, error) {
+	query := `
+		SELECT
+			tm.student_uuid,
+			COALESCE(s.student_first_name, '') AS student_first_name,
+			COALESCE(s.student_last_name, '') AS student_last_name,
+			s.student_pickup_point,
+			tm.stop_order,
+			COALESCE(tm.shuttle_uuid::text, '') AS shuttle_uuid,
+			COALESCE(st.status::text, '') AS shuttle_status
+		FROM trip_manifest tm
+		LEFT JOIN students s ON s.student_uuid = tm.student_uuid
+		LEFT JOIN shuttle st ON st.shuttle_uuid = tm.shuttle_uuid
+		WHERE tm.trip_uuid = $1
+		ORDER BY tm.stop_order ASC
+	`
+
+	var manifest []dto.TripManifestResponseDTO
+	if err := r.DB.Select(&manifest, query, tripUUID); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// Lock the trip row so a start and a finish can't race each other
+func (r *TripRepository) FetchTripForUpdate(tx *sqlx.Tx, tripUUID string) (entity.Trip, error) {
+	query := `
+		SELECT
+			trip_id,
+			trip_uuid,
+			route_name_uuid,
+			schedule_uuid,
+			school_uuid,
+			driver_uuid,
+			TO_CHAR(trip_date, 'YYYY-MM-DD') AS trip_date,
+			direction,
+			TO_CHAR(departure_time, 'HH24:MI') AS departure_time,
+			status,
+			started_at,
+			finished_at,
+			created_at,
+			created_by
+		FROM trips
+		WHERE trip_uuid = $1
+		FOR UPDATE
+	`
+
+	var trip entity.Trip
+	if err := tx.Get(&trip, query, tripUUID); err != nil {
+		return entity.Trip{}, err
+	}
+
+	return trip, nil
+}
+
+func (r *TripRepository) FetchTripManifestEntries(tx *sqlx.Tx, tripUUID string) ([]entity.TripManifest, error) {
+	query := `
+		SELECT manifest_id, trip_uuid, student_uuid, stop_order, shuttle_uuid, created_at
+		FROM trip_manifest
+		WHERE trip_uuid = $1
+		ORDER BY stop_order ASC
+	`
+
+	var entries []entity.TripManifest
+	if err := tx.Select(&entries, query, tripUUID); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Only today's trips count, a trip left unfinished on an earlier day doesn't block the driver
+func (r *TripRepository) HasTripInProgress(tx *sqlx.Tx, driverUUID, exceptTripUUID string) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM trips
+			WHERE driver_uuid = $1 AND status = 'in_progress' AND trip_uuid <> $2
+			AND trip_date = CURRENT_DATE
+		)
+	`
+
+	var exists bool
+	if err := tx.Get(&exists, query, driverUUID, exceptTripUUID); err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// Move the trip to a new status, stamping when it started or finished
+func (r *TripRepository) UpdateTripStatus(tx *sqlx.Tx, tripUUID, status string, at time.Time) error {
+	query := `
+		UPDATE trips
+		SET status = $1,
+			started_at = CASE WHEN $1 = 'in_progress' THEN $2 ELSE started_at END,
+			finished_at = CASE WHEN $1 IN ('completed', 'cancelled') THEN $2 ELSE finished_at END,
+			updated_at = $2
+		WHERE trip_uuid = $3
+	`
+
+	_, err := tx.Exec(query, status, at, tripUUID)
+	return err
+}
+
+// Today's shuttle row for the student with this driver, e.g. from the morning trip
+func (r *TripRepository) FetchTodayShuttle(tx *sqlx.Tx, studentUUID, driverUUID string) (string, error) {
+	query := `
+		SELECT shuttle_uuid
+		FROM shuttle
+		WHERE student_uuid = $1 AND driver_uuid = $2
+		AND DATE(created_at) = CURRENT_DATE AND deleted_at IS NULL
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+
+	var shuttleUUID string
+	if err := tx.Get(&shuttleUUID, query, studentUUID, driverUUID); err != nil {
+		return "", err
+	}
+
+	return shuttleUUID, nil
+}
+
+func (r *TripRepository) SaveTripShuttle(tx *sqlx.Tx, shuttle entity.Shuttle) error {
+	query := `
+		INSERT INTO shuttle (shuttle_id, shuttle_uuid, student_uuid, driver_uuid, trip_uuid, status, created_at)
+		VALUES (:shuttle_id, :shuttle_uuid, :student_uuid, :driver_uuid, :trip_uuid, :status, :created_at)`
+
+	_, err := tx.NamedExec(query, shuttle)
+	return err
+}
+
+func (r *TripRepository) LinkManifestShuttle(tx *sqlx.Tx, manifestID int64, shuttleUUID string) error {
+	_, err := tx.Exec(`UPDATE trip_manifest SET shuttle_uuid = $1 WHERE manifest_id = $2`, shuttleUUID, manifestID)
+	return err
+}
+
+// Trips on a date, optionally only those in one status
+func (r *TripRepository) CountTripsByDate(date, status string) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM trips
+		WHERE trip_date = $1::date AND ($2 = '' OR status::text = $2)
+	`
+
+	var total int
+	if err := r.DB.Get(&total, query, date, status); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return total, nil
+}
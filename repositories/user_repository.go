@@ -48,6 +48,7 @@ type UserRepositoryInterface interface {
 	UpdateUser(tx *sqlx.Tx, user entity.User, userUUID string) error
 	UpdateUserPicture(userUUID uuid.UUID, picture, db string) error
 	UpdateUserStatus(userUUID uuid.UUID, status string, time time.Time) error
+	MarkStaleUsersOffline(before time.Time) (int64, error)
 	UpdateSuperAdminDetails(tx *sqlx.Tx, details entity.SuperAdminDetails, userUUID string) error
 	UpdateSchoolAdminDetails(tx *sqlx.Tx, details entity.SchoolAdminDetails, userUUID string) error
 	UpdateParentDetails(tx *sqlx.Tx, details entity.ParentDetails, userUUID string) error
@@ -1038,6 +1039,21 @@ func (r *userRepository) UpdateUserStatus(userUUID uuid.UUID, status string, tim
 	return nil
 }
 
+func (r *userRepository) MarkStaleUsersOffline(before time.Time) (int64, error) {
+	query := `UPDATE users SET user_status = 'offline' WHERE user_status = 'online' AND user_last_active < $1`
+	res, err := r.DB.Exec(query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return rowsAffected, nil
+}
+
 func (r *userRepository) DeleteSuperAdmin(tx *sqlx.Tx, userUUID uuid.UUID, user_name string) error {
 	query := `UPDATE users SET deleted_at = NOW(), deleted_by = $1 WHERE user_uuid = $2`
 	res, err := tx.Exec(query, user_name, userUUID)
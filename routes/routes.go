@@ -1,7 +1,10 @@
 package routes
 
 import (
+	"time"
+
 	"shuttle/handler"
+	"shuttle/logger"
 	"shuttle/middleware"
 	"shuttle/repositories"
 	"shuttle/services"
@@ -21,37 +24,62 @@ func Route(r *fiber.App, db *sqlx.DB) {
 	routeRepository := repositories.NewRouteRepository(db)
 	childernRepository := repositories.NewChildernRepository(db)
 	shuttleRepository := repositories.NewShuttleRepository(db)
+	locationRepository := repositories.NewLocationRepository(db)
+	tripRepository := repositories.NewTripRepository(db)
+	tokenRepository := repositories.NewTokenRepository(db)
+	loginAttemptRepository := repositories.NewLoginAttemptRepository(db)
+	passwordResetRepository := repositories.NewPasswordResetRepository(db)
 	
+	broker, err := utils.NewBroker(db)
+	if err != nil {
+		logger.LogFatal(err, "Failed to start WebSocket broker", nil)
+	}
+	hub := utils.NewHub(broker)
+
 	userService := services.NewUserService(userRepository)
-	authService := services.NewAuthService(authRepository, userRepository)
 	schoolService := services.NewSchoolService(schoolRepository, userRepository)
 	vehicleService := services.NewVehicleService(vehicleRepository)
-	studentService := services.NewStudentService(studentRepository, &userService, userRepository)
+	studentService := services.NewStudentService(studentRepository, &userService, userRepository, routeRepository)
 	routeService := services.NewRouteService(routeRepository)
 	childernService := services.NewChildernService(childernRepository)
-	shuttleService := services.NewShuttleService(shuttleRepository)
+	etaService := services.NewETAService(routeRepository, locationRepository, hub)
+	shuttleService := services.NewShuttleService(shuttleRepository, locationRepository, etaService)
+	tripService := services.NewTripService(tripRepository)
+	tokenRevocationService := services.NewTokenRevocationService(tokenRepository)
+	authService := services.NewAuthService(authRepository, userRepository, tokenRevocationService)
+	loginAttemptService := services.NewLoginAttemptService(loginAttemptRepository)
+	passwordResetService := services.NewPasswordResetService(passwordResetRepository, userRepository, &authService, utils.NewMailer())
 	
-	authHandler := handler.NewAuthHttpHandler(authService, userService)
+	authHandler := handler.NewAuthHttpHandler(authService, userService, loginAttemptService, passwordResetService, hub)
 	userHandler := handler.NewUserHttpHandler(userService, schoolService, vehicleService)
 	schoolHandler := handler.NewSchoolHttpHandler(schoolService)
 	vehicleHandler := handler.NewVehicleHttpHandler(vehicleService)
 	studentHandler := handler.NewStudentHttpHandler(studentService)
 	routeHandler := handler.NewRouteHttpHandler(routeService)
 	childernHandler := handler.NewChildernHandler(childernService)
-	shuttleHandler := handler.NewShuttleHandler(shuttleService)
+	shuttleHandler := handler.NewShuttleHandler(shuttleService, tripService, hub)
+	tripHandler := handler.NewTripHttpHandler(tripService)
+
+	geofenceService := services.NewGeofenceService(shuttleRepository, shuttleService, hub)
+	go geofenceService.RunSweep()
 
-	wsService := utils.NewWebSocketService(userRepository, authRepository, shuttleRepository)
+	wsService := utils.NewWebSocketService(userRepository, authRepository, shuttleRepository, locationRepository, hub, geofenceService, etaService)
+	go wsService.RunPresenceSweeper()
+	go tripService.RunDailyGeneration()
+	go tokenRevocationService.RunPurge()
 	
 	////////////////////////////////////// PUBLIC //////////////////////////////////////
 
-	r.Post("login", authHandler.Login)
-	r.Post("/refresh-token", authHandler.IssueNewAccessToken)
+	r.Post("login", middleware.RateLimitMiddleware(utils.IntFromConfig("LOGIN_RATE_LIMIT", 10), time.Minute), authHandler.Login)
+	r.Post("/refresh-token", middleware.RateLimitMiddleware(utils.IntFromConfig("REFRESH_RATE_LIMIT", 30), time.Minute), authHandler.IssueNewAccessToken)
+	r.Post("/forgot-password", middleware.RateLimitMiddleware(utils.IntFromConfig("PASSWORD_RESET_RATE_LIMIT", 5), time.Minute), authHandler.ForgotPassword)
+	r.Post("/reset-password", middleware.RateLimitMiddleware(utils.IntFromConfig("PASSWORD_RESET_RATE_LIMIT", 5), time.Minute), authHandler.ResetPassword)
 	r.Static("/assets", "./assets")
 
 	////////////////////////////////////// AUTHENTICATED //////////////////////////////////////
 
 	protected := r.Group("/api")
-	protected.Use(middleware.AuthenticationMiddleware())
+	protected.Use(middleware.AuthenticationMiddleware(tokenRevocationService))
 	protected.Use(middleware.AuthorizationMiddleware([]string{"SA", "AS", "D", "P"}))
 
 	protected.Use("/ws", func(c *fiber.Ctx) error {
@@ -60,6 +88,8 @@ func Route(r *fiber.App, db *sqlx.DB) {
 		}
 		return fiber.ErrUpgradeRequired
 	})
+	// Without a shuttle the connection only receives messages addressed to the user, e.g. ETA and handover pushes
+	protected.Get("/ws", websocket.New(wsService.HandleWebSocketConnection))
 	protected.Get("/ws/:id", websocket.New(wsService.HandleWebSocketConnection))
 
 	protected.Get("/my/profile", authHandler.GetMyProfile)
@@ -70,6 +100,8 @@ func Route(r *fiber.App, db *sqlx.DB) {
 
 	protected.Post("/logout", authHandler.Logout)
 	protected.Post("/device-token", authHandler.AddDeviceToken)
+	protected.Get("/my/sessions", authHandler.GetMySessions)
+	protected.Delete("/my/sessions/:id", authHandler.RevokeMySession)
 
 	////////////////////////////////////// SUPER ADMIN //////////////////////////////////////
 	
@@ -93,6 +125,8 @@ func Route(r *fiber.App, db *sqlx.DB) {
 	protectedSuperAdmin.Delete("/user/sa/delete/:id", userHandler.DeleteSuperAdmin)
 	protectedSuperAdmin.Delete("/user/as/delete/:id", userHandler.DeleteSchoolAdmin)
 	protectedSuperAdmin.Delete("/user/driver/delete/:id", userHandler.DeleteDriver)
+	protectedSuperAdmin.Post("/user/logout/:id", authHandler.ForceLogoutUser)
+	protectedSuperAdmin.Get("/login-attempts", authHandler.GetLoginAttempts)
 
 	// SCHOOL FOR SUPERADMIN
 	protectedSuperAdmin.Get("/school/all", schoolHandler.GetAllSchools)
@@ -122,6 +156,7 @@ func Route(r *fiber.App, db *sqlx.DB) {
 
 	// STUDENT FOR SCHOOL ADMIN
 	protectedSchoolAdmin.Get("/student/all", studentHandler.GetAllStudentWithParents)
+	protectedSchoolAdmin.Get("/student/nearby", studentHandler.GetNearbyStudents)
 	protectedSchoolAdmin.Get("/student/:id", studentHandler.GetSpecStudentWithParents)
 	protectedSchoolAdmin.Post("/student/add", studentHandler.AddSchoolStudentWithParents)
 	protectedSchoolAdmin.Put("/student/update/:id", studentHandler.UpdateSchoolStudentWithParents)
@@ -142,23 +177,46 @@ func Route(r *fiber.App, db *sqlx.DB) {
 	// ROUTE FOR SCHOOL ADMIN
 	protectedSchoolAdmin.Get("/route/all", routeHandler.GetAllRoutesByAS)
 	protectedSchoolAdmin.Get("/route/:id", routeHandler.GetSpecRouteByAS)
+	protectedSchoolAdmin.Get("/route/:id/geojson", routeHandler.GetRouteGeoJSON)
+	protectedSchoolAdmin.Get("/route/:id/candidates", studentHandler.GetRouteCandidates)
 	protectedSchoolAdmin.Post("/route/add", routeHandler.AddRoute)
+	protectedSchoolAdmin.Post("/route/optimize", routeHandler.OptimizeRoute)
 	protectedSchoolAdmin.Put("/route/update/:id", routeHandler.UpdateRoute)
 	protectedSchoolAdmin.Delete("/route/delete/:id", routeHandler.DeleteRoute)
 
+	protectedSchoolAdmin.Post("/trip/generate", tripHandler.GenerateTrips)
+
+	protectedSchoolAdmin.Get("/shuttle/:id/trail", shuttleHandler.GetShuttleTrail)
+	protectedSchoolAdmin.Get("/shuttle/:id/history", shuttleHandler.GetShuttleStatusHistory)
+	protectedSchoolAdmin.Get("/handover", shuttleHandler.GetStudentHandovers)
+
 	//ROUTE FOR DRIVER
 	protectedDriver.Get("/route/all", routeHandler.GetAllRoutesByDriver)
+	protectedDriver.Get("/route/itinerary", routeHandler.GetDriverItinerary)
 
 	protectedParent.Get("/my/childern/track", shuttleHandler.GetShuttleTrackByParent) //buat menu track
 	protectedParent.Get("/my/childern/all", childernHandler.GetAllChilderns) //buat menu apalah
 	protectedParent.Get("/my/childern/shuttle/:id", shuttleHandler.GetSpecShuttle) //buat menu opo jeneng e lali😂 (spec shutle)
 	protectedParent.Get("/my/childern/recap", shuttleHandler.GetAllShuttleByParent) //buat menu recap
+	protectedParent.Get("/my/childern/shuttle/:id/trail", shuttleHandler.GetShuttleTrail)
+	protectedParent.Get("/my/childern/shuttle/:id/history", shuttleHandler.GetShuttleStatusHistory)
+	protectedParent.Get("/my/childern/:id/absence", childernHandler.GetAbsences)
+	protectedParent.Post("/my/childern/:id/absence", childernHandler.AddAbsence)
+	protectedParent.Delete("/my/childern/absence/:id", childernHandler.CancelAbsence)
 	protectedParent.Get("/my/childern/:id", childernHandler.GetSpecChildern) //nih katanya butuh spec
 	protectedParent.Put("/my/childern/update/:id", childernHandler.UpdateChildern) //menu update nih tampling
 	protectedParent.Put("/my/childern/status/update/:id", childernHandler.UpdateChildernStatus) //menu update nih tampling
 
+	protectedDriver.Get("/trip/today", tripHandler.GetDriverTripsToday)
+	protectedDriver.Post("/trip/:id/start", tripHandler.StartTrip)
+	protectedDriver.Post("/trip/:id/finish", tripHandler.FinishTrip)
+
 	protectedDriver.Get("/shuttle/all", shuttleHandler.GetAllShuttleByDriver)
 	protectedDriver.Post("/shuttle/add", shuttleHandler.AddShuttle)
 	protectedDriver.Get("/shuttle/:id", shuttleHandler.GetSpecShuttle)
+	protectedDriver.Get("/shuttle/:id/trail", shuttleHandler.GetShuttleTrail)
+	protectedDriver.Get("/shuttle/:id/history", shuttleHandler.GetShuttleStatusHistory)
+	protectedDriver.Post("/shuttle/:id/board", shuttleHandler.BoardStudent)
+	protectedDriver.Post("/shuttle/:id/alight", shuttleHandler.AlightStudent)
 	protectedDriver.Put("/shuttle/update/:id", shuttleHandler.EditShuttle) 
 }
\ No newline at end of file
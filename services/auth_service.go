@@ -1,7 +1,6 @@
 package services
 
 import (
-	"context"
 	"encoding/json"
 	"path/filepath"
 	"time"
@@ -9,37 +8,43 @@ import (
 	"shuttle/errors"
 	"shuttle/logger"
 	"shuttle/models/dto"
-	"shuttle/models/entity"
 	"shuttle/repositories"
 	"shuttle/utils"
 
 	// "shuttle/utils"
 
 	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
 	"github.com/spf13/viper"
 )
 
 type AuthServiceInterface interface {
 	Login(email, password string) (userDataa dto.UserDataOnLoginDTO, err error)
 	GetMyProfile(userUUID, roleCode string) (interface{}, error)
-	CheckStoredRefreshToken(userID string, refreshToken string) error
-	DeleteRefreshTokenOnLogout(ctx context.Context, userID string) error
+	StartSession(user dto.UserDataOnLoginDTO, device dto.SessionDeviceDTO) (dto.RefreshedTokenDTO, error)
+	RotateRefreshToken(refreshToken string) (dto.RefreshedTokenDTO, error)
+	GetMySessions(userUUID, currentSessionUUID string) ([]dto.UserSessionResponseDTO, error)
+	EndSession(userUUID, sessionUUID, reason string) error
+	EndAllSessions(userUUID, reason string) (int, error)
+	RevokeAllSessions(tx *sqlx.Tx, userUUID uuid.UUID, reason string, at time.Time) ([]uuid.UUID, error)
+	MarkSessionsRevoked(sessionUUIDs []uuid.UUID, at time.Time)
 	UpdateUserStatus(userUUID, status string, lastActive time.Time) error
-	UpdateRefreshToken(userUUID, refreshToken, newRefreshToken string) error
 	// GenerateFCMToken(userUUID, token string) (string, error)
-	AddDeviceToken(userUUID, fcmToken string) error
+	AddDeviceToken(sessionUUID, fcmToken string) error
 	ChangePassword(userUUID, newPassword string) error
 }
 
 type AuthService struct {
-	authRepository repositories.AuthRepositoryInterface
-	userRepository repositories.UserRepositoryInterface
+	authRepository         repositories.AuthRepositoryInterface
+	userRepository         repositories.UserRepositoryInterface
+	tokenRevocationService TokenRevocationServiceInterface
 }
 
-func NewAuthService(authRepository repositories.AuthRepositoryInterface, userRepository repositories.UserRepositoryInterface) AuthService {
+func NewAuthService(authRepository repositories.AuthRepositoryInterface, userRepository repositories.UserRepositoryInterface, tokenRevocationService TokenRevocationServiceInterface) AuthService {
 	return AuthService{
-		authRepository: authRepository,
-		userRepository: userRepository,
+		authRepository:         authRepository,
+		userRepository:         userRepository,
+		tokenRevocationService: tokenRevocationService,
 	}
 }
 
@@ -222,32 +227,6 @@ func (service *AuthService) ChangePassword(userUUID, newPassword string) error {
 	return nil
 }
 
-func (service *AuthService) CheckStoredRefreshToken(userUUID string, refreshToken string) error {
-	refreshTokenEntity, err := service.authRepository.CheckRefreshTokenData(userUUID, refreshToken)
-	if err != nil {
-		return err
-	}
-
-	if refreshTokenEntity.RefreshToken != refreshToken {
-		return errors.New("invalid refresh token", 0)
-	}
-
-	if refreshTokenEntity.ExpiredAt.Before(time.Now()) {
-		return errors.New("refresh token has expired", 0)
-	}
-
-	return nil
-}
-
-func (service *AuthService) DeleteRefreshTokenOnLogout(ctx context.Context, userUUID string) error {
-	err := service.authRepository.DeleteRefreshToken(ctx, userUUID)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
 func (service *AuthService) UpdateUserStatus(userUUID, status string, lastActive time.Time) error {
 	err := service.authRepository.UpdateUserStatus(userUUID, status, lastActive)
 	if err != nil {
@@ -257,24 +236,6 @@ func (service *AuthService) UpdateUserStatus(userUUID, status string, lastActive
 	return nil
 }
 
-func (service *AuthService) UpdateRefreshToken(userUUID, refreshToken, newRefreshToken string) error {
-	tokendata, err := service.authRepository.CheckRefreshTokenData(userUUID, refreshToken)
-	if err != nil {
-		return errors.New("Invalid refresh token", 401)
-	}
-
-	if tokendata.LastUsedAt != nil && time.Since(*tokendata.LastUsedAt) < time.Second {
-		return errors.New("cannot reissue a new access token yet", 0)
-	}
-
-	err = service.authRepository.UpdateRefreshToken(userUUID, newRefreshToken)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
 // func (service *AuthService) GenerateFCMToken() (string, error) {
 // 	tp, err := utils.NewTokenProvider()
 // 	if err != nil {
@@ -291,22 +252,6 @@ func (service *AuthService) UpdateRefreshToken(userUUID, refreshToken, newRefres
 // 	return token, nil
 // }
 
-func (service *AuthService) AddDeviceToken(userUUID, fcmToken string) error {
-	FCMTokenData := entity.FCMToken{
-		ID:          time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
-		UserUUID:    uuid.MustParse(userUUID),
-		DeviceToken: fcmToken,
-		CreatedAt:   time.Now(),
-	}
-
-	err := service.authRepository.SaveDeviceToken(FCMTokenData)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
 func generateImageURL(imagePath string) (string, error) {
 	fileName := filepath.Base(imagePath)
 	allowedExtensions := []string{".jpg", ".jpeg", ".png"}
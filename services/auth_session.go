@@ -0,0 +1,331 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"shuttle/errors"
+	"shuttle/logger"
+	"shuttle/models/dto"
+	"shuttle/models/entity"
+	"shuttle/utils"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	SessionRevokedLogout     = "logout"
+	SessionRevokedSignedOut  = "signed_out"
+	SessionRevokedForced     = "forced_logout"
+	RefreshTokenRevokedReuse = "reuse_detected"
+)
+
+// Open a session for the device the user signed in from, its refresh token
+// starts a family carrying the session UUID
+func (service *AuthService) StartSession(user dto.UserDataOnLoginDTO, device dto.SessionDeviceDTO) (dto.RefreshedTokenDTO, error) {
+	parsedUserUUID, err := uuid.Parse(user.UserUUID)
+	if err != nil {
+		return dto.RefreshedTokenDTO{}, err
+	}
+
+	userID := fmt.Sprintf("%d", user.UserID)
+	sessionUUID := uuid.New()
+
+	refreshToken, meta, err := utils.GenerateRefreshToken(userID, user.UserUUID, user.Username, user.RoleCode, sessionUUID)
+	if err != nil {
+		return dto.RefreshedTokenDTO{}, err
+	}
+
+	now := time.Now()
+	session := entity.UserSession{
+		SessionID:   time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
+		SessionUUID: sessionUUID,
+		UserUUID:    parsedUserUUID,
+		DeviceName:  sessionField(device.DeviceName, 100),
+		Platform:    sessionField(strings.ToLower(device.Platform), 20),
+		IPAddress:   sessionField(device.IPAddress, 45),
+		UserAgent:   sessionField(device.UserAgent, 255),
+		CreatedAt:   now,
+		LastUsedAt:  now,
+		ExpiresAt:   meta.AbsoluteExpiresAt,
+	}
+
+	tx, err := service.authRepository.BeginTransaction()
+	if err != nil {
+		return dto.RefreshedTokenDTO{}, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := service.authRepository.SaveUserSession(tx, session); err != nil {
+		return dto.RefreshedTokenDTO{}, fmt.Errorf("failed to save session: %w", err)
+	}
+
+	if err := service.authRepository.SaveRefreshToken(tx, refreshTokenEntity(parsedUserUUID, meta)); err != nil {
+		return dto.RefreshedTokenDTO{}, fmt.Errorf("failed to save refresh token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return dto.RefreshedTokenDTO{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	// The app can still register its push token later through /device-token
+	if device.FCMToken != "" {
+		if _, err := service.authRepository.SetSessionFCMToken(sessionUUID, device.FCMToken); err != nil {
+			logger.LogError(err, "Failed to save FCM token", map[string]interface{}{
+				"session_uuid": sessionUUID.String(),
+			})
+		}
+	}
+
+	return dto.RefreshedTokenDTO{
+		UserID:       userID,
+		UserUUID:     user.UserUUID,
+		Username:     user.Username,
+		RoleCode:     user.RoleCode,
+		SessionUUID:  sessionUUID.String(),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// Exchange a refresh token for the next one in its family. Every token can be
+// used once; a rotated token showing up again means it leaked, so the whole
+// session is ended and has to log in again.
+func (service *AuthService) RotateRefreshToken(refreshToken string) (dto.RefreshedTokenDTO, error) {
+	claims, err := utils.ValidateToken(refreshToken)
+	if err != nil || claims["typ"] != utils.TokenTypeRefresh {
+		return dto.RefreshedTokenDTO{}, errors.New("invalid refresh token", 401)
+	}
+
+	jti, _ := claims["jti"].(string)
+	userID, _ := claims["sub"].(string)
+	userUUID, _ := claims["user_uuid"].(string)
+	username, _ := claims["user_name"].(string)
+	roleCode, _ := claims["role_code"].(string)
+	if _, err := uuid.Parse(jti); err != nil {
+		return dto.RefreshedTokenDTO{}, errors.New("invalid refresh token", 401)
+	}
+
+	tx, err := service.authRepository.BeginTransaction()
+	if err != nil {
+		return dto.RefreshedTokenDTO{}, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stored, err := service.authRepository.FetchRefreshTokenForUpdate(tx, jti)
+	if err == sql.ErrNoRows || (err == nil && stored.UserUUID.String() != userUUID) {
+		return dto.RefreshedTokenDTO{}, errors.New("invalid refresh token", 401)
+	}
+	if err != nil {
+		return dto.RefreshedTokenDTO{}, err
+	}
+
+	now := time.Now()
+	if stored.Revoked {
+		return dto.RefreshedTokenDTO{}, errors.New("refresh token has been revoked", 401)
+	}
+
+	if stored.RotatedAt != nil {
+		if _, err := service.authRepository.RevokeSession(tx, stored.FamilyID, stored.UserUUID, RefreshTokenRevokedReuse, now); err != nil {
+			return dto.RefreshedTokenDTO{}, fmt.Errorf("failed to revoke session: %w", err)
+		}
+		if err := service.authRepository.RevokeRefreshTokenFamily(tx, stored.FamilyID, RefreshTokenRevokedReuse, now); err != nil {
+			return dto.RefreshedTokenDTO{}, fmt.Errorf("failed to revoke refresh token family: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return dto.RefreshedTokenDTO{}, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		if err := service.revokeSessionAccess(stored.FamilyID, stored.UserUUID, now); err != nil {
+			return dto.RefreshedTokenDTO{}, err
+		}
+
+		logger.LogWarn("Refresh token reuse detected, session revoked", map[string]interface{}{
+			"user_uuid":    userUUID,
+			"session_uuid": stored.FamilyID.String(),
+		})
+		return dto.RefreshedTokenDTO{}, errors.New("refresh token was already used, please log in again", 401)
+	}
+
+	if !stored.ExpiredAt.After(now) || !stored.AbsoluteExpiredAt.After(now) {
+		return dto.RefreshedTokenDTO{}, errors.New("refresh token has expired", 401)
+	}
+
+	newRefreshToken, meta, err := utils.RotateRefreshToken(userID, userUUID, username, roleCode, stored.FamilyID, stored.AbsoluteExpiredAt)
+	if err != nil {
+		return dto.RefreshedTokenDTO{}, err
+	}
+
+	if err := service.authRepository.SaveRefreshToken(tx, refreshTokenEntity(stored.UserUUID, meta)); err != nil {
+		return dto.RefreshedTokenDTO{}, fmt.Errorf("failed to save refresh token: %w", err)
+	}
+
+	if err := service.authRepository.MarkRefreshTokenRotated(tx, stored.JTI, meta.JTI, now); err != nil {
+		return dto.RefreshedTokenDTO{}, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	if err := service.authRepository.TouchSession(tx, stored.FamilyID, now); err != nil {
+		return dto.RefreshedTokenDTO{}, fmt.Errorf("failed to update session: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return dto.RefreshedTokenDTO{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return dto.RefreshedTokenDTO{
+		UserID:       userID,
+		UserUUID:     userUUID,
+		Username:     username,
+		RoleCode:     roleCode,
+		SessionUUID:  stored.FamilyID.String(),
+		RefreshToken: newRefreshToken,
+	}, nil
+}
+
+func (service *AuthService) GetMySessions(userUUID, currentSessionUUID string) ([]dto.UserSessionResponseDTO, error) {
+	sessions, err := service.authRepository.FetchActiveSessions(userUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].SessionUUID == currentSessionUUID
+	}
+
+	return sessions, nil
+}
+
+// Sign one device out: its refresh tokens stop working at once and so do the
+// access tokens already handed to it
+func (service *AuthService) EndSession(userUUID, sessionUUID, reason string) error {
+	parsedUserUUID, err := uuid.Parse(userUUID)
+	if err != nil {
+		return errors.New("invalid user UUID format", 400)
+	}
+	parsedSessionUUID, err := uuid.Parse(sessionUUID)
+	if err != nil {
+		return errors.New("invalid session UUID format", 400)
+	}
+
+	tx, err := service.authRepository.BeginTransaction()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	revoked, err := service.authRepository.RevokeSession(tx, parsedSessionUUID, parsedUserUUID, reason, now)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if !revoked {
+		return errors.New("session not found", 404)
+	}
+
+	if err := service.authRepository.RevokeRefreshTokenFamily(tx, parsedSessionUUID, reason, now); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return service.revokeSessionAccess(parsedSessionUUID, parsedUserUUID, now)
+}
+
+// Sign the user out of every device, returns how many sessions were ended
+func (service *AuthService) EndAllSessions(userUUID, reason string) (int, error) {
+	parsedUserUUID, err := uuid.Parse(userUUID)
+	if err != nil {
+		return 0, errors.New("invalid user UUID format", 400)
+	}
+
+	tx, err := service.authRepository.BeginTransaction()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	sessionUUIDs, err := service.RevokeAllSessions(tx, parsedUserUUID, reason, now)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	service.MarkSessionsRevoked(sessionUUIDs, now)
+
+	return len(sessionUUIDs), nil
+}
+
+// Revoke every session, refresh token and session access token of the user
+// within the caller's transaction. After committing, the caller hands the
+// returned sessions to MarkSessionsRevoked.
+func (service *AuthService) RevokeAllSessions(tx *sqlx.Tx, userUUID uuid.UUID, reason string, at time.Time) ([]uuid.UUID, error) {
+	sessionUUIDs, err := service.authRepository.RevokeUserSessions(tx, userUUID, reason, at)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	if err := service.authRepository.RevokeUserRefreshTokens(tx, userUUID, reason, at); err != nil {
+		return nil, fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+
+	if err := service.tokenRevocationService.RevokeInTransaction(tx, userUUID, sessionUUIDs, at.Add(utils.AccessTokenTTL)); err != nil {
+		return nil, fmt.Errorf("failed to revoke session access tokens: %w", err)
+	}
+
+	return sessionUUIDs, nil
+}
+
+func (service *AuthService) MarkSessionsRevoked(sessionUUIDs []uuid.UUID, at time.Time) {
+	service.tokenRevocationService.MarkRevoked(sessionUUIDs, at.Add(utils.AccessTokenTTL))
+}
+
+func (service *AuthService) AddDeviceToken(sessionUUID, fcmToken string) error {
+	parsedSessionUUID, err := uuid.Parse(sessionUUID)
+	if err != nil {
+		return errors.New("invalid session UUID format", 400)
+	}
+
+	saved, err := service.authRepository.SetSessionFCMToken(parsedSessionUUID, fcmToken)
+	if err != nil {
+		return err
+	}
+	if !saved {
+		return errors.New("session not found", 404)
+	}
+
+	return nil
+}
+
+// Access tokens of a session are revoked through its UUID for as long as any of them can still be valid
+func (service *AuthService) revokeSessionAccess(sessionUUID, userUUID uuid.UUID, at time.Time) error {
+	if err := service.tokenRevocationService.Revoke(sessionUUID.String(), userUUID.String(), at.Add(utils.AccessTokenTTL)); err != nil {
+		return fmt.Errorf("failed to revoke session access tokens: %w", err)
+	}
+	return nil
+}
+
+func refreshTokenEntity(userUUID uuid.UUID, meta utils.RefreshTokenMeta) entity.RefreshToken {
+	return entity.RefreshToken{
+		ID:                time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
+		UserUUID:          userUUID,
+		JTI:               meta.JTI,
+		FamilyID:          meta.FamilyID,
+		IssuedAt:          time.Now(),
+		ExpiredAt:         meta.ExpiresAt,
+		AbsoluteExpiredAt: meta.AbsoluteExpiresAt,
+	}
+}
+
+func sessionField(value string, maxLength int) sql.NullString {
+	value = strings.TrimSpace(value)
+	if runes := []rune(value); len(runes) > maxLength {
+		value = string(runes[:maxLength])
+	}
+	return sql.NullString{String: value, Valid: value != ""}
+}
@@ -2,10 +2,15 @@ package services
 
 import (
 	"database/sql"
-	"encoding/json"
+	"fmt"
+	"shuttle/errors"
 	"shuttle/models/dto"
 	"shuttle/models/entity"
+	"shuttle/models/geo"
 	"shuttle/repositories"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 type ChildernServiceInterface interface {
@@ -13,6 +18,10 @@ type ChildernServiceInterface interface {
 	GetSpecChildern(id string) (dto.StudentResponseDTO, error)
 	UpdateChildern(id string, req dto.StudentRequestByParentDTO, username string) error
 	UpdateChildernStatus(id string, req dto.StudentStatusRequestByParentDTO, username string) error
+
+	AddAbsence(studentUUID, parentUUID string, req dto.StudentAbsenceRequestDTO, username string) (dto.StudentAbsenceResponseDTO, error)
+	GetAbsences(studentUUID, parentUUID string) ([]dto.StudentAbsenceResponseDTO, error)
+	CancelAbsence(absenceUUID, parentUUID, username string) error
 }
 
 type ChildernService struct {
@@ -40,7 +49,7 @@ func (service *ChildernService) GetAllChilderns(id string) ([]dto.StudentRespons
 			LastName:       childern.LastName,
 			Grade:          childern.Grade,
 			StudentAddress: childern.StudentAddress.String,
-			PickupPoint:    childern.StudentPickupPoint.String,
+			PickupPoint:    childern.StudentPickupPoint,
 			Status:         childern.Status,
 			Gender:         childern.Gender,
 			SchoolUUID:     childern.SchoolUUID.String(),
@@ -62,18 +71,13 @@ func (service *ChildernService) GetSpecChildern(id string) (dto.StudentResponseD
 		Address = childern.StudentAddress.String
 	}
 
-	pickupPoint := ""
-	if childern.StudentPickupPoint.Valid {
-		pickupPoint = childern.StudentPickupPoint.String
-	}
-
 	studentDTO := dto.StudentResponseDTO{
 		UUID:           childern.UUID.String(),
 		FirstName:      childern.FirstName,
 		LastName:       childern.LastName,
 		Gender:         childern.Gender,
 		StudentAddress: Address,
-		PickupPoint:    pickupPoint,
+		PickupPoint:    childern.StudentPickupPoint,
 		Grade:          childern.Grade,
 		Status:         childern.Status,
 		SchoolUUID:     childern.SchoolUUID.String(),
@@ -83,21 +87,12 @@ func (service *ChildernService) GetSpecChildern(id string) (dto.StudentResponseD
 }
 
 func (service *ChildernService) UpdateChildern(id string, req dto.StudentRequestByParentDTO, username string) error {
-	var pickupPointJSON []byte
-	var err error
-	if req.StudentPickupPoint != nil {
-		pickupPointJSON, err = json.Marshal(req.StudentPickupPoint)
-		if err != nil {
-			return err
-		}
-	}
-
 	student := entity.Student{
 		FirstName:          req.StudentFirstName,
 		LastName:           req.StudentLastName,
 		Gender:             string(req.StudentGender),
 		StudentAddress:     sql.NullString{String: req.StudentAddress, Valid: req.StudentAddress != ""},
-		StudentPickupPoint: sql.NullString{String: string(pickupPointJSON), Valid: req.StudentPickupPoint != nil},
+		StudentPickupPoint: geo.NewNullGeoPoint(req.StudentPickupPoint),
 		Status:             req.StudentStatus,
 		UpdatedBy:          sql.NullString{String: username, Valid: username != ""},
 	}
@@ -113,3 +108,157 @@ func (service *ChildernService) UpdateChildernStatus(id string, req dto.StudentS
 
 	return service.ChildernRepository.UpdateChildernStatus(student, id)
 }
+
+func (service *ChildernService) checkParent(studentUUID, parentUUID string) error {
+	if _, err := uuid.Parse(studentUUID); err != nil {
+		return errors.New("invalid student ID format", 400)
+	}
+
+	isParent, err := service.ChildernRepository.IsParentOfStudent(parentUUID, studentUUID)
+	if err != nil {
+		return err
+	}
+	if !isParent {
+		return errors.New("student not found", 404)
+	}
+	return nil
+}
+
+func (service *ChildernService) AddAbsence(studentUUID, parentUUID string, req dto.StudentAbsenceRequestDTO, username string) (dto.StudentAbsenceResponseDTO, error) {
+	if err := service.checkParent(studentUUID, parentUUID); err != nil {
+		return dto.StudentAbsenceResponseDTO{}, err
+	}
+
+	startDate, err := time.Parse("2006-01-02", req.StartDate)
+	if err != nil {
+		return dto.StudentAbsenceResponseDTO{}, errors.New("start_date must be in YYYY-MM-DD format", 400)
+	}
+	endDate, err := time.Parse("2006-01-02", req.EndDate)
+	if err != nil {
+		return dto.StudentAbsenceResponseDTO{}, errors.New("end_date must be in YYYY-MM-DD format", 400)
+	}
+	if endDate.Before(startDate) {
+		return dto.StudentAbsenceResponseDTO{}, errors.New("end_date must not be before start_date", 400)
+	}
+	if req.StartDate < time.Now().Format("2006-01-02") {
+		return dto.StudentAbsenceResponseDTO{}, errors.New("absences cannot start in the past", 400)
+	}
+
+	overlaps, err := service.ChildernRepository.HasOverlappingAbsence(studentUUID, req.StartDate, req.EndDate)
+	if err != nil {
+		return dto.StudentAbsenceResponseDTO{}, err
+	}
+	if overlaps {
+		return dto.StudentAbsenceResponseDTO{}, errors.New("absence overlaps an existing absence", 409)
+	}
+
+	absence := entity.StudentAbsence{
+		AbsenceID:   time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
+		AbsenceUUID: uuid.New(),
+		StudentUUID: uuid.MustParse(studentUUID),
+		ParentUUID:  uuid.MustParse(parentUUID),
+		StartDate:   req.StartDate,
+		EndDate:     req.EndDate,
+		Reason:      sql.NullString{String: req.Reason, Valid: req.Reason != ""},
+		CreatedAt:   sql.NullTime{Time: time.Now(), Valid: true},
+		CreatedBy:   sql.NullString{String: username, Valid: username != ""},
+	}
+	tx, err := service.ChildernRepository.BeginTransaction()
+	if err != nil {
+		return dto.StudentAbsenceResponseDTO{}, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := service.ChildernRepository.SaveAbsence(tx, absence); err != nil {
+		return dto.StudentAbsenceResponseDTO{}, fmt.Errorf("failed to save absence: %w", err)
+	}
+
+	// Trips already generated for these days should not wait for the child
+	if err := service.ChildernRepository.RemoveFromScheduledTrips(tx, studentUUID, req.StartDate, req.EndDate); err != nil {
+		return dto.StudentAbsenceResponseDTO{}, fmt.Errorf("failed to remove student from scheduled trips: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return dto.StudentAbsenceResponseDTO{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return dto.StudentAbsenceResponseDTO{
+		AbsenceUUID: absence.AbsenceUUID.String(),
+		StudentUUID: studentUUID,
+		StartDate:   req.StartDate,
+		EndDate:     req.EndDate,
+		Reason:      req.Reason,
+		CreatedAt:   absence.CreatedAt.Time.Format("2006-01-02 15:04:05"),
+	}, nil
+}
+
+// Current and upcoming absences
+func (service *ChildernService) GetAbsences(studentUUID, parentUUID string) ([]dto.StudentAbsenceResponseDTO, error) {
+	if err := service.checkParent(studentUUID, parentUUID); err != nil {
+		return nil, err
+	}
+
+	return service.ChildernRepository.FetchAbsences(studentUUID, time.Now().Format("2006-01-02"))
+}
+
+// Upcoming absences are cancelled outright; one already under way is cut
+// short to end yesterday so the days the child actually missed stay on record
+func (service *ChildernService) CancelAbsence(absenceUUID, parentUUID, username string) error {
+	if _, err := uuid.Parse(absenceUUID); err != nil {
+		return errors.New("invalid absence ID format", 400)
+	}
+
+	absence, err := service.ChildernRepository.FetchAbsence(absenceUUID)
+	if err == sql.ErrNoRows || (err == nil && absence.ParentUUID.String() != parentUUID) {
+		return errors.New("absence not found", 404)
+	}
+	if err != nil {
+		return err
+	}
+
+	today := time.Now().Format("2006-01-02")
+	if absence.CancelledAt.Valid {
+		return errors.New("absence is already cancelled", 409)
+	}
+	if absence.EndDate < today {
+		return errors.New("absence has already ended", 409)
+	}
+
+	tx, err := service.ChildernRepository.BeginTransaction()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	freedFrom := absence.StartDate
+	if absence.StartDate < today {
+		freedFrom = today
+		err = service.ChildernRepository.ShortenAbsence(tx, absenceUUID, time.Now().AddDate(0, 0, -1).Format("2006-01-02"), username)
+	} else {
+		err = service.ChildernRepository.CancelAbsence(tx, absenceUUID, username)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update absence: %w", err)
+	}
+
+	// Put the child back on trips that were generated while the absence applied
+	manifests, err := service.ChildernRepository.FetchScheduledTripsToRejoin(tx, absence.StudentUUID.String(), freedFrom, absence.EndDate)
+	if err != nil {
+		return fmt.Errorf("failed to fetch scheduled trips: %w", err)
+	}
+	for _, manifest := range manifests {
+		manifest.ManifestID = time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6)
+		manifest.CreatedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		if err := service.ChildernRepository.SaveTripManifest(tx, manifest); err != nil {
+			return fmt.Errorf("failed to restore trip manifest: %w", err)
+		}
+		if err := service.ChildernRepository.RenumberTripManifest(tx, manifest.TripUUID.String()); err != nil {
+			return fmt.Errorf("failed to renumber trip manifest: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package services
+
+import (
+	"database/sql"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"shuttle/logger"
+	"shuttle/models/dto"
+	"shuttle/models/entity"
+	"shuttle/models/geo"
+	"shuttle/repositories"
+	"shuttle/utils"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ETAStopPickup  = "pickup"
+	ETAStopSchool  = "school"
+	ETAStopDropoff = "dropoff"
+
+	// Straight-line distance underestimates the road, and every stop costs some time
+	etaRoadFactor   = 1.3
+	etaStopDwell    = 60 * time.Second
+	etaSpeedWindow  = 10 * time.Minute
+	etaDefaultSpeed = 7.0 // m/s, about 25 km/h in town traffic
+	etaMinSpeed     = 2.0 // m/s, below this the bus is standing still
+	etaMaxPosAge    = 30 * time.Minute
+	etaPushInterval = 30 * time.Second
+)
+
+type ETAServiceInterface interface {
+	GetStudentETAs(driverUUID uuid.UUID) (map[string]dto.StudentETAResponse, error)
+	ObservePosition(shuttleUUID, driverUUID uuid.UUID, latitude, longitude float64, recordedAt time.Time)
+}
+
+type ETAService struct {
+	routeRepository    repositories.RouteRepositoryInterface
+	locationRepository repositories.LocationRepositoryInterface
+	hub                *utils.Hub
+
+	mu       sync.Mutex
+	lastPush map[uuid.UUID]time.Time
+}
+
+func NewETAService(routeRepository repositories.RouteRepositoryInterface, locationRepository repositories.LocationRepositoryInterface, hub *utils.Hub) ETAServiceInterface {
+	return &ETAService{
+		routeRepository:    routeRepository,
+		locationRepository: locationRepository,
+		hub:                hub,
+		lastPush:           make(map[uuid.UUID]time.Time),
+	}
+}
+
+type etaStop struct {
+	stopType  string
+	latitude  float64
+	longitude float64
+	students  []dto.RouteStopDTO
+}
+
+// ETA per student on the driver's active trip, keyed by student UUID
+func (s *ETAService) GetStudentETAs(driverUUID uuid.UUID) (map[string]dto.StudentETAResponse, error) {
+	recent, err := s.locationRepository.FetchRecentLocationsByUser(driverUUID, time.Now().Add(-etaMaxPosAge))
+	if err != nil {
+		return nil, err
+	}
+	if len(recent) == 0 {
+		return map[string]dto.StudentETAResponse{}, nil
+	}
+
+	latest := recent[len(recent)-1]
+	return s.estimate(driverUUID, latest.Latitude, latest.Longitude, latest.RecordedAt, s.averageSpeed(recent))
+}
+
+// Push fresh ETAs to parents while the driver streams positions, at most once per interval
+func (s *ETAService) ObservePosition(shuttleUUID, driverUUID uuid.UUID, latitude, longitude float64, recordedAt time.Time) {
+	s.mu.Lock()
+	if time.Since(s.lastPush[driverUUID]) < etaPushInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastPush[driverUUID] = time.Now()
+	s.mu.Unlock()
+
+	recent, err := s.locationRepository.FetchRecentLocationsByUser(driverUUID, time.Now().Add(-etaSpeedWindow))
+	if err != nil {
+		logger.LogError(err, "ETA failed to load recent positions", map[string]interface{}{
+			"driverUUID": driverUUID.String(),
+		})
+		return
+	}
+
+	etas, err := s.estimate(driverUUID, latitude, longitude, recordedAt, s.averageSpeed(recent))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logger.LogError(err, "ETA failed to estimate stops", map[string]interface{}{
+				"driverUUID": driverUUID.String(),
+			})
+		}
+		return
+	}
+
+	for _, eta := range etas {
+		if eta.ShuttleUUID == "" {
+			continue
+		}
+
+		message, err := utils.NewWebSocketMessage(utils.MessageTypeETA, eta.ShuttleUUID, driverUUID.String(), eta)
+		if err != nil {
+			logger.LogError(err, "ETA failed to encode event", nil)
+			continue
+		}
+		s.hub.BroadcastToGroupAndUser(eta.ShuttleUUID, eta.ParentUUID, message)
+	}
+}
+
+func (s *ETAService) estimate(driverUUID uuid.UUID, latitude, longitude float64, recordedAt time.Time, speed float64) (map[string]dto.StudentETAResponse, error) {
+	stops, err := s.routeRepository.FetchRouteStopsByDriver(driverUUID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	etas := make(map[string]dto.StudentETAResponse)
+	positionAge := int64(time.Since(recordedAt).Seconds())
+
+	distance := 0.0
+	elapsed := time.Duration(0)
+	prevLat, prevLng := latitude, longitude
+
+	for i, stop := range remainingStops(stops) {
+		leg := utils.HaversineDistance(prevLat, prevLng, stop.latitude, stop.longitude) * etaRoadFactor
+		distance += leg
+		elapsed += time.Duration(leg / speed * float64(time.Second))
+		if i > 0 {
+			elapsed += etaStopDwell
+		}
+
+		estimatedArrival := time.Now().Add(elapsed).Format(time.RFC3339)
+		for _, student := range stop.students {
+			// A student picked up on the way keeps the pickup ETA and gains the school one
+			if eta, exists := etas[student.StudentUUID]; exists && stop.stopType == ETAStopSchool {
+				eta.SchoolETASeconds = int64(elapsed.Seconds())
+				eta.SchoolEstimatedArrival = estimatedArrival
+				etas[student.StudentUUID] = eta
+				continue
+			}
+
+			etas[student.StudentUUID] = dto.StudentETAResponse{
+				StudentUUID:        student.StudentUUID,
+				ShuttleUUID:        student.ShuttleUUID,
+				ParentUUID:         student.ParentUUID,
+				StopType:           stop.stopType,
+				StopsBefore:        i,
+				DistanceMeters:     math.Round(distance),
+				ETASeconds:         int64(elapsed.Seconds()),
+				EstimatedArrival:   estimatedArrival,
+				AverageSpeed:       math.Round(speed*100) / 100,
+				PositionAgeSeconds: positionAge,
+			}
+		}
+
+		prevLat, prevLng = stop.latitude, stop.longitude
+	}
+
+	return etas, nil
+}
+
+// Work out which leg the bus is on from today's shuttle statuses. In the
+// morning it still has to collect waiting students and then reach school;
+// in the afternoon it drops off whoever is still on board. Stops arrive in
+// route order, which the way home runs in reverse.
+func remainingStops(stops []dto.RouteStopDTO) []etaStop {
+	var pickups, dropoffs, onBoard []dto.RouteStopDTO
+	var schoolPoint geo.NullGeoPoint
+
+	for _, stop := range stops {
+		schoolPoint = stop.SchoolPoint
+		switch stop.ShuttleStatus {
+		case "waiting_to_be_taken_to_school":
+			pickups = append(pickups, stop)
+		case "going_to_school":
+			onBoard = append(onBoard, stop)
+		case "going_to_home":
+			dropoffs = append(dropoffs, stop)
+		}
+	}
+
+	var result []etaStop
+
+	if len(dropoffs) > 0 {
+		sort.SliceStable(dropoffs, func(i, j int) bool {
+			return dropoffs[i].StudentOrder > dropoffs[j].StudentOrder
+		})
+		for _, stop := range dropoffs {
+			if lat, lng, ok := stop.StudentPickupPoint.Coordinates(); ok {
+				result = append(result, etaStop{stopType: ETAStopDropoff, latitude: lat, longitude: lng, students: []dto.RouteStopDTO{stop}})
+			}
+		}
+		return result
+	}
+
+	if len(pickups) == 0 && len(onBoard) == 0 {
+		return nil
+	}
+
+	for _, stop := range pickups {
+		if lat, lng, ok := stop.StudentPickupPoint.Coordinates(); ok {
+			result = append(result, etaStop{stopType: ETAStopPickup, latitude: lat, longitude: lng, students: []dto.RouteStopDTO{stop}})
+		}
+	}
+
+	// Everyone riding now or picked up on the way arrives together at school
+	if lat, lng, ok := schoolPoint.Coordinates(); ok {
+		riders := append(append([]dto.RouteStopDTO{}, pickups...), onBoard...)
+		result = append(result, etaStop{stopType: ETAStopSchool, latitude: lat, longitude: lng, students: riders})
+	}
+
+	return result
+}
+
+// Rolling average over the recent window: prefer the speeds reported by the
+// app, else derive it from distance travelled between fixes
+func (s *ETAService) averageSpeed(recent []entity.ShuttleLocation) float64 {
+	cutoff := time.Now().Add(-etaSpeedWindow)
+
+	reported, reportedCount := 0.0, 0
+	travelled, duration := 0.0, 0.0
+	var prev *entity.ShuttleLocation
+
+	for i := range recent {
+		location := &recent[i]
+		if location.RecordedAt.Before(cutoff) {
+			continue
+		}
+
+		if location.Speed.Valid && location.Speed.Float64 > 0 {
+			reported += location.Speed.Float64
+			reportedCount++
+		}
+		if prev != nil {
+			travelled += utils.HaversineDistance(prev.Latitude, prev.Longitude, location.Latitude, location.Longitude)
+			duration += location.RecordedAt.Sub(prev.RecordedAt).Seconds()
+		}
+		prev = location
+	}
+
+	speed := etaDefaultSpeed
+	switch {
+	case reportedCount > 0:
+		speed = reported / float64(reportedCount)
+	case duration > 0:
+		speed = travelled / duration
+	}
+
+	if speed < etaMinSpeed {
+		return etaDefaultSpeed
+	}
+	return speed
+}
@@ -0,0 +1,269 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"shuttle/errors"
+	"shuttle/logger"
+	"shuttle/models/dto"
+	"shuttle/repositories"
+	"shuttle/utils"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+)
+
+const (
+	GeofenceEventApproaching = "approaching_pickup"
+	GeofenceEventArrived     = "arrived_at_school"
+
+	geofenceContextTTL    = 20 * time.Second
+	geofenceStateIdleTTL  = time.Hour
+	geofenceSweepInterval = 5 * time.Minute
+)
+
+type GeofenceServiceInterface interface {
+	ObservePosition(shuttleUUID, driverUUID uuid.UUID, latitude, longitude float64, recordedAt time.Time)
+	RunSweep()
+}
+
+type cachedGeofenceContext struct {
+	context   dto.ShuttleGeofenceContext
+	fetchedAt time.Time
+}
+
+// Alerts already fired for a shuttle while it stays in one status
+type geofenceState struct {
+	status       string
+	approachSent bool
+	arrivalSent  bool
+	lastSeenAt   time.Time
+}
+
+type GeofenceService struct {
+	shuttleRepository repositories.ShuttleRepositoryInterface
+	shuttleService    ShuttleServiceInterface
+	hub               *utils.Hub
+	autoStatus        bool
+
+	mu       sync.Mutex
+	contexts map[uuid.UUID]cachedGeofenceContext
+	states   map[uuid.UUID]*geofenceState
+}
+
+func NewGeofenceService(shuttleRepository repositories.ShuttleRepositoryInterface, shuttleService ShuttleServiceInterface, hub *utils.Hub) GeofenceServiceInterface {
+	return &GeofenceService{
+		shuttleRepository: shuttleRepository,
+		shuttleService:    shuttleService,
+		hub:               hub,
+		autoStatus:        viper.GetBool("GEOFENCE_AUTO_STATUS"),
+		contexts:          make(map[uuid.UUID]cachedGeofenceContext),
+		states:            make(map[uuid.UUID]*geofenceState),
+	}
+}
+
+func (s *GeofenceService) ObservePosition(shuttleUUID, driverUUID uuid.UUID, latitude, longitude float64, recordedAt time.Time) {
+	context, err := s.geofenceContext(shuttleUUID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logger.LogError(err, "Geofence failed to load shuttle context", map[string]interface{}{
+				"shuttleUUID": shuttleUUID.String(),
+			})
+		}
+		return
+	}
+
+	switch context.ShuttleStatus {
+	case "home":
+		// The day's ride is over, nothing left to watch for this shuttle
+		s.forget(shuttleUUID)
+
+	case "waiting_to_be_taken_to_school":
+		pickupLat, pickupLng, ok := context.StudentPickupPoint.Coordinates()
+		if !ok {
+			return
+		}
+
+		distance := utils.HaversineDistance(latitude, longitude, pickupLat, pickupLng)
+		if distance <= float64(context.ApproachRadius) && s.markFired(shuttleUUID, context.ShuttleStatus, GeofenceEventApproaching) {
+			s.notifyApproaching(context, distance)
+		}
+
+	case "going_to_school":
+		schoolLat, schoolLng, ok := context.SchoolPoint.Coordinates()
+		if !ok {
+			return
+		}
+
+		distance := utils.HaversineDistance(latitude, longitude, schoolLat, schoolLng)
+		if distance <= float64(context.ArrivalRadius) && s.markFired(shuttleUUID, context.ShuttleStatus, GeofenceEventArrived) {
+			s.handleArrival(context, driverUUID, latitude, longitude, distance)
+		}
+	}
+}
+
+func (s *GeofenceService) geofenceContext(shuttleUUID uuid.UUID) (dto.ShuttleGeofenceContext, error) {
+	s.mu.Lock()
+	cached, exists := s.contexts[shuttleUUID]
+	s.mu.Unlock()
+
+	if exists && time.Since(cached.fetchedAt) < geofenceContextTTL {
+		return cached.context, nil
+	}
+
+	context, err := s.shuttleRepository.FetchShuttleGeofenceContext(shuttleUUID)
+	if err != nil {
+		return dto.ShuttleGeofenceContext{}, err
+	}
+
+	s.mu.Lock()
+	s.contexts[shuttleUUID] = cachedGeofenceContext{context: context, fetchedAt: time.Now()}
+	s.mu.Unlock()
+
+	return context, nil
+}
+
+// Record that an event fired for the shuttle's current status. Returns false
+// when it already fired, so each alert goes out once per leg of the trip.
+func (s *GeofenceService) markFired(shuttleUUID uuid.UUID, status, event string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, exists := s.states[shuttleUUID]
+	if !exists || state.status != status {
+		state = &geofenceState{status: status}
+		s.states[shuttleUUID] = state
+	}
+	state.lastSeenAt = time.Now()
+
+	switch event {
+	case GeofenceEventApproaching:
+		if state.approachSent {
+			return false
+		}
+		state.approachSent = true
+	case GeofenceEventArrived:
+		if state.arrivalSent {
+			return false
+		}
+		state.arrivalSent = true
+	}
+
+	return true
+}
+
+func (s *GeofenceService) notifyApproaching(context dto.ShuttleGeofenceContext, distance float64) {
+	message, err := utils.NewWebSocketMessage(utils.MessageTypeGeofence, context.ShuttleUUID, "", utils.GeofencePayload{
+		Event:          GeofenceEventApproaching,
+		StudentUUID:    context.StudentUUID,
+		DistanceMeters: distance,
+	})
+	if err != nil {
+		logger.LogError(err, "Geofence failed to encode approaching event", nil)
+	} else {
+		s.hub.BroadcastToGroupAndUser(context.ShuttleUUID, context.ParentUUID, message)
+	}
+
+	body := fmt.Sprintf("The shuttle is about %d meters from %s's pickup point", int(distance), context.StudentFirstName)
+	if err := utils.SendNotificationMessage(context.ParentUUID, "Shuttle Approaching", body); err != nil {
+		logger.LogWarn("Failed to send approaching notification to parent", map[string]interface{}{
+			"error":       err.Error(),
+			"shuttleUUID": context.ShuttleUUID,
+			"parentUUID":  context.ParentUUID,
+		})
+	}
+}
+
+// Apply at_school when GEOFENCE_AUTO_STATUS is on, otherwise suggest it to the driver
+func (s *GeofenceService) handleArrival(context dto.ShuttleGeofenceContext, driverUUID uuid.UUID, latitude, longitude, distance float64) {
+	payload := utils.GeofencePayload{
+		Event:           GeofenceEventArrived,
+		StudentUUID:     context.StudentUUID,
+		DistanceMeters:  distance,
+		SuggestedStatus: "at_school",
+	}
+
+	if s.autoStatus {
+		change, err := s.shuttleService.EditShuttleStatus(context.ShuttleUUID, dto.ShuttleStatusUpdateRequest{
+			Status:    "at_school",
+			Latitude:  &latitude,
+			Longitude: &longitude,
+		}, driverUUID.String())
+		if err != nil {
+			if customErr, ok := err.(*errors.CustomError); ok {
+				logger.LogWarn("Geofence skipped automatic status change", map[string]interface{}{
+					"shuttleUUID": context.ShuttleUUID,
+					"reason":      customErr.Message,
+				})
+			} else {
+				logger.LogError(err, "Geofence failed to apply automatic status change", map[string]interface{}{
+					"shuttleUUID": context.ShuttleUUID,
+				})
+			}
+		} else {
+			payload.Applied = true
+			s.forgetContext(uuid.MustParse(context.ShuttleUUID))
+
+			if err := utils.PublishStatusChange(s.hub, change, ""); err != nil {
+				logger.LogError(err, "Failed to publish shuttle status event", map[string]interface{}{
+					"shuttleUUID": context.ShuttleUUID,
+				})
+			}
+			if err := utils.SendNotification(change.ParentUUID, "Shuttle Status Update", change.NewStatus); err != nil {
+				logger.LogWarn("Failed to send notification to parent", map[string]interface{}{
+					"error":         err.Error(),
+					"shuttleUUID":   context.ShuttleUUID,
+					"parentUUID":    change.ParentUUID,
+					"shuttleStatus": change.NewStatus,
+				})
+			}
+		}
+	}
+
+	message, err := utils.NewWebSocketMessage(utils.MessageTypeGeofence, context.ShuttleUUID, "", payload)
+	if err != nil {
+		logger.LogError(err, "Geofence failed to encode arrival event", nil)
+		return
+	}
+	s.hub.SendToUser(driverUUID.String(), message)
+}
+
+func (s *GeofenceService) forgetContext(shuttleUUID uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.contexts, shuttleUUID)
+}
+
+func (s *GeofenceService) forget(shuttleUUID uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.contexts, shuttleUUID)
+	delete(s.states, shuttleUUID)
+}
+
+// Shuttles are created per student and day, so entries of shuttles that
+// stopped reporting are dropped instead of kept for the life of the process
+func (s *GeofenceService) RunSweep() {
+	ticker := time.NewTicker(geofenceSweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+
+		s.mu.Lock()
+		for shuttleUUID, cached := range s.contexts {
+			if now.Sub(cached.fetchedAt) >= geofenceContextTTL {
+				delete(s.contexts, shuttleUUID)
+			}
+		}
+		for shuttleUUID, state := range s.states {
+			if now.Sub(state.lastSeenAt) >= geofenceStateIdleTTL {
+				delete(s.states, shuttleUUID)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
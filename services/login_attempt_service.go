@@ -0,0 +1,129 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"shuttle/models/dto"
+	"shuttle/models/entity"
+	"shuttle/repositories"
+	"shuttle/utils"
+
+	"github.com/google/uuid"
+)
+
+const (
+	LoginFailureInvalidCredentials = "invalid_credentials"
+	LoginFailureLocked             = "locked"
+
+	defaultLoginMaxAttempts      = 5
+	defaultLoginMaxAttemptsPerIP = 20
+	defaultLoginLockoutDuration  = time.Minute
+	defaultLoginLockoutMax       = time.Hour
+	defaultLoginAttemptWindow    = 24 * time.Hour
+)
+
+type LoginAttemptServiceInterface interface {
+	CheckLockout(email, ipAddress string) (time.Duration, error)
+	RecordAttempt(email, ipAddress, userAgent, userUUID string, succeeded bool, failureReason string) error
+	GetLoginAttempts(filter dto.LoginAttemptFilter, page, limit int) ([]dto.LoginAttemptResponseDTO, int, error)
+}
+
+// Failed logins are counted per account and per address. Once either passes
+// its limit the next attempt has to wait, and every further failure doubles
+// the wait up to the maximum lockout.
+type LoginAttemptService struct {
+	loginAttemptRepository repositories.LoginAttemptRepositoryInterface
+	maxAttempts            int
+	maxAttemptsPerIP       int
+	lockoutDuration        time.Duration
+	lockoutMax             time.Duration
+	window                 time.Duration
+}
+
+func NewLoginAttemptService(loginAttemptRepository repositories.LoginAttemptRepositoryInterface) LoginAttemptServiceInterface {
+	return &LoginAttemptService{
+		loginAttemptRepository: loginAttemptRepository,
+		maxAttempts:            utils.IntFromConfig("LOGIN_MAX_ATTEMPTS", defaultLoginMaxAttempts),
+		maxAttemptsPerIP:       utils.IntFromConfig("LOGIN_MAX_ATTEMPTS_PER_IP", defaultLoginMaxAttemptsPerIP),
+		lockoutDuration:        utils.DurationFromConfig("LOGIN_LOCKOUT_DURATION", defaultLoginLockoutDuration),
+		lockoutMax:             utils.DurationFromConfig("LOGIN_LOCKOUT_MAX", defaultLoginLockoutMax),
+		window:                 utils.DurationFromConfig("LOGIN_ATTEMPT_WINDOW", defaultLoginAttemptWindow),
+	}
+}
+
+// How long the caller has to wait before trying again, zero when it may try now
+func (s *LoginAttemptService) CheckLockout(email, ipAddress string) (time.Duration, error) {
+	since := time.Now().Add(-s.window)
+
+	account, err := s.loginAttemptRepository.FetchAccountFailures(email, since)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch account login failures: %w", err)
+	}
+
+	address, err := s.loginAttemptRepository.FetchIPFailures(ipAddress, since)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch address login failures: %w", err)
+	}
+
+	wait := s.remainingLockout(account, s.maxAttempts)
+	if addressWait := s.remainingLockout(address, s.maxAttemptsPerIP); addressWait > wait {
+		wait = addressWait
+	}
+
+	return wait, nil
+}
+
+func (s *LoginAttemptService) remainingLockout(stats dto.LoginFailureStatsDTO, limit int) time.Duration {
+	if stats.Failures < limit || !stats.LastFailedAt.Valid {
+		return 0
+	}
+
+	lockout := s.lockoutDuration
+	for i := limit; i < stats.Failures && lockout < s.lockoutMax; i++ {
+		lockout *= 2
+	}
+	if lockout > s.lockoutMax {
+		lockout = s.lockoutMax
+	}
+
+	remaining := time.Until(stats.LastFailedAt.Time.Add(lockout))
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+func (s *LoginAttemptService) RecordAttempt(email, ipAddress, userAgent, userUUID string, succeeded bool, failureReason string) error {
+	attempt := entity.LoginAttempt{
+		AttemptID:     time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
+		Email:         email,
+		IPAddress:     ipAddress,
+		UserAgent:     sql.NullString{String: userAgent, Valid: userAgent != ""},
+		Succeeded:     succeeded,
+		FailureReason: sql.NullString{String: failureReason, Valid: failureReason != ""},
+		AttemptedAt:   time.Now(),
+	}
+	if parsedUserUUID, err := uuid.Parse(userUUID); err == nil {
+		attempt.UserUUID = uuid.NullUUID{UUID: parsedUserUUID, Valid: true}
+	}
+
+	return s.loginAttemptRepository.SaveLoginAttempt(attempt)
+}
+
+func (s *LoginAttemptService) GetLoginAttempts(filter dto.LoginAttemptFilter, page, limit int) ([]dto.LoginAttemptResponseDTO, int, error) {
+	offset := (page - 1) * limit
+
+	attempts, err := s.loginAttemptRepository.FetchLoginAttempts(offset, limit, filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch login attempts: %w", err)
+	}
+
+	total, err := s.loginAttemptRepository.CountLoginAttempts(filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count login attempts: %w", err)
+	}
+
+	return attempts, total, nil
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"time"
+
+	"shuttle/errors"
+	"shuttle/logger"
+	"shuttle/models/entity"
+	"shuttle/repositories"
+	"shuttle/utils"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+)
+
+const (
+	SessionRevokedPasswordReset = "password_reset"
+
+	defaultPasswordResetTTL      = 30 * time.Minute
+	defaultPasswordResetCooldown = time.Minute
+)
+
+type PasswordResetServiceInterface interface {
+	RequestPasswordReset(email, ipAddress string)
+	ResetPassword(token, newPassword string) (string, error)
+}
+
+// Reset tokens are random, mailed to the user and only stored as a SHA-256
+// hash. Each can be redeemed once before it expires; asking for a new one
+// cancels the previous.
+type PasswordResetService struct {
+	passwordResetRepository repositories.PasswordResetRepositoryInterface
+	userRepository          repositories.UserRepositoryInterface
+	authService             AuthServiceInterface
+	mailer                  utils.Mailer
+	ttl                     time.Duration
+	cooldown                time.Duration
+}
+
+func NewPasswordResetService(passwordResetRepository repositories.PasswordResetRepositoryInterface, userRepository repositories.UserRepositoryInterface, authService AuthServiceInterface, mailer utils.Mailer) PasswordResetServiceInterface {
+	return &PasswordResetService{
+		passwordResetRepository: passwordResetRepository,
+		userRepository:          userRepository,
+		authService:             authService,
+		mailer:                  mailer,
+		ttl:                     utils.DurationFromConfig("PASSWORD_RESET_TTL", defaultPasswordResetTTL),
+		cooldown:                utils.DurationFromConfig("PASSWORD_RESET_COOLDOWN", defaultPasswordResetCooldown),
+	}
+}
+
+// Mail a reset link in the background. The caller returns at once whether or
+// not the email has an account, so neither the answer nor its timing reveals it.
+func (s *PasswordResetService) RequestPasswordReset(email, ipAddress string) {
+	go func() {
+		if err := s.sendPasswordReset(email, ipAddress); err != nil {
+			logger.LogError(err, "Failed to request password reset", map[string]interface{}{
+				"email": email,
+			})
+		}
+	}()
+}
+
+// Unknown addresses and requests within the cooldown are ignored without an error
+func (s *PasswordResetService) sendPasswordReset(email, ipAddress string) error {
+	userUUID, err := s.userRepository.FetchUUIDByEmail(email)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch user: %w", err)
+	}
+
+	now := time.Now()
+	recent, err := s.passwordResetRepository.HasRecentPasswordResetToken(userUUID, now.Add(-s.cooldown))
+	if err != nil {
+		return fmt.Errorf("failed to check recent reset tokens: %w", err)
+	}
+	if recent {
+		logger.LogWarn("Password reset requested again too soon, ignored", map[string]interface{}{
+			"user_uuid": userUUID.String(),
+		})
+		return nil
+	}
+
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return fmt.Errorf("failed to generate reset token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+
+	resetToken := entity.PasswordResetToken{
+		TokenID:     time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
+		UserUUID:    userUUID,
+		TokenHash:   hashResetToken(token),
+		RequestedIP: sql.NullString{String: ipAddress, Valid: ipAddress != ""},
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.ttl),
+	}
+	if err := s.passwordResetRepository.SavePasswordResetToken(resetToken); err != nil {
+		return fmt.Errorf("failed to save reset token: %w", err)
+	}
+
+	if err := s.mailer.Send(utils.MailMessage{
+		To:      email,
+		Subject: "Reset your Shuttle password",
+		Body:    passwordResetMailBody(token, s.ttl),
+	}); err != nil {
+		return fmt.Errorf("failed to send reset mail: %w", err)
+	}
+
+	return nil
+}
+
+// Set the new password and sign the user out everywhere, returns the user's UUID
+func (s *PasswordResetService) ResetPassword(token, newPassword string) (string, error) {
+	tx, err := s.passwordResetRepository.BeginTransaction()
+	if err != nil {
+		return "", fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	resetToken, err := s.passwordResetRepository.FetchPasswordResetTokenForUpdate(tx, hashResetToken(token))
+	if err == sql.ErrNoRows || (err == nil && (resetToken.UsedAt != nil || !resetToken.ExpiresAt.After(now))) {
+		return "", errors.New("reset token is invalid or has expired", 400)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	hashedPassword, err := utils.HashPassword(newPassword)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.passwordResetRepository.UpdateUserPassword(tx, resetToken.UserUUID, hashedPassword); err != nil {
+		return "", fmt.Errorf("failed to update password: %w", err)
+	}
+
+	if err := s.passwordResetRepository.ConsumePasswordResetTokens(tx, resetToken.UserUUID, now); err != nil {
+		return "", fmt.Errorf("failed to consume reset tokens: %w", err)
+	}
+
+	// The new password only takes effect together with the sign-out everywhere
+	sessionUUIDs, err := s.authService.RevokeAllSessions(tx, resetToken.UserUUID, SessionRevokedPasswordReset, now)
+	if err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	s.authService.MarkSessionsRevoked(sessionUUIDs, now)
+
+	return resetToken.UserUUID.String(), nil
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// PASSWORD_RESET_URL is the page or app link that takes the token, without it
+// the token itself is mailed
+func passwordResetMailBody(token string, ttl time.Duration) string {
+	action := "Use this code to reset your password:\n\n" + token
+	if resetURL := viper.GetString("PASSWORD_RESET_URL"); resetURL != "" {
+		if parsed, err := url.Parse(resetURL); err == nil {
+			query := parsed.Query()
+			query.Set("token", token)
+			parsed.RawQuery = query.Encode()
+			action = "Open this link to reset your password:\n\n" + parsed.String()
+		}
+	}
+
+	return fmt.Sprintf("Hello,\n\nWe received a request to reset the password of your Shuttle account. %s\n\nIt expires in %d minutes and can only be used once. If you didn't ask for this, you can ignore this email.\n", action, int(ttl.Minutes()))
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"shuttle/models/dto"
+	"shuttle/utils"
+)
+
+// Render a route as GeoJSON: every pickup point and the school as points, the
+// planned path of each driver as a line, and the trail of every trip run on
+// the date as a line of its own
+func (service *routeService) GetRouteGeoJSON(routeNameUUID, schoolUUID string, date time.Time) (utils.GeoJSONFeatureCollection, error) {
+	stops, err := service.routeRepository.FetchRouteGeoStops(routeNameUUID, schoolUUID)
+	if err != nil {
+		return utils.GeoJSONFeatureCollection{}, fmt.Errorf("failed to fetch route stops: %w", err)
+	}
+	if len(stops) == 0 {
+		return utils.GeoJSONFeatureCollection{}, fmt.Errorf("route not found")
+	}
+
+	trail, err := service.routeRepository.FetchRouteTrail(routeNameUUID, date.Format("2006-01-02"))
+	if err != nil {
+		return utils.GeoJSONFeatureCollection{}, fmt.Errorf("failed to fetch route trail: %w", err)
+	}
+
+	route := stops[0]
+	collection := utils.NewGeoJSONFeatureCollection()
+
+	var drivers []string
+	paths := make(map[string][][2]float64)
+	for _, stop := range stops {
+		if stop.StudentUUID == "" {
+			continue
+		}
+
+		properties := map[string]interface{}{
+			"kind":               "stop",
+			"stop_type":          ItineraryStopPickup,
+			"route_uuid":         route.RouteNameUUID,
+			"route_name":         route.RouteName,
+			"driver_uuid":        stop.DriverUUID,
+			"order":              stop.StudentOrder,
+			"student_uuid":       stop.StudentUUID,
+			"student_first_name": stop.StudentFirstName,
+			"student_last_name":  stop.StudentLastName,
+			"address":            stop.StudentAddress,
+		}
+
+		lat, lng, ok := stop.StudentPickupPoint.Coordinates()
+		if !ok {
+			// Kept without a geometry so the student still shows up in the export
+			collection.Features = append(collection.Features, utils.GeoJSONFeature{Type: "Feature", Properties: properties})
+			continue
+		}
+		collection.Features = append(collection.Features, utils.NewGeoJSONPoint(lat, lng, properties))
+
+		if _, exists := paths[stop.DriverUUID]; !exists {
+			drivers = append(drivers, stop.DriverUUID)
+		}
+		paths[stop.DriverUUID] = append(paths[stop.DriverUUID], [2]float64{lat, lng})
+	}
+
+	schoolLat, schoolLng, schoolLocated := route.SchoolPoint.Coordinates()
+	if schoolLocated {
+		collection.Features = append(collection.Features, utils.NewGeoJSONPoint(schoolLat, schoolLng, map[string]interface{}{
+			"kind":       "stop",
+			"stop_type":  ItineraryStopSchool,
+			"route_uuid": route.RouteNameUUID,
+			"route_name": route.RouteName,
+			"name":       route.SchoolName,
+		}))
+	}
+
+	for _, driverUUID := range drivers {
+		path := paths[driverUUID]
+		if schoolLocated {
+			path = append(path, [2]float64{schoolLat, schoolLng})
+		}
+		if len(path) < 2 {
+			continue
+		}
+		collection.Features = append(collection.Features, utils.NewGeoJSONLineString(path, map[string]interface{}{
+			"kind":        "planned_route",
+			"route_uuid":  route.RouteNameUUID,
+			"route_name":  route.RouteName,
+			"driver_uuid": driverUUID,
+		}))
+	}
+
+	var trips []string
+	trails := make(map[string][]dto.RouteTrailPointDTO)
+	for _, point := range trail {
+		if _, exists := trails[point.TripUUID]; !exists {
+			trips = append(trips, point.TripUUID)
+		}
+		trails[point.TripUUID] = append(trails[point.TripUUID], point)
+	}
+
+	for _, tripUUID := range trips {
+		points := trails[tripUUID]
+		if len(points) < 2 {
+			continue
+		}
+
+		line := make([][2]float64, 0, len(points))
+		for _, point := range points {
+			line = append(line, [2]float64{point.Latitude, point.Longitude})
+		}
+		collection.Features = append(collection.Features, utils.NewGeoJSONLineString(line, map[string]interface{}{
+			"kind":        "trail",
+			"route_uuid":  route.RouteNameUUID,
+			"trip_uuid":   tripUUID,
+			"driver_uuid": points[0].DriverUUID,
+			"direction":   points[0].Direction.String,
+			"started_at":  points[0].RecordedAt.Format(time.RFC3339),
+			"ended_at":    points[len(points)-1].RecordedAt.Format(time.RFC3339),
+			"point_count": len(points),
+		}))
+	}
+
+	return collection, nil
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"fmt"
+	"math"
+
+	"shuttle/models/dto"
+	"shuttle/utils"
+)
+
+const (
+	ItineraryStopPickup  = "pickup"
+	ItineraryStopDropoff = "dropoff"
+	ItineraryStopSchool  = "school"
+)
+
+func (service *routeService) GetDriverItinerary(driverUUID string) ([]dto.DriverItineraryDTO, error) {
+	rows, err := service.routeRepository.FetchAllRoutesByDriver(driverUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildDriverItineraries(rows), nil
+}
+
+// Group the flat route rows into one itinerary per route run. Rows arrive in
+// driving order, so on the way to school the school closes the list and on
+// the way home it opens it.
+func buildDriverItineraries(rows []dto.RouteResponseByDriverDTO) []dto.DriverItineraryDTO {
+	var keys []string
+	grouped := make(map[string][]dto.RouteResponseByDriverDTO)
+	for _, row := range rows {
+		key := row.RouteUUID + "|" + row.ScheduleUUID.String
+		if _, exists := grouped[key]; !exists {
+			keys = append(keys, key)
+		}
+		grouped[key] = append(grouped[key], row)
+	}
+
+	itineraries := make([]dto.DriverItineraryDTO, 0, len(keys))
+	for _, key := range keys {
+		group := grouped[key]
+		first := group[0]
+
+		direction := first.TripDirection.String
+		if direction == "" {
+			direction = "to_school"
+		}
+
+		school := dto.ItineraryStopDTO{StopType: ItineraryStopSchool, Address: first.SchoolName}
+		if lat, lng, ok := first.SchoolPoint.Coordinates(); ok {
+			school.Latitude, school.Longitude = &lat, &lng
+		}
+
+		stopType := ItineraryStopPickup
+		if direction == "to_home" {
+			stopType = ItineraryStopDropoff
+		}
+
+		var stops []dto.ItineraryStopDTO
+		if direction == "to_home" {
+			stops = append(stops, school)
+		}
+		for _, row := range group {
+			stop := dto.ItineraryStopDTO{
+				StopType:         stopType,
+				StudentUUID:      row.StudentUUID,
+				StudentFirstName: row.StudentFirstName,
+				StudentLastName:  row.StudentLastName,
+				Address:          row.StudentAddress,
+				ShuttleUUID:      row.ShuttleUUID.String,
+				ShuttleStatus:    row.ShuttleStatus.String,
+			}
+			if lat, lng, ok := row.StudentPickupPoint.Coordinates(); ok {
+				stop.Latitude, stop.Longitude = &lat, &lng
+			}
+			stops = append(stops, stop)
+		}
+		if direction != "to_home" {
+			stops = append(stops, school)
+		}
+
+		// Stops without coordinates are kept in sequence but skipped when measuring
+		var previous *dto.ItineraryStopDTO
+		total := 0.0
+		for i := range stops {
+			stops[i].Sequence = i + 1
+			if stops[i].Latitude == nil {
+				continue
+			}
+			if previous != nil {
+				leg := utils.HaversineDistance(*previous.Latitude, *previous.Longitude, *stops[i].Latitude, *stops[i].Longitude)
+				stops[i].DistanceFromPreviousMeters = math.Round(leg)
+				total += leg
+			}
+			stops[i].CumulativeDistanceMeters = math.Round(total)
+			previous = &stops[i]
+		}
+
+		itineraries = append(itineraries, dto.DriverItineraryDTO{
+			RouteUUID:           first.RouteUUID,
+			ScheduleUUID:        first.ScheduleUUID.String,
+			Direction:           direction,
+			DepartureTime:       first.DepartureTime.String,
+			SchoolUUID:          first.SchoolUUID,
+			SchoolName:          first.SchoolName,
+			TotalStops:          len(stops),
+			TotalDistanceMeters: math.Round(total),
+			Stops:               stops,
+		})
+	}
+
+	return itineraries
+}
+
+func itineraryName(itinerary dto.DriverItineraryDTO) string {
+	name := fmt.Sprintf("%s (%s)", itinerary.SchoolName, itinerary.Direction)
+	if itinerary.DepartureTime != "" {
+		name += " " + itinerary.DepartureTime
+	}
+	return name
+}
+
+func itineraryStopName(stop dto.ItineraryStopDTO) string {
+	if stop.StopType == ItineraryStopSchool {
+		return stop.Address
+	}
+	return fmt.Sprintf("%d. %s %s", stop.Sequence, stop.StudentFirstName, stop.StudentLastName)
+}
+
+// Every stop as a point plus one line per itinerary through the stops that have coordinates
+func ItineraryGeoJSON(itineraries []dto.DriverItineraryDTO) utils.GeoJSONFeatureCollection {
+	collection := utils.NewGeoJSONFeatureCollection()
+
+	for _, itinerary := range itineraries {
+		var line [][2]float64
+		for _, stop := range itinerary.Stops {
+			if stop.Latitude == nil {
+				continue
+			}
+			line = append(line, [2]float64{*stop.Latitude, *stop.Longitude})
+
+			collection.Features = append(collection.Features, utils.NewGeoJSONPoint(*stop.Latitude, *stop.Longitude, map[string]interface{}{
+				"name":                          itineraryStopName(stop),
+				"route_uuid":                    itinerary.RouteUUID,
+				"schedule_uuid":                 itinerary.ScheduleUUID,
+				"direction":                     itinerary.Direction,
+				"sequence":                      stop.Sequence,
+				"stop_type":                     stop.StopType,
+				"student_uuid":                  stop.StudentUUID,
+				"address":                       stop.Address,
+				"distance_from_previous_meters": stop.DistanceFromPreviousMeters,
+			}))
+		}
+
+		if len(line) > 1 {
+			collection.Features = append(collection.Features, utils.NewGeoJSONLineString(line, map[string]interface{}{
+				"name":                  itineraryName(itinerary),
+				"route_uuid":            itinerary.RouteUUID,
+				"schedule_uuid":         itinerary.ScheduleUUID,
+				"direction":             itinerary.Direction,
+				"departure_time":        itinerary.DepartureTime,
+				"total_distance_meters": itinerary.TotalDistanceMeters,
+			}))
+		}
+	}
+
+	return collection
+}
+
+// Stops as waypoints and each itinerary as a GPX route so navigation apps can follow it
+func ItineraryGPX(itineraries []dto.DriverItineraryDTO) utils.GPX {
+	gpx := utils.NewGPX()
+
+	for _, itinerary := range itineraries {
+		route := utils.GPXRoute{
+			Name:        itineraryName(itinerary),
+			Description: fmt.Sprintf("%d stops, %.0f m", itinerary.TotalStops, itinerary.TotalDistanceMeters),
+		}
+
+		for _, stop := range itinerary.Stops {
+			if stop.Latitude == nil {
+				continue
+			}
+			point := utils.GPXWaypoint{
+				Latitude:    *stop.Latitude,
+				Longitude:   *stop.Longitude,
+				Name:        itineraryStopName(stop),
+				Description: stop.Address,
+				Type:        stop.StopType,
+			}
+			gpx.Waypoints = append(gpx.Waypoints, point)
+			route.Points = append(route.Points, point)
+		}
+
+		if len(route.Points) > 0 {
+			gpx.Routes = append(gpx.Routes, route)
+		}
+	}
+
+	return gpx
+}
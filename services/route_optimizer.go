@@ -0,0 +1,90 @@
+package services
+
+import (
+	"math"
+
+	"shuttle/utils"
+)
+
+const optimizerMaxPasses = 50
+
+type routePoint struct {
+	latitude  float64
+	longitude float64
+}
+
+func pointDistance(a, b routePoint) float64 {
+	return utils.HaversineDistance(a.latitude, a.longitude, b.latitude, b.longitude)
+}
+
+// Length of the path through points in the given order, finishing at end when set
+func pathLength(points []routePoint, order []int, end *routePoint) float64 {
+	total := 0.0
+	for i := 1; i < len(order); i++ {
+		total += pointDistance(points[order[i-1]], points[order[i]])
+	}
+	if end != nil && len(order) > 0 {
+		total += pointDistance(points[order[len(order)-1]], *end)
+	}
+	return total
+}
+
+// Order the pickup points to minimise the distance driven. With a school the
+// path is built backwards from it by nearest neighbour, so it ends there;
+// 2-opt then untangles crossing legs until no reversal helps.
+func optimizeStopOrder(points []routePoint, end *routePoint) []int {
+	if len(points) == 0 {
+		return nil
+	}
+
+	visited := make([]bool, len(points))
+	order := make([]int, 0, len(points))
+
+	current := points[0]
+	if end != nil {
+		current = *end
+	}
+	for len(order) < len(points) {
+		nearest, nearestDistance := -1, math.MaxFloat64
+		for i, point := range points {
+			if visited[i] {
+				continue
+			}
+			if distance := pointDistance(current, point); distance < nearestDistance {
+				nearest, nearestDistance = i, distance
+			}
+		}
+		visited[nearest] = true
+		order = append(order, nearest)
+		current = points[nearest]
+	}
+
+	if end != nil {
+		for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+			order[i], order[j] = order[j], order[i]
+		}
+	}
+
+	best := pathLength(points, order, end)
+	for pass := 0; pass < optimizerMaxPasses; pass++ {
+		improved := false
+		for i := 0; i < len(order)-1; i++ {
+			for k := i + 1; k < len(order); k++ {
+				candidate := make([]int, len(order))
+				copy(candidate, order)
+				for a, b := i, k; a < b; a, b = a+1, b-1 {
+					candidate[a], candidate[b] = candidate[b], candidate[a]
+				}
+
+				if length := pathLength(points, candidate, end); length < best-1e-6 {
+					order, best, improved = candidate, length, true
+				}
+			}
+		}
+		if !improved {
+			break
+		}
+	}
+
+	return order
+}
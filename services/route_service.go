@@ -3,10 +3,13 @@ package services
 import (
 	"database/sql"
 	"fmt"
+	"math"
 	"shuttle/models/dto"
 	"shuttle/models/entity"
 	"shuttle/repositories"
+	"strconv"
 	"time"
+	"shuttle/utils"
 	"github.com/google/uuid"
 )
 
@@ -14,12 +17,15 @@ type RouteServiceInterface interface {
 	GetAllRoutesByAS(page, limit int, sortField, sortDirection, schoolUUID string) ([]dto.RoutesResponseDTO, int, error)
 	GetSpecRouteByAS(routeNameUUID, driverUUID string) (dto.RoutesResponseDTO, error)
 	GetAllRoutesByDriver(driverUUID string) ([]dto.RouteResponseByDriverDTO, error)
+	GetDriverItinerary(driverUUID string) ([]dto.DriverItineraryDTO, error)
+	GetRouteGeoJSON(routeNameUUID, schoolUUID string, date time.Time) (utils.GeoJSONFeatureCollection, error)
 
 	AddRoute(route dto.RoutesRequestDTO, schoolUUID, username string) error
 	UpdateRoute(route dto.RoutesRequestDTO, routenameUUID, schoolUUID, username string) error 
 	DeleteRoute(routenameUUID, schoolUUID, username string) error
 
 	GetDriverUUIDByRouteName(routeNameUUID string) (string, error)
+	OptimizeRoute(req dto.RouteOptimizeRequestDTO, schoolUUID string) (dto.RouteOptimizeResponseDTO, error)
 }
 
 type routeService struct {
@@ -46,6 +52,12 @@ func (service *routeService) GetAllRoutesByAS(page, limit int, sortField, sortDi
 		return nil, 0, fmt.Errorf("failed to count routes: %w", err)
 	}
 
+	for i := range routes {
+		if routes[i].SeatCapacity > 0 {
+			routes[i].SeatUtilization = math.Round(float64(routes[i].AssignedStudents)/float64(routes[i].SeatCapacity)*1000) / 10
+		}
+	}
+
 	return routes, totalItems, nil
 }
 
@@ -72,6 +84,12 @@ func (s *routeService) GetSpecRouteByAS(routeNameUUID, driverUUID string) (dto.R
 	routeResponse.RouteName = routes[0].RouteName
 	routeResponse.RouteDescription = routes[0].RouteDescription
 
+	schedules, err := s.routeRepository.FetchRouteSchedules(routeNameUUID)
+	if err != nil {
+		return dto.RoutesResponseDTO{}, err
+	}
+	routeResponse.Schedules = schedules
+
 	if routes[0].DriverUUID == uuid.Nil {
 		routeResponse.RouteAssignment = nil
 		return routeResponse, nil
@@ -118,6 +136,17 @@ func (service *routeService) AddRoute(route dto.RoutesRequestDTO, schoolUUID, us
 		return err
 	}
 
+	schedules, err := buildRouteSchedules(route.Schedules, schoolUUID, username)
+	if err != nil {
+		return err
+	}
+
+	if route.Optimize {
+		if err := service.applyOptimizedOrder(&route, schoolUUID); err != nil {
+			return err
+		}
+	}
+
 	routeEntity := entity.Routes{
 		RouteID:          time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
 		RouteNameUUID:    uuid.New(),
@@ -148,6 +177,19 @@ func (service *routeService) AddRoute(route dto.RoutesRequestDTO, schoolUUID, us
 
 	parsedRouteUUID := uuid.MustParse(routeNameUUID)
 
+	for _, schedule := range schedules {
+		schedule.RouteNameUUID = parsedRouteUUID
+		if err := service.routeRepository.AddRouteSchedule(tx, schedule); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := service.checkVehicleCapacity(tx, route.RouteAssignment); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	// Insert route assignment
 	for _, assignment := range route.RouteAssignment {
 		isDriverAssigned, err := service.routeRepository.IsDriverAssigned(tx, assignment.DriverUUID.String())
@@ -203,6 +245,17 @@ func (service *routeService) AddRoute(route dto.RoutesRequestDTO, schoolUUID, us
 }
 
 func (service *routeService) UpdateRoute(route dto.RoutesRequestDTO, routenameUUID, schoolUUID, username string) error {
+	schedules, err := buildRouteSchedules(route.Schedules, schoolUUID, username)
+	if err != nil {
+		return err
+	}
+
+	if route.Optimize {
+		if err := service.applyOptimizedOrder(&route, schoolUUID); err != nil {
+			return err
+		}
+	}
+
 	routeEntity := entity.Routes{
 		RouteNameUUID:    uuid.MustParse(routenameUUID),
 		SchoolUUID:       uuid.MustParse(schoolUUID),
@@ -229,6 +282,26 @@ func (service *routeService) UpdateRoute(route dto.RoutesRequestDTO, routenameUU
 		return fmt.Errorf("failed to update route: %w", err)
 	}
 
+	// Schedules are replaced as a whole when sent, and kept when omitted
+	if route.Schedules != nil {
+		if err := service.routeRepository.DeleteRouteSchedules(tx, routenameUUID, username); err != nil {
+			tx.Rollback()
+			return err
+		}
+		for _, schedule := range schedules {
+			schedule.RouteNameUUID = uuid.MustParse(routenameUUID)
+			if err := service.routeRepository.AddRouteSchedule(tx, schedule); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+
+	if err := service.checkVehicleCapacity(tx, route.RouteAssignment); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	for _, assignment := range route.RouteAssignment {
 		for _, student := range assignment.Students {
 			routeAssignmentEntity := entity.RouteAssignment{
@@ -305,6 +378,247 @@ func (s *routeService) GetDriverUUIDByRouteName(routeNameUUID string) (string, e
 	return driverUUID, nil
 }
 
+func (service *routeService) OptimizeRoute(req dto.RouteOptimizeRequestDTO, schoolUUID string) (dto.RouteOptimizeResponseDTO, error) {
+	exists, err := service.routeRepository.DriverExists(req.DriverUUID.String())
+	if err != nil {
+		return dto.RouteOptimizeResponseDTO{}, fmt.Errorf("error checking driver: %w", err)
+	}
+	if !exists {
+		return dto.RouteOptimizeResponseDTO{}, fmt.Errorf("driver not found")
+	}
+
+	studentUUIDs := make([]string, 0, len(req.StudentUUIDs))
+	for _, studentUUID := range req.StudentUUIDs {
+		studentUUIDs = append(studentUUIDs, studentUUID.String())
+	}
+
+	students, err := service.routeRepository.FetchStudentPoints(schoolUUID, studentUUIDs)
+	if err != nil {
+		return dto.RouteOptimizeResponseDTO{}, fmt.Errorf("failed to fetch students: %w", err)
+	}
+	if len(students) != len(studentUUIDs) {
+		return dto.RouteOptimizeResponseDTO{}, fmt.Errorf("student not found")
+	}
+
+	schoolPoint, err := service.routeRepository.FetchSchoolPoint(schoolUUID)
+	if err != nil {
+		return dto.RouteOptimizeResponseDTO{}, fmt.Errorf("failed to fetch school point: %w", err)
+	}
+
+	var end *routePoint
+	if lat, lng, ok := schoolPoint.Coordinates(); ok {
+		end = &routePoint{latitude: lat, longitude: lng}
+	}
+
+	response := dto.RouteOptimizeResponseDTO{DriverUUID: req.DriverUUID.String()}
+
+	var points []routePoint
+	var routable []dto.RouteStudentPointDTO
+	for _, student := range students {
+		lat, lng, ok := student.StudentPickupPoint.Coordinates()
+		if !ok {
+			response.UnroutableStudents = append(response.UnroutableStudents, student.StudentUUID)
+			continue
+		}
+		points = append(points, routePoint{latitude: lat, longitude: lng})
+		routable = append(routable, student)
+	}
+
+	order := optimizeStopOrder(points, end)
+	for position, index := range order {
+		leg := 0.0
+		if position > 0 {
+			leg = pointDistance(points[order[position-1]], points[index])
+		}
+		response.Stops = append(response.Stops, dto.OptimizedStopDTO{
+			StudentUUID:       routable[index].StudentUUID,
+			StudentFirstName:  routable[index].StudentFirstName,
+			StudentLastName:   routable[index].StudentLastName,
+			StudentOrder:      position + 1,
+			Latitude:          points[index].latitude,
+			Longitude:         points[index].longitude,
+			LegDistanceMeters: math.Round(leg),
+		})
+	}
+
+	total := pathLength(points, order, end)
+	if end != nil && len(order) > 0 {
+		response.SchoolLegMeters = math.Round(pointDistance(points[order[len(order)-1]], *end))
+	}
+	response.TotalDistanceMeters = math.Round(total)
+
+	// Same driving assumptions as the live ETA: road detour, town speed and a stop at every pickup
+	duration := time.Duration(total*etaRoadFactor/etaDefaultSpeed*float64(time.Second)) + time.Duration(len(order))*etaStopDwell
+	response.EstimatedDurationSeconds = int64(duration.Seconds())
+
+	return response, nil
+}
+
+// Replace the hand-typed student_order of every assignment with the optimized
+// order; students without a pickup point keep their place after the routed ones
+func (service *routeService) applyOptimizedOrder(route *dto.RoutesRequestDTO, schoolUUID string) error {
+	for i, assignment := range route.RouteAssignment {
+		if len(assignment.Students) == 0 {
+			continue
+		}
+
+		studentUUIDs := make([]uuid.UUID, 0, len(assignment.Students))
+		for _, student := range assignment.Students {
+			studentUUIDs = append(studentUUIDs, student.StudentUUID)
+		}
+
+		optimized, err := service.OptimizeRoute(dto.RouteOptimizeRequestDTO{
+			DriverUUID:   assignment.DriverUUID,
+			StudentUUIDs: studentUUIDs,
+		}, schoolUUID)
+		if err != nil {
+			return err
+		}
+
+		orders := make(map[string]int, len(optimized.Stops))
+		for _, stop := range optimized.Stops {
+			orders[stop.StudentUUID] = stop.StudentOrder
+		}
+
+		next := len(optimized.Stops) + 1
+		for j, student := range assignment.Students {
+			order, exists := orders[student.StudentUUID.String()]
+			if !exists {
+				order = next
+				next++
+			}
+			route.RouteAssignment[i].Students[j].StudentOrder = strconv.Itoa(order)
+		}
+	}
+
+	return nil
+}
+
+// Validate the requested schedules and turn them into entities; the route is filled in by the caller
+func buildRouteSchedules(schedules []dto.RouteScheduleRequestDTO, schoolUUID, username string) ([]entity.RouteSchedule, error) {
+	seen := make(map[string]bool)
+	var result []entity.RouteSchedule
+
+	for _, schedule := range schedules {
+		if schedule.Direction != "to_school" && schedule.Direction != "to_home" {
+			return nil, fmt.Errorf("invalid schedule: direction must be to_school or to_home")
+		}
+
+		departure, err := time.Parse("15:04", schedule.DepartureTime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule: departure_time must be in HH:MM format")
+		}
+
+		key := schedule.Direction + " " + departure.Format("15:04")
+		if seen[key] {
+			return nil, fmt.Errorf("invalid schedule: duplicate %s trip at %s", schedule.Direction, departure.Format("15:04"))
+		}
+		seen[key] = true
+
+		activeDays := []int64{1, 2, 3, 4, 5}
+		if len(schedule.ActiveDays) > 0 {
+			activeDays = nil
+			days := make(map[int]bool)
+			for _, day := range schedule.ActiveDays {
+				if day < 1 || day > 7 {
+					return nil, fmt.Errorf("invalid schedule: active_days must be between 1 (Monday) and 7 (Sunday)")
+				}
+				if !days[day] {
+					days[day] = true
+					activeDays = append(activeDays, int64(day))
+				}
+			}
+		}
+
+		termStart, err := parseTermDate(schedule.TermStart)
+		if err != nil {
+			return nil, err
+		}
+		termEnd, err := parseTermDate(schedule.TermEnd)
+		if err != nil {
+			return nil, err
+		}
+		if termStart.Valid && termEnd.Valid && termEnd.Time.Before(termStart.Time) {
+			return nil, fmt.Errorf("invalid schedule: term_end must not be before term_start")
+		}
+
+		result = append(result, entity.RouteSchedule{
+			ScheduleID:    time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
+			ScheduleUUID:  uuid.New(),
+			SchoolUUID:    uuid.MustParse(schoolUUID),
+			Direction:     schedule.Direction,
+			DepartureTime: departure.Format("15:04"),
+			ActiveDays:    activeDays,
+			TermStart:     termStart,
+			TermEnd:       termEnd,
+			CreatedAt:     sql.NullTime{Time: time.Now(), Valid: true},
+			CreatedBy:     sql.NullString{String: username, Valid: true},
+		})
+	}
+
+	return result, nil
+}
+
+func parseTermDate(value string) (sql.NullTime, error) {
+	if value == "" {
+		return sql.NullTime{}, nil
+	}
+
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return sql.NullTime{}, fmt.Errorf("invalid schedule: term dates must be in YYYY-MM-DD format")
+	}
+	return sql.NullTime{Time: date, Valid: true}, nil
+}
+
+// Returned when a driver would carry more students than their vehicle seats
+type VehicleCapacityError struct {
+	Capacity dto.VehicleCapacityDTO
+}
+
+func (e *VehicleCapacityError) Error() string {
+	return fmt.Sprintf("vehicle capacity exceeded: %s (%s) has %d seats but %d students are assigned",
+		e.Capacity.VehicleName, e.Capacity.VehicleNumber, e.Capacity.VehicleSeats, e.Capacity.AssignedStudents)
+}
+
+// Check every driver's vehicle can seat the students requested for them plus
+// the ones they already carry on other routes
+func (service *routeService) checkVehicleCapacity(tx *sql.Tx, assignments []dto.RouteAssignmentRequestDTO) error {
+	requested := make(map[string][]string)
+	var drivers []string
+	for _, assignment := range assignments {
+		driverUUID := assignment.DriverUUID.String()
+		if _, exists := requested[driverUUID]; !exists {
+			drivers = append(drivers, driverUUID)
+		}
+		for _, student := range assignment.Students {
+			requested[driverUUID] = append(requested[driverUUID], student.StudentUUID.String())
+		}
+	}
+
+	for _, driverUUID := range drivers {
+		capacity, err := service.routeRepository.FetchDriverVehicleCapacity(tx, driverUUID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("driver has no vehicle")
+			}
+			return fmt.Errorf("error checking vehicle capacity: %w", err)
+		}
+
+		others, err := service.routeRepository.CountDriverStudentsExcept(tx, driverUUID, requested[driverUUID])
+		if err != nil {
+			return err
+		}
+
+		capacity.AssignedStudents = others + len(requested[driverUUID])
+		if capacity.AssignedStudents > capacity.VehicleSeats {
+			return &VehicleCapacityError{Capacity: capacity}
+		}
+	}
+
+	return nil
+}
+
 func ValidateDuplicateStudents(routeAssignments []dto.RouteAssignmentRequestDTO) error {
 	studentSet := make(map[string]bool)
 
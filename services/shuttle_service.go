@@ -4,9 +4,11 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"shuttle/errors"
 	"shuttle/models/dto"
 	"shuttle/models/entity"
 	"shuttle/repositories"
+	"shuttle/utils"
 	"time"
 
 	"github.com/google/uuid"
@@ -20,16 +22,25 @@ type ShuttleServiceInterface interface {
 	GetAllShuttleByDriver(driverUUID uuid.UUID) ([]dto.ShuttleAllResponse, error)
 	GetSpecShuttle(shuttleUUID uuid.UUID) ([]dto.ShuttleSpecResponse, error)
 	AddShuttle(req dto.ShuttleRequest, driverUUID, createdBy string) error
-	EditShuttleStatus(shuttleUUID, status string) error
+	EditShuttleStatus(shuttleUUID string, req dto.ShuttleStatusUpdateRequest, actorUUID string) (dto.ShuttleStatusChange, error)
+	GetShuttleStatusHistory(shuttleUUID uuid.UUID) ([]dto.ShuttleStatusHistoryResponse, error)
+	CanAccessShuttle(shuttleUUID, userUUID uuid.UUID, roleCode, schoolUUID string) (bool, error)
+	GetShuttleTrail(shuttleUUID uuid.UUID, from, to time.Time) (dto.ShuttleTrailResponse, error)
+	ConfirmStudentHandover(shuttleUUID uuid.UUID, driverUUID, handoverType string, req dto.StudentHandoverRequest, proofFile string) (dto.StudentHandoverResponse, error)
+	GetStudentHandovers(schoolUUID string, filter dto.StudentHandoverFilter, page, limit int) ([]dto.StudentHandoverResponse, int, error)
 }
 
 type ShuttleService struct {
-	shuttleRepository repositories.ShuttleRepositoryInterface
+	shuttleRepository  repositories.ShuttleRepositoryInterface
+	locationRepository repositories.LocationRepositoryInterface
+	etaService         ETAServiceInterface
 }
 
-func NewShuttleService(shuttleRepository repositories.ShuttleRepositoryInterface) ShuttleServiceInterface {
+func NewShuttleService(shuttleRepository repositories.ShuttleRepositoryInterface, locationRepository repositories.LocationRepositoryInterface, etaService ETAServiceInterface) ShuttleServiceInterface {
 	return &ShuttleService{
-		shuttleRepository: shuttleRepository,
+		shuttleRepository:  shuttleRepository,
+		locationRepository: locationRepository,
+		etaService:         etaService,
 	}
 }
 
@@ -65,6 +76,10 @@ func (s *ShuttleService) GetShuttleTrackByParent(parentUUID uuid.UUID) ([]dto.Sh
 	}
 
 	log.Println("Fetched shuttle data:", shuttles)
+
+	// One estimate per driver covers every child riding with them
+	etasByDriver := make(map[string]map[string]dto.StudentETAResponse)
+
 	responses := make([]dto.ShuttleResponse, 0, len(shuttles))
 	for _, shuttle := range shuttles {
 		response := &dto.ShuttleResponse{
@@ -76,9 +91,25 @@ func (s *ShuttleService) GetShuttleTrackByParent(parentUUID uuid.UUID) ([]dto.Sh
 			SchoolUUID:     shuttle.SchoolUUID,
 			SchoolName:     shuttle.SchoolName,
 			ShuttleStatus:  shuttle.ShuttleStatus,
+			DriverUUID:     shuttle.DriverUUID,
 			CreatedAt:      shuttle.CreatedAt,
 			CurrentDate:    shuttle.CurrentDate,
 		}
+
+		if driverUUID, err := uuid.Parse(shuttle.DriverUUID); err == nil {
+			etas, fetched := etasByDriver[shuttle.DriverUUID]
+			if !fetched {
+				etas, err = s.etaService.GetStudentETAs(driverUUID)
+				if err != nil {
+					log.Println("Error estimating shuttle ETA:", err)
+				}
+				etasByDriver[shuttle.DriverUUID] = etas
+			}
+			if eta, exists := etas[shuttle.StudentUUID]; exists {
+				response.ETA = &eta
+			}
+		}
+
 		responses = append(responses, *response)
 	}
 
@@ -220,15 +251,295 @@ func (s *ShuttleService) AddShuttle(req dto.ShuttleRequest, driverUUID, createdB
 	return nil
 }
 
-func (s *ShuttleService) EditShuttleStatus(shuttleUUID, status string) error {
+// A shuttle runs one loop per day: home, to school, at school and back home again
+var shuttleStatusTransitions = map[string]string{
+	"home":                          "waiting_to_be_taken_to_school",
+	"waiting_to_be_taken_to_school": "going_to_school",
+	"going_to_school":               "at_school",
+	"at_school":                     "waiting_to_be_taken_to_home",
+	"waiting_to_be_taken_to_home":   "going_to_home",
+	"going_to_home":                 "home",
+}
+
+func (s *ShuttleService) EditShuttleStatus(shuttleUUID string, req dto.ShuttleStatusUpdateRequest, actorUUID string) (dto.ShuttleStatusChange, error) {
 	shuttleUUIDParsed, err := uuid.Parse(shuttleUUID)
 	if err != nil {
-		return err
+		return dto.ShuttleStatusChange{}, err
 	}
 
-	if err := s.shuttleRepository.UpdateShuttleStatus(shuttleUUIDParsed, status); err != nil {
-		return err
+	if _, known := shuttleStatusTransitions[req.Status]; !known {
+		return dto.ShuttleStatusChange{}, errors.New("invalid shuttle status: "+req.Status, 400)
 	}
 
-	return nil
+	tx, err := s.shuttleRepository.BeginTransaction()
+	if err != nil {
+		return dto.ShuttleStatusChange{}, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	currentStatus, err := s.shuttleRepository.FetchShuttleStatusForUpdate(tx, shuttleUUIDParsed, actorUUID)
+	if err == sql.ErrNoRows {
+		return dto.ShuttleStatusChange{}, errors.New("shuttle not found", 404)
+	}
+	if err != nil {
+		return dto.ShuttleStatusChange{}, err
+	}
+
+	if currentStatus == req.Status {
+		return dto.ShuttleStatusChange{}, errors.New("shuttle is already "+currentStatus, 409)
+	}
+	if next := shuttleStatusTransitions[currentStatus]; next != req.Status {
+		return dto.ShuttleStatusChange{}, errors.New(fmt.Sprintf("cannot change shuttle status from %s to %s, next status must be %s", currentStatus, req.Status, next), 409)
+	}
+
+	change, err := s.shuttleRepository.UpdateShuttleStatus(tx, shuttleUUIDParsed, req.Status)
+	if err != nil {
+		return dto.ShuttleStatusChange{}, err
+	}
+
+	history := entity.ShuttleStatusHistory{
+		HistoryID:   time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
+		ShuttleUUID: shuttleUUIDParsed,
+		FromStatus:  sql.NullString{String: change.OldStatus, Valid: true},
+		ToStatus:    change.NewStatus,
+		ChangedAt:   change.ChangedAt,
+	}
+	if actor, err := uuid.Parse(actorUUID); err == nil {
+		history.ChangedBy = uuid.NullUUID{UUID: actor, Valid: true}
+	}
+	if req.Latitude != nil && req.Longitude != nil {
+		history.Latitude = sql.NullFloat64{Float64: *req.Latitude, Valid: true}
+		history.Longitude = sql.NullFloat64{Float64: *req.Longitude, Valid: true}
+	}
+
+	if err := s.shuttleRepository.SaveShuttleStatusHistory(tx, history); err != nil {
+		return dto.ShuttleStatusChange{}, fmt.Errorf("failed to save status history: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return dto.ShuttleStatusChange{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return change, nil
+}
+
+func (s *ShuttleService) GetShuttleStatusHistory(shuttleUUID uuid.UUID) ([]dto.ShuttleStatusHistoryResponse, error) {
+	history, err := s.shuttleRepository.FetchShuttleStatusHistory(shuttleUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch shuttle status history: %w", err)
+	}
+
+	response := make([]dto.ShuttleStatusHistoryResponse, 0, len(history))
+	for _, entry := range history {
+		item := dto.ShuttleStatusHistoryResponse{
+			FromStatus: entry.FromStatus.String,
+			ToStatus:   entry.ToStatus,
+			ChangedAt:  entry.ChangedAt.Format(time.RFC3339),
+		}
+		if entry.ChangedBy.Valid {
+			item.ChangedBy = entry.ChangedBy.UUID.String()
+		}
+		if entry.Latitude.Valid && entry.Longitude.Valid {
+			item.Latitude = &entry.Latitude.Float64
+			item.Longitude = &entry.Longitude.Float64
+		}
+		response = append(response, item)
+	}
+
+	return response, nil
+}
+
+// School admins may read any shuttle of their school, drivers and parents only the shuttles they belong to
+func (s *ShuttleService) CanAccessShuttle(shuttleUUID, userUUID uuid.UUID, roleCode, schoolUUID string) (bool, error) {
+	switch roleCode {
+	case "SA":
+		return true, nil
+	case "AS":
+		return s.shuttleRepository.CheckShuttleInSchool(shuttleUUID, schoolUUID)
+	default:
+		return s.shuttleRepository.CheckIfExistInShuttle(userUUID, shuttleUUID)
+	}
+}
+
+func (s *ShuttleService) GetShuttleTrail(shuttleUUID uuid.UUID, from, to time.Time) (dto.ShuttleTrailResponse, error) {
+	locations, err := s.locationRepository.FetchTrailByShuttle(shuttleUUID, from, to)
+	if err != nil {
+		return dto.ShuttleTrailResponse{}, fmt.Errorf("failed to fetch shuttle trail: %w", err)
+	}
+
+	points := make([]dto.ShuttleTrailPointResponse, 0, len(locations))
+	for _, location := range locations {
+		point := dto.ShuttleTrailPointResponse{
+			UserUUID:   location.UserUUID.String(),
+			Latitude:   location.Latitude,
+			Longitude:  location.Longitude,
+			RecordedAt: location.RecordedAt.Format(time.RFC3339),
+		}
+		if location.Speed.Valid {
+			point.Speed = &location.Speed.Float64
+		}
+		if location.Heading.Valid {
+			point.Heading = &location.Heading.Float64
+		}
+		points = append(points, point)
+	}
+
+	return dto.ShuttleTrailResponse{
+		ShuttleUUID: shuttleUUID.String(),
+		From:        from.Format(time.RFC3339),
+		To:          to.Format(time.RFC3339),
+		TotalPoints: len(points),
+		Points:      points,
+	}, nil
+}
+
+const (
+	HandoverTypeBoarded  = "boarded"
+	HandoverTypeAlighted = "alighted"
+)
+
+// Record that the driver handed a student on or off the shuttle. A student has
+// to board before alighting and cannot board twice without alighting in between.
+func (s *ShuttleService) ConfirmStudentHandover(shuttleUUID uuid.UUID, driverUUID, handoverType string, req dto.StudentHandoverRequest, proofFile string) (dto.StudentHandoverResponse, error) {
+	if handoverType != HandoverTypeBoarded && handoverType != HandoverTypeAlighted {
+		return dto.StudentHandoverResponse{}, errors.New("invalid handover type: "+handoverType, 400)
+	}
+
+	if req.ProofType == "" && proofFile != "" {
+		req.ProofType = "photo"
+	}
+	if req.ProofType != "" && req.ProofType != "photo" && req.ProofType != "signature" {
+		return dto.StudentHandoverResponse{}, errors.New("invalid proof type, use 'photo' or 'signature'", 400)
+	}
+	if req.ProofType != "" && proofFile == "" {
+		return dto.StudentHandoverResponse{}, errors.New("proof file is required for proof type "+req.ProofType, 400)
+	}
+
+	tx, err := s.shuttleRepository.BeginTransaction()
+	if err != nil {
+		return dto.StudentHandoverResponse{}, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	context, err := s.shuttleRepository.FetchShuttleHandoverContext(tx, shuttleUUID)
+	if err == sql.ErrNoRows || (err == nil && context.DriverUUID != driverUUID) {
+		return dto.StudentHandoverResponse{}, errors.New("shuttle not found", 404)
+	}
+	if err != nil {
+		return dto.StudentHandoverResponse{}, err
+	}
+
+	lastType, err := s.shuttleRepository.FetchLastHandoverType(tx, shuttleUUID)
+	if err != nil {
+		return dto.StudentHandoverResponse{}, err
+	}
+	if handoverType == HandoverTypeBoarded && lastType == HandoverTypeBoarded {
+		return dto.StudentHandoverResponse{}, errors.New("student is already on board", 409)
+	}
+	if handoverType == HandoverTypeAlighted && lastType != HandoverTypeBoarded {
+		return dto.StudentHandoverResponse{}, errors.New("student has not boarded", 409)
+	}
+
+	tripUUID, err := s.shuttleRepository.FetchInProgressTripByShuttle(tx, shuttleUUID)
+	if err != nil {
+		return dto.StudentHandoverResponse{}, err
+	}
+
+	handover := entity.StudentHandover{
+		HandoverID:   time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
+		HandoverUUID: uuid.New(),
+		ShuttleUUID:  shuttleUUID,
+		TripUUID:     tripUUID,
+		StudentUUID:  uuid.MustParse(context.StudentUUID),
+		DriverUUID:   uuid.MustParse(context.DriverUUID),
+		HandoverType: handoverType,
+		ProofType:    sql.NullString{String: req.ProofType, Valid: req.ProofType != ""},
+		ProofFile:    sql.NullString{String: proofFile, Valid: proofFile != ""},
+		Notes:        sql.NullString{String: req.Notes, Valid: req.Notes != ""},
+		ConfirmedAt:  time.Now(),
+	}
+	if req.Latitude != nil && req.Longitude != nil {
+		handover.Latitude = sql.NullFloat64{Float64: *req.Latitude, Valid: true}
+		handover.Longitude = sql.NullFloat64{Float64: *req.Longitude, Valid: true}
+	}
+
+	if err := s.shuttleRepository.SaveStudentHandover(tx, handover); err != nil {
+		return dto.StudentHandoverResponse{}, fmt.Errorf("failed to save handover: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return dto.StudentHandoverResponse{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	response := dto.StudentHandoverResponse{
+		HandoverUUID:     handover.HandoverUUID.String(),
+		ShuttleUUID:      context.ShuttleUUID,
+		StudentUUID:      context.StudentUUID,
+		StudentFirstName: context.StudentFirstName,
+		DriverUUID:       context.DriverUUID,
+		HandoverType:     handoverType,
+		ProofType:        req.ProofType,
+		Notes:            req.Notes,
+		ConfirmedAt:      handover.ConfirmedAt.Format(time.RFC3339),
+		ParentUUID:       context.ParentUUID,
+	}
+	if tripUUID.Valid {
+		response.TripUUID = tripUUID.UUID.String()
+	}
+	if handover.Latitude.Valid {
+		response.Latitude = &handover.Latitude.Float64
+		response.Longitude = &handover.Longitude.Float64
+	}
+	if proofFile != "" {
+		if response.ProofURL, err = utils.GenerateImageAssetsURL(proofFile); err != nil {
+			return dto.StudentHandoverResponse{}, err
+		}
+	}
+
+	return response, nil
+}
+
+func (s *ShuttleService) GetStudentHandovers(schoolUUID string, filter dto.StudentHandoverFilter, page, limit int) ([]dto.StudentHandoverResponse, int, error) {
+	offset := (page - 1) * limit
+
+	records, err := s.shuttleRepository.FetchStudentHandovers(offset, limit, schoolUUID, filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch handovers: %w", err)
+	}
+
+	total, err := s.shuttleRepository.CountStudentHandovers(schoolUUID, filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count handovers: %w", err)
+	}
+
+	response := make([]dto.StudentHandoverResponse, 0, len(records))
+	for _, record := range records {
+		item := dto.StudentHandoverResponse{
+			HandoverUUID:     record.HandoverUUID,
+			ShuttleUUID:      record.ShuttleUUID,
+			TripUUID:         record.TripUUID.String,
+			StudentUUID:      record.StudentUUID,
+			StudentFirstName: record.StudentFirstName,
+			StudentLastName:  record.StudentLastName,
+			DriverUUID:       record.DriverUUID,
+			DriverFirstName:  record.DriverFirstName,
+			DriverLastName:   record.DriverLastName,
+			HandoverType:     record.HandoverType,
+			ProofType:        record.ProofType.String,
+			Notes:            record.Notes.String,
+			ConfirmedAt:      record.ConfirmedAt.Format(time.RFC3339),
+		}
+		if record.Latitude.Valid && record.Longitude.Valid {
+			item.Latitude = &record.Latitude.Float64
+			item.Longitude = &record.Longitude.Float64
+		}
+		if record.ProofFile.Valid {
+			if item.ProofURL, err = utils.GenerateImageAssetsURL(record.ProofFile.String); err != nil {
+				return nil, 0, err
+			}
+		}
+		response = append(response, item)
+	}
+
+	return response, total, nil
 }
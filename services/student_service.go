@@ -2,10 +2,11 @@ package services
 
 import (
 	"database/sql"
-	"encoding/json"
+	"math"
 	"shuttle/errors"
 	"shuttle/models/dto"
 	"shuttle/models/entity"
+	"shuttle/models/geo"
 	"shuttle/repositories"
 	"time"
 
@@ -17,6 +18,8 @@ type StudentServiceInterface interface {
 	GetAllStudentsWithParents(page int, limit int, sortField string, sortDirection string, schoolUUIDStr string) ([]dto.SchoolStudentParentResponseDTO, int, error)
 	GetSpecStudentWithParents(id, schoolUUIDStr string) (dto.SchoolStudentParentResponseDTO, error)
 	GetAvailableStudents(schoolUUID string) ([]dto.StudentResponseDTO, error)
+	GetNearbyStudents(schoolUUID string, center *geo.GeoPoint, radiusMeters float64, onlyAvailable bool, limit int) (dto.NearbyStudentsResponseDTO, error)
+	GetRouteCandidates(routeNameUUID, schoolUUID string, radiusMeters float64, limit int) (dto.NearbyStudentsResponseDTO, error)
 	AddSchoolStudentWithParents(student dto.SchoolStudentParentRequestDTO, schoolUUID string, username string) error
 	UpdateSchoolStudentWithParents(id string, student dto.SchoolStudentParentRequestDTO, schoolUUID, username string) error
 	DeleteSchoolStudentWithParentsIfNeccessary(id, schoolUUID, username string) error
@@ -26,13 +29,15 @@ type StudentService struct {
 	userService       UserServiceInterface
 	studentRepository repositories.StudentRepositoryInterface
 	userRepository    repositories.UserRepositoryInterface
+	routeRepository   repositories.RouteRepositoryInterface
 }
 
-func NewStudentService(studentRepository repositories.StudentRepositoryInterface, userService UserServiceInterface, userRepository repositories.UserRepositoryInterface) StudentService {
+func NewStudentService(studentRepository repositories.StudentRepositoryInterface, userService UserServiceInterface, userRepository repositories.UserRepositoryInterface, routeRepository repositories.RouteRepositoryInterface) StudentService {
 	return StudentService{
 		userService:       userService,
 		studentRepository: studentRepository,
 		userRepository:    userRepository,
+		routeRepository:   routeRepository,
 	}
 }
 
@@ -116,11 +121,6 @@ func (service *StudentService) GetSpecStudentWithParents(id, schoolUUIDStr strin
 		Address = student.StudentAddress.String
 	}
 
-	var pickupPoint string
-	if student.StudentPickupPoint.Valid {
-		pickupPoint = student.StudentPickupPoint.String
-	}
-
 	return dto.SchoolStudentParentResponseDTO{
 		StudentUUID:      student.UUID.String(),
 		ParentUUID:       student.ParentUUID.String,
@@ -136,7 +136,7 @@ func (service *StudentService) GetSpecStudentWithParents(id, schoolUUIDStr strin
 		StudentGrade:     student.Grade,
 		StudentStatus:    student.Status,
 		Address:          Address,
-		PickupPoint:      pickupPoint,
+		PickupPoint:      student.StudentPickupPoint,
 		CreatedAt:        safeTimeFormat(student.CreatedAt),
 		CreatedBy:        safeStringFormat(student.CreatedBy),
 		UpdatedAt:        safeTimeFormat(student.UpdatedAt),
@@ -166,6 +166,120 @@ func (service *StudentService) GetAvailableStudents(schoolUUID string) ([]dto.St
 	return studentDTOs, nil
 }
 
+// Present students living within the radius of the center, which defaults to the school
+func (service *StudentService) GetNearbyStudents(schoolUUID string, center *geo.GeoPoint, radiusMeters float64, onlyAvailable bool, limit int) (dto.NearbyStudentsResponseDTO, error) {
+	if center == nil {
+		schoolPoint, err := service.routeRepository.FetchSchoolPoint(schoolUUID)
+		if err != nil {
+			return dto.NearbyStudentsResponseDTO{}, err
+		}
+		if !schoolPoint.Valid {
+			return dto.NearbyStudentsResponseDTO{}, errors.New("school has no location, pass latitude and longitude", 400)
+		}
+		center = schoolPoint.Ptr()
+	}
+
+	var studentUUIDs []string
+	if onlyAvailable {
+		available, err := service.availableStudentUUIDs(schoolUUID)
+		if err != nil {
+			return dto.NearbyStudentsResponseDTO{}, err
+		}
+		studentUUIDs = available
+	}
+
+	students, err := service.studentRepository.FetchStudentsNearPoints(schoolUUID, []geo.GeoPoint{*center}, radiusMeters, studentUUIDs, limit)
+	if err != nil {
+		return dto.NearbyStudentsResponseDTO{}, err
+	}
+
+	response := dto.NearbyStudentsResponseDTO{
+		Center:       center,
+		RadiusMeters: radiusMeters,
+		Students:     make([]dto.NearbyStudentDTO, 0, len(students)),
+	}
+	for _, student := range students {
+		response.Students = append(response.Students, nearbyStudentDTO(student))
+	}
+
+	return response, nil
+}
+
+// Unassigned present students living near any stop already on the route
+func (service *StudentService) GetRouteCandidates(routeNameUUID, schoolUUID string, radiusMeters float64, limit int) (dto.NearbyStudentsResponseDTO, error) {
+	stops, err := service.routeRepository.FetchRouteGeoStops(routeNameUUID, schoolUUID)
+	if err != nil {
+		return dto.NearbyStudentsResponseDTO{}, err
+	}
+	if len(stops) == 0 {
+		return dto.NearbyStudentsResponseDTO{}, errors.New("route not found", 404)
+	}
+
+	response := dto.NearbyStudentsResponseDTO{
+		RouteUUID:    routeNameUUID,
+		RadiusMeters: radiusMeters,
+		Students:     []dto.NearbyStudentDTO{},
+	}
+
+	var points []geo.GeoPoint
+	var stopStudents []string
+	for _, stop := range stops {
+		if stop.StudentUUID == "" || !stop.StudentPickupPoint.Valid {
+			continue
+		}
+		points = append(points, stop.StudentPickupPoint.GeoPoint)
+		stopStudents = append(stopStudents, stop.StudentUUID)
+	}
+	if len(points) == 0 {
+		return response, nil
+	}
+
+	available, err := service.availableStudentUUIDs(schoolUUID)
+	if err != nil {
+		return dto.NearbyStudentsResponseDTO{}, err
+	}
+
+	students, err := service.studentRepository.FetchStudentsNearPoints(schoolUUID, points, radiusMeters, available, limit)
+	if err != nil {
+		return dto.NearbyStudentsResponseDTO{}, err
+	}
+
+	for _, student := range students {
+		candidate := nearbyStudentDTO(student)
+		if student.NearestIndex >= 0 && student.NearestIndex < len(stopStudents) {
+			candidate.NearestStopStudentUUID = stopStudents[student.NearestIndex]
+		}
+		response.Students = append(response.Students, candidate)
+	}
+
+	return response, nil
+}
+
+// Students without a route assignment, as listed by GetAvailableStudents
+func (service *StudentService) availableStudentUUIDs(schoolUUID string) ([]string, error) {
+	available, err := service.GetAvailableStudents(schoolUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	uuids := make([]string, 0, len(available))
+	for _, student := range available {
+		uuids = append(uuids, student.UUID)
+	}
+	return uuids, nil
+}
+
+func nearbyStudentDTO(student dto.StudentDistanceDTO) dto.NearbyStudentDTO {
+	return dto.NearbyStudentDTO{
+		StudentUUID:        student.StudentUUID,
+		StudentFirstName:   student.StudentFirstName,
+		StudentLastName:    student.StudentLastName,
+		StudentAddress:     student.StudentAddress,
+		StudentPickupPoint: student.StudentPickupPoint,
+		DistanceMeters:     math.Round(student.DistanceMeters),
+	}
+}
+
 func (service *StudentService) AddSchoolStudentWithParents(student dto.SchoolStudentParentRequestDTO, schoolUUID string, username string) error {
 	var parentID uuid.UUID
 
@@ -214,15 +328,6 @@ func (service *StudentService) AddSchoolStudentWithParents(student dto.SchoolStu
 		}
 	}
 
-	var pickupPointJSON []byte
-	if student.Student.StudentPickupPoint != nil {
-		pickupPointJSON, err = json.Marshal(student.Student.StudentPickupPoint)
-		if err != nil {
-			transactionError = err
-			return transactionError
-		}
-	}
-
 	if student.Student.StudentStatus == "" {
 		student.Student.StudentStatus = "present"
 	}
@@ -238,7 +343,7 @@ func (service *StudentService) AddSchoolStudentWithParents(student dto.SchoolStu
 		Grade:              student.Student.StudentGrade,
 		Status:             student.Student.StudentStatus,
 		StudentAddress:     sql.NullString{String: student.Student.StudentAddress, Valid: true},
-		StudentPickupPoint: sql.NullString{String: string(pickupPointJSON), Valid: true},
+		StudentPickupPoint: geo.NewNullGeoPoint(student.Student.StudentPickupPoint),
 		CreatedBy:          sql.NullString{String: username, Valid: true},
 	}
 
@@ -257,12 +362,6 @@ func (service *StudentService) UpdateSchoolStudentWithParents(id string, student
 		return err
 	}
 
-	// Proses pickup point menjadi JSON
-	pickupPointJSON, err := json.Marshal(student.StudentPickupPoint)
-	if err != nil {
-		return err
-	}
-
 	studentEntity := entity.Student{
 		UUID:               studentUUID,
 		SchoolUUID:         *parseSafeUUID(schoolUUID),
@@ -271,7 +370,7 @@ func (service *StudentService) UpdateSchoolStudentWithParents(id string, student
 		Gender:             string(student.StudentGender),
 		Grade:              student.StudentGrade,
 		StudentAddress:     sql.NullString{String: student.StudentAddress, Valid: true},
-		StudentPickupPoint: sql.NullString{String: string(pickupPointJSON), Valid: true},
+		StudentPickupPoint: geo.NewNullGeoPoint(student.StudentPickupPoint),
 		UpdatedBy:          sql.NullString{String: username, Valid: true},
 	}
 
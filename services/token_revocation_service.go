@@ -0,0 +1,204 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"shuttle/logger"
+	"shuttle/models/entity"
+	"shuttle/repositories"
+	"shuttle/utils"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	defaultRevocationCacheTTL      = 30 * time.Second
+	defaultRevocationPurgeInterval = time.Hour
+)
+
+type TokenRevocationServiceInterface interface {
+	Revoke(id, userUUID string, expiresAt time.Time) error
+	RevokeInTransaction(tx *sqlx.Tx, userUUID uuid.UUID, ids []uuid.UUID, expiresAt time.Time) error
+	MarkRevoked(ids []uuid.UUID, expiresAt time.Time)
+	IsRevoked(expiresAt time.Time, ids ...string) (bool, error)
+	RunPurge()
+}
+
+// Revoked access tokens live in Postgres so they survive restarts and are
+// shared between instances. An id is either a token's jti or a session UUID,
+// which revokes every access token issued for that session. Revocations are cached until the token expires;
+// tokens found valid are only trusted for the cache TTL, which bounds how long
+// a revocation made on another instance takes to be seen here.
+type TokenRevocationService struct {
+	tokenRepository repositories.TokenRepositoryInterface
+	cacheTTL        time.Duration
+	purgeInterval   time.Duration
+
+	mu      sync.Mutex
+	revoked map[string]time.Time
+	cleared map[string]time.Time
+}
+
+func NewTokenRevocationService(tokenRepository repositories.TokenRepositoryInterface) TokenRevocationServiceInterface {
+	service := &TokenRevocationService{
+		tokenRepository: tokenRepository,
+		cacheTTL:        utils.DurationFromConfig("TOKEN_REVOCATION_CACHE_TTL", defaultRevocationCacheTTL),
+		purgeInterval:   utils.DurationFromConfig("TOKEN_REVOCATION_PURGE_INTERVAL", defaultRevocationPurgeInterval),
+		revoked:         make(map[string]time.Time),
+		cleared:         make(map[string]time.Time),
+	}
+
+	tokens, err := tokenRepository.FetchActiveRevokedTokens(time.Now())
+	if err != nil {
+		logger.LogError(err, "Failed to load revoked tokens", nil)
+	}
+	for _, token := range tokens {
+		service.revoked[token.JTI.String()] = token.ExpiresAt
+	}
+
+	return service
+}
+
+func (s *TokenRevocationService) Revoke(id, userUUID string, expiresAt time.Time) error {
+	parsedID, err := uuid.Parse(id)
+	if err != nil {
+		return err
+	}
+	parsedUserUUID, err := uuid.Parse(userUUID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.tokenRepository.SaveRevokedToken(entity.RevokedToken{
+		JTI:       parsedID,
+		UserUUID:  parsedUserUUID,
+		ExpiresAt: expiresAt,
+		RevokedAt: time.Now(),
+	}); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.revoked[id] = expiresAt
+	delete(s.cleared, id)
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Store revocations as part of a larger transaction; the caller passes the
+// same ids to MarkRevoked once it has committed
+func (s *TokenRevocationService) RevokeInTransaction(tx *sqlx.Tx, userUUID uuid.UUID, ids []uuid.UUID, expiresAt time.Time) error {
+	now := time.Now()
+	tokens := make([]entity.RevokedToken, 0, len(ids))
+	for _, id := range ids {
+		tokens = append(tokens, entity.RevokedToken{
+			JTI:       id,
+			UserUUID:  userUUID,
+			ExpiresAt: expiresAt,
+			RevokedAt: now,
+		})
+	}
+
+	return s.tokenRepository.SaveRevokedTokens(tx, tokens)
+}
+
+func (s *TokenRevocationService) MarkRevoked(ids []uuid.UUID, expiresAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		s.revoked[id.String()] = expiresAt
+		delete(s.cleared, id.String())
+	}
+}
+
+// Whether any of the ids, a token's jti and its session, has been revoked
+func (s *TokenRevocationService) IsRevoked(expiresAt time.Time, ids ...string) (bool, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	unchecked := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := s.revoked[id]; ok {
+			s.mu.Unlock()
+			return true, nil
+		}
+		if checkedAt, ok := s.cleared[id]; !ok || now.Sub(checkedAt) >= s.cacheTTL {
+			unchecked = append(unchecked, id)
+		}
+	}
+	s.mu.Unlock()
+
+	if len(unchecked) == 0 {
+		return false, nil
+	}
+
+	revoked, err := s.tokenRepository.IsAnyTokenRevoked(unchecked)
+	if err != nil {
+		return false, err
+	}
+
+	// With several ids it isn't known which one was revoked, the next check asks again
+	if revoked {
+		if len(unchecked) == 1 {
+			s.mu.Lock()
+			s.revoked[unchecked[0]] = expiresAt
+			delete(s.cleared, unchecked[0])
+			s.mu.Unlock()
+		}
+		return true, nil
+	}
+
+	s.mu.Lock()
+	for _, id := range unchecked {
+		s.cleared[id] = now
+	}
+	s.mu.Unlock()
+
+	return false, nil
+}
+
+// Drop revocations of tokens that have expired anyway, in the database and in the
+// cache, along with sessions past their absolute expiry
+func (s *TokenRevocationService) RunPurge() {
+	ticker := time.NewTicker(s.purgeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+
+		purged, err := s.tokenRepository.DeleteExpiredRevokedTokens(now)
+		if err != nil {
+			logger.LogError(err, "Failed to purge revoked tokens", nil)
+		} else if purged > 0 {
+			logger.LogInfo("Expired revoked tokens purged", map[string]interface{}{
+				"tokens": purged,
+			})
+		}
+
+		expiredSessions, err := s.tokenRepository.DeleteExpiredSessions(now)
+		if err != nil {
+			logger.LogError(err, "Failed to purge expired sessions", nil)
+		} else if expiredSessions > 0 {
+			logger.LogInfo("Expired sessions purged", map[string]interface{}{
+				"sessions": expiredSessions,
+			})
+		}
+
+		s.mu.Lock()
+		for jti, expiresAt := range s.revoked {
+			if !expiresAt.After(now) {
+				delete(s.revoked, jti)
+			}
+		}
+		for jti, checkedAt := range s.cleared {
+			if now.Sub(checkedAt) >= s.cacheTTL {
+				delete(s.cleared, jti)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
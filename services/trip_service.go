@@ -0,0 +1,304 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"shuttle/errors"
+	"shuttle/logger"
+	"shuttle/models/dto"
+	"shuttle/models/entity"
+	"shuttle/repositories"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/viper"
+)
+
+const (
+	TripStatusScheduled  = "scheduled"
+	TripStatusInProgress = "in_progress"
+	TripStatusCompleted  = "completed"
+
+	defaultTripGenerationTime = "04:00"
+)
+
+type TripServiceInterface interface {
+	GenerateTrips(date time.Time, schoolUUID, createdBy string) (int, error)
+	GetDriverTripsToday(driverUUID string) ([]dto.TripResponseDTO, error)
+	StartTrip(tripUUID, driverUUID string) error
+	FinishTrip(tripUUID, driverUUID string) error
+	GetTripCountByDate(date time.Time, status string) (int, error)
+	RunDailyGeneration()
+}
+
+type TripService struct {
+	tripRepository repositories.TripRepositoryInterface
+}
+
+func NewTripService(tripRepository repositories.TripRepositoryInterface) TripServiceInterface {
+	return &TripService{
+		tripRepository: tripRepository,
+	}
+}
+
+// Turn every route running on the date into a trip with its manifest of
+// present students. Safe to call repeatedly; existing trips are left alone.
+func (s *TripService) GenerateTrips(date time.Time, schoolUUID, createdBy string) (int, error) {
+	tripDate := date.Format("2006-01-02")
+
+	candidates, err := s.tripRepository.FetchTripCandidates(tripDate, schoolUUID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch routes for trips: %w", err)
+	}
+
+	tx, err := s.tripRepository.BeginTransaction()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	created := 0
+	for _, candidate := range candidates {
+		students, err := s.tripRepository.FetchTripStudents(tx, candidate.RouteNameUUID, candidate.DriverUUID, tripDate)
+		if err != nil {
+			return 0, fmt.Errorf("failed to fetch trip students: %w", err)
+		}
+		if len(students) == 0 {
+			continue
+		}
+
+		trip := entity.Trip{
+			TripID:        time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
+			TripUUID:      uuid.New(),
+			RouteNameUUID: uuid.MustParse(candidate.RouteNameUUID),
+			SchoolUUID:    uuid.MustParse(candidate.SchoolUUID),
+			DriverUUID:    uuid.MustParse(candidate.DriverUUID),
+			TripDate:      tripDate,
+			Direction:     candidate.Direction,
+			DepartureTime: candidate.DepartureTime,
+			Status:        TripStatusScheduled,
+			CreatedAt:     sql.NullTime{Time: time.Now(), Valid: true},
+			CreatedBy:     sql.NullString{String: createdBy, Valid: createdBy != ""},
+		}
+		if candidate.ScheduleUUID.Valid {
+			trip.ScheduleUUID = uuid.NullUUID{UUID: uuid.MustParse(candidate.ScheduleUUID.String), Valid: true}
+		}
+
+		inserted, err := s.tripRepository.SaveTrip(tx, trip)
+		if err != nil {
+			return 0, fmt.Errorf("failed to save trip: %w", err)
+		}
+		if !inserted {
+			continue
+		}
+
+		// The way home visits the stops in reverse
+		for i, student := range students {
+			stopOrder := i + 1
+			if candidate.Direction.String == "to_home" {
+				stopOrder = len(students) - i
+			}
+
+			manifest := entity.TripManifest{
+				ManifestID:  time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
+				TripUUID:    trip.TripUUID,
+				StudentUUID: uuid.MustParse(student.StudentUUID),
+				StopOrder:   stopOrder,
+				CreatedAt:   sql.NullTime{Time: time.Now(), Valid: true},
+			}
+			if err := s.tripRepository.SaveTripManifest(tx, manifest); err != nil {
+				return 0, fmt.Errorf("failed to save trip manifest: %w", err)
+			}
+		}
+
+		created++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return created, nil
+}
+
+func (s *TripService) GetDriverTripsToday(driverUUID string) ([]dto.TripResponseDTO, error) {
+	trips, err := s.tripRepository.FetchTripsByDriver(driverUUID, time.Now().Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range trips {
+		manifest, err := s.tripRepository.FetchTripManifest(trips[i].TripUUID)
+		if err != nil {
+			return nil, err
+		}
+		trips[i].Manifest = manifest
+	}
+
+	return trips, nil
+}
+
+// Start today's trip and open a shuttle row for every student on the manifest,
+// reusing the one from an earlier trip today so the daily status loop carries on
+func (s *TripService) StartTrip(tripUUID, driverUUID string) error {
+	tx, err := s.tripRepository.BeginTransaction()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	trip, err := s.lockDriverTrip(tx, tripUUID, driverUUID)
+	if err != nil {
+		return err
+	}
+
+	if trip.Status != TripStatusScheduled {
+		return errors.New("trip is already "+trip.Status, 409)
+	}
+	if today := time.Now().Format("2006-01-02"); trip.TripDate != today {
+		return errors.New("trip is scheduled for "+trip.TripDate, 409)
+	}
+
+	inProgress, err := s.tripRepository.HasTripInProgress(tx, driverUUID, tripUUID)
+	if err != nil {
+		return err
+	}
+	if inProgress {
+		return errors.New("another trip is already in progress", 409)
+	}
+
+	initialStatus := "waiting_to_be_taken_to_school"
+	if trip.Direction.String == "to_home" {
+		initialStatus = "at_school"
+	}
+
+	entries, err := s.tripRepository.FetchTripManifestEntries(tx, tripUUID)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		shuttleUUID, err := s.tripRepository.FetchTodayShuttle(tx, entry.StudentUUID.String(), driverUUID)
+		if err == sql.ErrNoRows {
+			shuttle := entity.Shuttle{
+				ShuttleID:   time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
+				ShuttleUUID: uuid.New(),
+				StudentUUID: entry.StudentUUID,
+				DriverUUID:  trip.DriverUUID,
+				TripUUID:    uuid.NullUUID{UUID: trip.TripUUID, Valid: true},
+				Status:      initialStatus,
+				CreatedAt:   sql.NullTime{Time: time.Now(), Valid: true},
+			}
+			if err := s.tripRepository.SaveTripShuttle(tx, shuttle); err != nil {
+				return fmt.Errorf("failed to save shuttle: %w", err)
+			}
+			shuttleUUID = shuttle.ShuttleUUID.String()
+		} else if err != nil {
+			return err
+		}
+
+		if err := s.tripRepository.LinkManifestShuttle(tx, entry.ManifestID, shuttleUUID); err != nil {
+			return fmt.Errorf("failed to link shuttle to trip: %w", err)
+		}
+	}
+
+	if err := s.tripRepository.UpdateTripStatus(tx, tripUUID, TripStatusInProgress, time.Now()); err != nil {
+		return fmt.Errorf("failed to start trip: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *TripService) FinishTrip(tripUUID, driverUUID string) error {
+	tx, err := s.tripRepository.BeginTransaction()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	trip, err := s.lockDriverTrip(tx, tripUUID, driverUUID)
+	if err != nil {
+		return err
+	}
+
+	if trip.Status != TripStatusInProgress {
+		return errors.New("trip is "+trip.Status+", only a trip in progress can be finished", 409)
+	}
+
+	if err := s.tripRepository.UpdateTripStatus(tx, tripUUID, TripStatusCompleted, time.Now()); err != nil {
+		return fmt.Errorf("failed to finish trip: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Trips belonging to another driver are reported as missing
+func (s *TripService) lockDriverTrip(tx *sqlx.Tx, tripUUID, driverUUID string) (entity.Trip, error) {
+	if _, err := uuid.Parse(tripUUID); err != nil {
+		return entity.Trip{}, errors.New("invalid trip UUID", 400)
+	}
+
+	trip, err := s.tripRepository.FetchTripForUpdate(tx, tripUUID)
+	if err == sql.ErrNoRows || (err == nil && trip.DriverUUID.String() != driverUUID) {
+		return entity.Trip{}, errors.New("trip not found", 404)
+	}
+	if err != nil {
+		return entity.Trip{}, err
+	}
+
+	return trip, nil
+}
+
+func (s *TripService) GetTripCountByDate(date time.Time, status string) (int, error) {
+	return s.tripRepository.CountTripsByDate(date.Format("2006-01-02"), status)
+}
+
+// Generate today's trips at startup and then every day at TRIP_GENERATION_TIME
+func (s *TripService) RunDailyGeneration() {
+	at := viper.GetString("TRIP_GENERATION_TIME")
+	if at == "" {
+		at = defaultTripGenerationTime
+	}
+	runAt, err := time.Parse("15:04", at)
+	if err != nil {
+		logger.LogWarn("Invalid TRIP_GENERATION_TIME, using default", map[string]interface{}{
+			"value":   at,
+			"default": defaultTripGenerationTime,
+		})
+		runAt, _ = time.Parse("15:04", defaultTripGenerationTime)
+	}
+
+	for {
+		s.generateForToday()
+
+		now := time.Now()
+		next := time.Date(now.Year(), now.Month(), now.Day(), runAt.Hour(), runAt.Minute(), 0, 0, now.Location())
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+		time.Sleep(time.Until(next))
+	}
+}
+
+func (s *TripService) generateForToday() {
+	created, err := s.GenerateTrips(time.Now(), "", "system")
+	if err != nil {
+		logger.LogError(err, "Failed to generate daily trips", nil)
+		return
+	}
+
+	logger.LogInfo("Daily trips generated", map[string]interface{}{
+		"date":  time.Now().Format("2006-01-02"),
+		"trips": created,
+	})
+}
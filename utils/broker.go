@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"shuttle/databases"
+	"shuttle/logger"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/spf13/viper"
+)
+
+const (
+	BrokerTargetGroup      = "group"
+	BrokerTargetUser       = "user"
+	BrokerTargetDisconnect = "disconnect"
+
+	brokerChannel = "shuttle_ws_events"
+)
+
+// A frame fanned out to every app instance. Each instance delivers it to
+// the matching connections it holds locally.
+type BrokerMessage struct {
+	Target         string        `json:"target"`
+	Key            string        `json:"key"`
+	Payload        []byte        `json:"payload"`
+	UserUUID       string        `json:"user_uuid,omitempty"`
+	SessionUUID    string        `json:"session_uuid,omitempty"`
+	SenderClientID string        `json:"sender_client_id,omitempty"`
+	Position       *LastPosition `json:"position,omitempty"`
+}
+
+type Broker interface {
+	Publish(message BrokerMessage) error
+	Subscribe(handler func(message BrokerMessage))
+	Close() error
+}
+
+// Pick the broker from WS_BROKER: "memory" (default, single instance) or "postgres"
+func NewBroker(db *sqlx.DB) (Broker, error) {
+	switch viper.GetString("WS_BROKER") {
+	case "", "memory":
+		return NewMemoryBroker(), nil
+	case "postgres":
+		return NewPostgresBroker(db, databases.PostgresDSN())
+	default:
+		return nil, fmt.Errorf("unknown WS_BROKER %q", viper.GetString("WS_BROKER"))
+	}
+}
+
+type MemoryBroker struct {
+	mu       sync.RWMutex
+	handlers []func(message BrokerMessage)
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{}
+}
+
+func (b *MemoryBroker) Publish(message BrokerMessage) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, handler := range b.handlers {
+		handler(message)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(handler func(message BrokerMessage)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+}
+
+func (b *MemoryBroker) Close() error {
+	return nil
+}
+
+// Fan-out over Postgres LISTEN/NOTIFY. NOTIFY payloads are capped at 8000 bytes,
+// which is plenty for position and status frames.
+type PostgresBroker struct {
+	db       *sqlx.DB
+	listener *pq.Listener
+
+	mu       sync.RWMutex
+	handlers []func(message BrokerMessage)
+	done     chan struct{}
+}
+
+func NewPostgresBroker(db *sqlx.DB, dsn string) (*PostgresBroker, error) {
+	listener := pq.NewListener(dsn, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.LogError(err, "Postgres broker listener event", map[string]interface{}{
+				"event": event,
+			})
+		}
+	})
+
+	if err := listener.Listen(brokerChannel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	broker := &PostgresBroker{
+		db:       db,
+		listener: listener,
+		done:     make(chan struct{}),
+	}
+	go broker.run()
+
+	return broker, nil
+}
+
+func (b *PostgresBroker) run() {
+	for {
+		select {
+		case notification := <-b.listener.Notify:
+			// A nil notification means the listener reconnected; missed frames are not replayed
+			if notification == nil {
+				continue
+			}
+
+			var message BrokerMessage
+			if err := json.Unmarshal([]byte(notification.Extra), &message); err != nil {
+				logger.LogError(err, "Postgres broker received invalid message", nil)
+				continue
+			}
+
+			b.mu.RLock()
+			for _, handler := range b.handlers {
+				handler(message)
+			}
+			b.mu.RUnlock()
+		case <-time.After(90 * time.Second):
+			go b.listener.Ping()
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func (b *PostgresBroker) Publish(message BrokerMessage) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.db.Exec(`SELECT pg_notify($1, $2)`, brokerChannel, string(payload))
+	return err
+}
+
+func (b *PostgresBroker) Subscribe(handler func(message BrokerMessage)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+}
+
+func (b *PostgresBroker) Close() error {
+	close(b.done)
+	return b.listener.Close()
+}
@@ -57,18 +57,8 @@ func InitFirebase() {
 //     return token.AccessToken, nil
 // }
 
-// Send a notification to a device
+// Send the message that goes with a shuttle status to every device the user is signed in on
 func SendNotification(userUUID, title, status string) error {
-    deviceToken, err := getDeviceToken(userUUID)
-    if err != nil {
-        return err
-    }
-
-    client, err := FirebaseApp.Messaging(context.Background())
-    if err != nil {
-        return errors.New("fcm: failed to get Firebase Messaging client")
-    }
-
     var body string
     switch status {
     case "home":
@@ -87,28 +77,53 @@ func SendNotification(userUUID, title, status string) error {
         return errors.New("fcm: invalid status")
     }
 
-    message := &messaging.Message{
-        Notification: &messaging.Notification{
-            Title: title,
-            Body:  body,
-        },
-        Token: deviceToken,
+    return SendNotificationMessage(userUUID, title, body)
+}
+
+// Send a notification with free text to every device the user is signed in on
+func SendNotificationMessage(userUUID, title, body string) error {
+    deviceTokens, err := getDeviceTokens(userUUID)
+    if err != nil {
+        return err
     }
 
-    _, err = client.Send(context.Background(), message)
+    client, err := FirebaseApp.Messaging(context.Background())
     if err != nil {
+        return errors.New("fcm: failed to get Firebase Messaging client")
+    }
+
+    // One failing device shouldn't keep the message from the others
+    sent := 0
+    for _, deviceToken := range deviceTokens {
+        message := &messaging.Message{
+            Notification: &messaging.Notification{
+                Title: title,
+                Body:  body,
+            },
+            Token: deviceToken,
+        }
+
+        if _, err := client.Send(context.Background(), message); err == nil {
+            sent++
+        }
+    }
+
+    if sent == 0 {
         return errors.New("fcm: failed to send message")
     }
     return nil
 }
 
-// Get device token from the database
-func getDeviceToken(userUUID string) (string, error) {
-    var deviceToken string
-    query := "SELECT device_token FROM fcm_tokens WHERE user_uuid = $1"
-    err := db.QueryRow(query, userUUID).Scan(&deviceToken)
-    if err != nil {
-        return "", errors.New("fcm: failed to get device token")
+// Get the device tokens of the user's active sessions from the database
+func getDeviceTokens(userUUID string) ([]string, error) {
+    var deviceTokens []string
+    query := `
+        SELECT fcm_token FROM user_sessions
+        WHERE user_uuid = $1 AND fcm_token IS NOT NULL AND revoked_at IS NULL AND expires_at > NOW()
+    `
+    err := db.Select(&deviceTokens, query, userUUID)
+    if err != nil || len(deviceTokens) == 0 {
+        return nil, errors.New("fcm: failed to get device token")
     }
-    return deviceToken, nil
+    return deviceTokens, nil
 }
\ No newline at end of file
@@ -0,0 +1,44 @@
+package utils
+
+// Minimal GeoJSON (RFC 7946) types; coordinates are [longitude, latitude]
+type GeoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   *GeoJSONGeometry       `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+func NewGeoJSONFeatureCollection() GeoJSONFeatureCollection {
+	return GeoJSONFeatureCollection{Type: "FeatureCollection", Features: []GeoJSONFeature{}}
+}
+
+func NewGeoJSONPoint(latitude, longitude float64, properties map[string]interface{}) GeoJSONFeature {
+	return GeoJSONFeature{
+		Type:       "Feature",
+		Geometry:   &GeoJSONGeometry{Type: "Point", Coordinates: []float64{longitude, latitude}},
+		Properties: properties,
+	}
+}
+
+// Points are given as [latitude, longitude] pairs
+func NewGeoJSONLineString(points [][2]float64, properties map[string]interface{}) GeoJSONFeature {
+	coordinates := make([][]float64, 0, len(points))
+	for _, point := range points {
+		coordinates = append(coordinates, []float64{point[1], point[0]})
+	}
+
+	return GeoJSONFeature{
+		Type:       "Feature",
+		Geometry:   &GeoJSONGeometry{Type: "LineString", Coordinates: coordinates},
+		Properties: properties,
+	}
+}
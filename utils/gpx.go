@@ -0,0 +1,46 @@
+package utils
+
+import "encoding/xml"
+
+const GPXContentType = "application/gpx+xml"
+
+// Minimal GPX 1.1 document with waypoints and routes, enough for navigation apps to import
+type GPX struct {
+	XMLName   xml.Name      `xml:"gpx"`
+	Version   string        `xml:"version,attr"`
+	Creator   string        `xml:"creator,attr"`
+	Xmlns     string        `xml:"xmlns,attr"`
+	Waypoints []GPXWaypoint `xml:"wpt"`
+	Routes    []GPXRoute    `xml:"rte"`
+}
+
+type GPXWaypoint struct {
+	Latitude    float64 `xml:"lat,attr"`
+	Longitude   float64 `xml:"lon,attr"`
+	Name        string  `xml:"name,omitempty"`
+	Description string  `xml:"desc,omitempty"`
+	Type        string  `xml:"type,omitempty"`
+}
+
+type GPXRoute struct {
+	Name        string        `xml:"name,omitempty"`
+	Description string        `xml:"desc,omitempty"`
+	Points      []GPXWaypoint `xml:"rtept"`
+}
+
+func NewGPX() GPX {
+	return GPX{
+		Version: "1.1",
+		Creator: "shuttle",
+		Xmlns:   "http://www.topografix.com/GPX/1/1",
+	}
+}
+
+func (g GPX) Encode() ([]byte, error) {
+	body, err := xml.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), body...), nil
+}
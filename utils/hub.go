@@ -0,0 +1,460 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"shuttle/logger"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+)
+
+const (
+	clientSendBuffer = 64
+	clientWriteWait  = 10 * time.Second
+
+	defaultPingInterval  = 30 * time.Second
+	defaultPongTimeout   = 60 * time.Second
+	defaultPresenceSweep = time.Minute
+	defaultPositionTTL   = time.Hour
+)
+
+// A single WebSocket connection. All writes go through the send queue so
+// only the writer goroutine ever touches the socket.
+type Client struct {
+	ID          string
+	UserUUID    string
+	SessionUUID string
+	ShuttleUUID string
+	RoleCode    string
+
+	conn   *websocket.Conn
+	send   chan []byte
+	done   chan struct{}
+	mu     sync.Mutex
+	closed bool
+}
+
+func NewClient(conn *websocket.Conn, userUUID, sessionUUID, shuttleUUID, roleCode string) *Client {
+	return &Client{
+		ID:          uuid.New().String(),
+		UserUUID:    userUUID,
+		SessionUUID: sessionUUID,
+		ShuttleUUID: shuttleUUID,
+		RoleCode:    roleCode,
+		conn:        conn,
+		send:        make(chan []byte, clientSendBuffer),
+		done:        make(chan struct{}),
+	}
+}
+
+// Queue a message without blocking; false means the client is closed or too slow
+func (cl *Client) Send(message []byte) bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.closed {
+		return false
+	}
+
+	select {
+	case cl.send <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+// Drain the send queue to the socket until the client is closed, pinging
+// the peer every interval so dead sockets trip the read deadline
+func (cl *Client) WritePump(pingInterval time.Duration) {
+	ticker := time.NewTicker(pingInterval)
+	defer func() {
+		ticker.Stop()
+		close(cl.done)
+	}()
+
+	for {
+		select {
+		case message, ok := <-cl.send:
+			if !ok {
+				return
+			}
+
+			cl.conn.SetWriteDeadline(time.Now().Add(clientWriteWait))
+			if err := cl.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				logger.LogError(err, "WebSocket Write Error", map[string]interface{}{
+					"ClientID": cl.ID,
+					"UserUUID": cl.UserUUID,
+				})
+				cl.conn.Close()
+				return
+			}
+		case <-ticker.C:
+			cl.conn.SetWriteDeadline(time.Now().Add(clientWriteWait))
+			if err := cl.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				logger.LogWarn("WebSocket Ping Failed, dropping connection", map[string]interface{}{
+					"ClientID": cl.ID,
+					"UserUUID": cl.UserUUID,
+				})
+				cl.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// Stop accepting messages and let the writer goroutine finish
+func (cl *Client) Close() {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if !cl.closed {
+		cl.closed = true
+		close(cl.send)
+	}
+}
+
+// Block until the writer goroutine has exited
+func (cl *Client) Wait() {
+	<-cl.done
+}
+
+// Force the underlying socket closed, which ends the connection's read loop
+func (cl *Client) Disconnect() {
+	cl.conn.Close()
+}
+
+type Hub struct {
+	broker Broker
+
+	pingInterval  time.Duration
+	pongTimeout   time.Duration
+	presenceSweep time.Duration
+	positionTTL   time.Duration
+
+	mu     sync.RWMutex
+	users  map[string]map[*Client]struct{}
+	groups map[string]map[*Client]struct{}
+
+	positionMutex sync.RWMutex
+	lastPositions map[string]LastPosition
+}
+
+func NewHub(broker Broker) *Hub {
+	pingInterval := DurationFromConfig("WS_PING_INTERVAL", defaultPingInterval)
+	pongTimeout := DurationFromConfig("WS_PONG_TIMEOUT", defaultPongTimeout)
+	if pingInterval >= pongTimeout {
+		pingInterval = pongTimeout * 9 / 10
+	}
+
+	hub := &Hub{
+		broker:        broker,
+		pingInterval:  pingInterval,
+		pongTimeout:   pongTimeout,
+		presenceSweep: DurationFromConfig("WS_PRESENCE_SWEEP_INTERVAL", defaultPresenceSweep),
+		positionTTL:   DurationFromConfig("WS_POSITION_TTL", defaultPositionTTL),
+		users:         make(map[string]map[*Client]struct{}),
+		groups:        make(map[string]map[*Client]struct{}),
+		lastPositions: make(map[string]LastPosition),
+	}
+	broker.Subscribe(hub.dispatch)
+
+	return hub
+}
+
+func DurationFromConfig(key string, fallback time.Duration) time.Duration {
+	value := viper.GetString(key)
+	if value == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		logger.LogWarn("Invalid duration in config, using default", map[string]interface{}{
+			"key":     key,
+			"value":   value,
+			"default": fallback.String(),
+		})
+		return fallback
+	}
+
+	return duration
+}
+
+func IntFromConfig(key string, fallback int) int {
+	value := viper.GetString(key)
+	if value == "" {
+		return fallback
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number <= 0 {
+		logger.LogWarn("Invalid number in config, using default", map[string]interface{}{
+			"key":     key,
+			"value":   value,
+			"default": fallback,
+		})
+		return fallback
+	}
+
+	return number
+}
+
+// Comma separated values, empty entries are skipped
+func ListFromConfig(key string) []string {
+	var values []string
+	for _, value := range strings.Split(viper.GetString(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func (h *Hub) PingInterval() time.Duration {
+	return h.pingInterval
+}
+
+func (h *Hub) PongTimeout() time.Duration {
+	return h.pongTimeout
+}
+
+func (h *Hub) PresenceSweepInterval() time.Duration {
+	return h.presenceSweep
+}
+
+func (h *Hub) Register(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, exists := h.users[client.UserUUID]; !exists {
+		h.users[client.UserUUID] = make(map[*Client]struct{})
+	}
+	h.users[client.UserUUID][client] = struct{}{}
+
+	if client.ShuttleUUID != "" {
+		if _, exists := h.groups[client.ShuttleUUID]; !exists {
+			h.groups[client.ShuttleUUID] = make(map[*Client]struct{})
+		}
+		h.groups[client.ShuttleUUID][client] = struct{}{}
+	}
+}
+
+// Remove a client and return how many connections its user still has open
+func (h *Hub) Unregister(client *Client) int {
+	h.mu.Lock()
+	if clients, exists := h.users[client.UserUUID]; exists {
+		delete(clients, client)
+		if len(clients) == 0 {
+			delete(h.users, client.UserUUID)
+		}
+	}
+
+	if clients, exists := h.groups[client.ShuttleUUID]; exists {
+		delete(clients, client)
+		if len(clients) == 0 {
+			delete(h.groups, client.ShuttleUUID)
+		}
+	}
+	remaining := len(h.users[client.UserUUID])
+	h.mu.Unlock()
+
+	client.Close()
+	return remaining
+}
+
+func (h *Hub) UserConnectionCount(userUUID string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.users[userUUID])
+}
+
+func (h *Hub) userClients(userUUID string) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	clients := make([]*Client, 0, len(h.users[userUUID]))
+	for client := range h.users[userUUID] {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+func (h *Hub) groupClients(shuttleUUID string) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	clients := make([]*Client, 0, len(h.groups[shuttleUUID]))
+	for client := range h.groups[shuttleUUID] {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// Queue a message to a client, dropping the connection if its queue is full
+func (h *Hub) deliver(client *Client, message []byte) {
+	if !client.Send(message) {
+		logger.LogWarn("WebSocket client too slow, dropping connection", map[string]interface{}{
+			"ClientID": client.ID,
+			"UserUUID": client.UserUUID,
+		})
+		client.Disconnect()
+	}
+}
+
+// Deliver a broker message to the connections held by this instance
+func (h *Hub) dispatch(message BrokerMessage) {
+	switch message.Target {
+	case BrokerTargetGroup:
+		if message.Position != nil {
+			h.SetLastPosition(message.Key, *message.Position)
+		}
+		delivered := make(map[*Client]struct{})
+		for _, client := range h.groupClients(message.Key) {
+			delivered[client] = struct{}{}
+			if client.ID == message.SenderClientID {
+				continue
+			}
+			h.deliver(client, message.Payload)
+		}
+		// Also reach the user's connections outside the group, once each
+		if message.UserUUID != "" {
+			for _, client := range h.userClients(message.UserUUID) {
+				if _, exists := delivered[client]; !exists {
+					h.deliver(client, message.Payload)
+				}
+			}
+		}
+	case BrokerTargetUser:
+		for _, client := range h.userClients(message.Key) {
+			h.deliver(client, message.Payload)
+		}
+	case BrokerTargetDisconnect:
+		closed := 0
+		for _, client := range h.userClients(message.Key) {
+			if message.SessionUUID != "" && client.SessionUUID != message.SessionUUID {
+				continue
+			}
+			client.Disconnect()
+			closed++
+		}
+		if closed > 0 {
+			logger.LogInfo("WebSocket connection closed", map[string]interface{}{
+				"user_uuid":    message.Key,
+				"session_uuid": message.SessionUUID,
+				"connections":  closed,
+			})
+		}
+	}
+}
+
+// Publish through the broker, falling back to local delivery so a broker
+// outage degrades to single-instance behaviour instead of silence
+func (h *Hub) publish(message BrokerMessage) {
+	if err := h.broker.Publish(message); err != nil {
+		logger.LogError(err, "WebSocket Broker Publish Error", map[string]interface{}{
+			"target": message.Target,
+			"key":    message.Key,
+		})
+		h.dispatch(message)
+	}
+}
+
+// Relay a message to every connection in the group except the sending one
+func (h *Hub) BroadcastToGroup(shuttleUUID string, message []byte, sender *Client) {
+	brokerMessage := BrokerMessage{
+		Target:  BrokerTargetGroup,
+		Key:     shuttleUUID,
+		Payload: message,
+	}
+	if sender != nil {
+		brokerMessage.SenderClientID = sender.ID
+	}
+	h.publish(brokerMessage)
+}
+
+// Relay a position frame and update the last known position on every instance
+func (h *Hub) PublishPosition(shuttleUUID string, message []byte, sender *Client, position LastPosition) {
+	h.SetLastPosition(shuttleUUID, position)
+
+	brokerMessage := BrokerMessage{
+		Target:   BrokerTargetGroup,
+		Key:      shuttleUUID,
+		Payload:  message,
+		Position: &position,
+	}
+	if sender != nil {
+		brokerMessage.SenderClientID = sender.ID
+	}
+	h.publish(brokerMessage)
+}
+
+// Relay a message to the group and to every other open connection of one user,
+// without delivering twice to a connection that is in both
+func (h *Hub) BroadcastToGroupAndUser(shuttleUUID, userUUID string, message []byte) {
+	h.publish(BrokerMessage{
+		Target:   BrokerTargetGroup,
+		Key:      shuttleUUID,
+		UserUUID: userUUID,
+		Payload:  message,
+	})
+}
+
+// Send a message to every open connection of a user
+func (h *Hub) SendToUser(userUUID string, message []byte) {
+	h.publish(BrokerMessage{
+		Target:  BrokerTargetUser,
+		Key:     userUUID,
+		Payload: message,
+	})
+}
+
+// Close every open connection of a user on all instances, e.g. on logout
+func (h *Hub) DisconnectUser(userUUID string) {
+	h.publish(BrokerMessage{
+		Target: BrokerTargetDisconnect,
+		Key:    userUUID,
+	})
+}
+
+// Close the connections opened with one session, e.g. when it is signed out remotely
+func (h *Hub) DisconnectSession(userUUID, sessionUUID string) {
+	h.publish(BrokerMessage{
+		Target:      BrokerTargetDisconnect,
+		Key:         userUUID,
+		SessionUUID: sessionUUID,
+	})
+}
+
+func (h *Hub) SetLastPosition(shuttleUUID string, position LastPosition) {
+	h.positionMutex.Lock()
+	defer h.positionMutex.Unlock()
+	h.lastPositions[shuttleUUID] = position
+}
+
+func (h *Hub) GetLastPosition(shuttleUUID string) (LastPosition, bool) {
+	h.positionMutex.RLock()
+	defer h.positionMutex.RUnlock()
+	position, exists := h.lastPositions[shuttleUUID]
+	return position, exists
+}
+
+// Shuttles are created per student and day, so positions older than the TTL
+// are dropped; a later lookup falls back to the location history
+func (h *Hub) PruneLastPositions() {
+	staleBefore := time.Now().Add(-h.positionTTL)
+
+	h.positionMutex.Lock()
+	defer h.positionMutex.Unlock()
+
+	for shuttleUUID, position := range h.lastPositions {
+		if position.RecordedAt.Before(staleBefore) {
+			delete(h.lastPositions, shuttleUUID)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"shuttle/logger"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+)
+
+const (
+	MailDriverSMTP = "smtp"
+	MailDriverFile = "file"
+	MailDriverLog  = "log"
+
+	defaultMailFileDir = "./storage/mail"
+)
+
+type MailMessage struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Delivery of plain text mail; MAIL_DRIVER picks SMTP for real delivery or
+// the log and file drivers for working without a mail server
+type Mailer interface {
+	Send(message MailMessage) error
+}
+
+func NewMailer() Mailer {
+	from := viper.GetString("MAIL_FROM")
+
+	switch driver := strings.ToLower(viper.GetString("MAIL_DRIVER")); driver {
+	case MailDriverSMTP:
+		return &smtpMailer{
+			host:     viper.GetString("MAIL_SMTP_HOST"),
+			port:     viper.GetString("MAIL_SMTP_PORT"),
+			username: viper.GetString("MAIL_SMTP_USERNAME"),
+			password: viper.GetString("MAIL_SMTP_PASSWORD"),
+			from:     from,
+		}
+	case MailDriverFile:
+		dir := viper.GetString("MAIL_FILE_DIR")
+		if dir == "" {
+			dir = defaultMailFileDir
+		}
+		return &fileMailer{dir: dir, from: from}
+	case MailDriverLog:
+		return &logMailer{from: from, logBody: viper.GetBool("MAIL_LOG_BODY")}
+	case "":
+		logger.LogWarn("MAIL_DRIVER is not set, mail is not delivered and only its recipient is logged", nil)
+		return &logMailer{from: from}
+	default:
+		logger.LogWarn("Unknown MAIL_DRIVER, mail is not delivered and only its recipient is logged", map[string]interface{}{
+			"driver": driver,
+		})
+		return &logMailer{from: from}
+	}
+}
+
+func buildMail(from string, message MailMessage) []byte {
+	headers := []string{
+		"From: " + from,
+		"To: " + message.To,
+		"Subject: " + message.Subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+	}
+	return []byte(strings.Join(headers, "\r\n") + "\r\n\r\n" + strings.ReplaceAll(message.Body, "\n", "\r\n"))
+}
+
+type smtpMailer struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+func (m *smtpMailer) Send(message MailMessage) error {
+	if m.host == "" || m.port == "" {
+		return errors.New("mail: MAIL_SMTP_HOST and MAIL_SMTP_PORT are required for the smtp driver")
+	}
+
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+
+	if err := smtp.SendMail(m.host+":"+m.port, auth, m.from, []string{message.To}, buildMail(m.from, message)); err != nil {
+		return fmt.Errorf("mail: failed to send through smtp: %w", err)
+	}
+	return nil
+}
+
+// Writes every message to its own .eml file, handy for local testing
+type fileMailer struct {
+	dir  string
+	from string
+}
+
+func (m *fileMailer) Send(message MailMessage) error {
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return fmt.Errorf("mail: failed to create mail directory: %w", err)
+	}
+
+	name := fmt.Sprintf("%s_%s.eml", time.Now().Format("20060102T150405"), uuid.New().String())
+	if err := os.WriteFile(filepath.Join(m.dir, name), buildMail(m.from, message), 0o600); err != nil {
+		return fmt.Errorf("mail: failed to write mail file: %w", err)
+	}
+	return nil
+}
+
+// Logs the message instead of sending it. Bodies can carry secrets such as
+// reset tokens, so they are only logged when MAIL_LOG_BODY is enabled.
+type logMailer struct {
+	from    string
+	logBody bool
+}
+
+func (m *logMailer) Send(message MailMessage) error {
+	fields := map[string]interface{}{
+		"from":    m.from,
+		"to":      message.To,
+		"subject": message.Subject,
+	}
+	if m.logBody {
+		fields["body"] = message.Body
+	}
+
+	logger.LogInfo("Mail not sent, MAIL_DRIVER is log", fields)
+	return nil
+}
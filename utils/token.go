@@ -10,9 +10,6 @@ import (
 	"time"
 
 	"shuttle/databases"
-	"shuttle/logger"
-	"shuttle/models/entity"
-	"shuttle/repositories"
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
@@ -20,6 +17,24 @@ import (
 	"github.com/spf13/viper"
 )
 
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+
+	AccessTokenTTL = 6 * time.Hour
+
+	defaultRefreshTokenTTL      = 7 * 24 * time.Hour
+	defaultRefreshTokenLifetime = 15 * 24 * time.Hour
+)
+
+// What gets stored about a refresh token, the token itself never is
+type RefreshTokenMeta struct {
+	JTI               uuid.UUID
+	FamilyID          uuid.UUID
+	ExpiresAt         time.Time
+	AbsoluteExpiresAt time.Time
+}
+
 var jwtSecret []byte
 var encryptionKey []byte
 var db *sqlx.DB
@@ -40,14 +55,18 @@ func init() {
 	}
 }
 
-// Signed Access Token
-func GenerateToken(userID, userUUID, username, role_code string) (string, error) {
+// Signed Access Token, the jti lets it be revoked before it expires and the
+// sid ties it to the session (device) it was issued for
+func GenerateToken(userID, userUUID, username, role_code, sessionUUID string) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"jti":       uuid.New().String(),
+		"sid":       sessionUUID,
+		"typ":       TokenTypeAccess,
 		"sub":       userID,
 		"user_uuid": userUUID,
 		"user_name": username,
 		"role_code": role_code,
-		"exp":       time.Now().Add(time.Hour * 6).Unix(), // 2 hours expiration
+		"exp":       time.Now().Add(AccessTokenTTL).Unix(),
 	})
 
 	signedToken, err := token.SignedString(jwtSecret)
@@ -63,82 +82,58 @@ func GenerateToken(userID, userUUID, username, role_code string) (string, error)
 	return encryptedToken, nil
 }
 
-// Same, but with 15 days expiration time and for reissuing access token
-func GenerateRefreshToken(userID, userUUID, username, role_code string) (string, error) {
-
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub":       userID,
-		"user_uuid": userUUID,
-		"user_name": username,
-		"role_code": role_code,
-		"exp":       time.Now().Add(time.Hour * 24 * 15).Unix(), // 15 days expiration
-	})
-
-	signedRefreshToken, err := refreshToken.SignedString(jwtSecret)
-	if err != nil {
-		return "", err
-	}
+// Refresh token that starts the family of a new session. Rotations stay in the
+// family and keep its absolute_exp, so a session can't be extended past it.
+func GenerateRefreshToken(userID, userUUID, username, role_code string, sessionUUID uuid.UUID) (string, RefreshTokenMeta, error) {
+	lifetime := DurationFromConfig("REFRESH_TOKEN_ABSOLUTE_LIFETIME", defaultRefreshTokenLifetime)
+	absoluteExp := time.Unix(time.Now().Add(lifetime).Unix(), 0)
 
-	encryptedRefreshToken, err := encryptToken(signedRefreshToken)
-	if err != nil {
-		return "", err
-	}
-
-	return encryptedRefreshToken, nil
+	return signRefreshToken(userID, userUUID, username, role_code, sessionUUID, absoluteExp)
 }
 
-// For reissuing refresh token
-func RegenerateRefreshToken(oldEncryptedToken string) (string, error) {
-	decryptedToken, err := decryptToken(oldEncryptedToken)
-	if err != nil {
-		return "", err
+// Next refresh token of a family
+func RotateRefreshToken(userID, userUUID, username, role_code string, familyID uuid.UUID, absoluteExp time.Time) (string, RefreshTokenMeta, error) {
+	if !absoluteExp.After(time.Now()) {
+		return "", RefreshTokenMeta{}, errors.New("refresh token expired")
 	}
 
-	token, err := jwt.Parse(decryptedToken, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
-	if err != nil {
-		return "", errors.New("invalid refresh token")
-	}
+	return signRefreshToken(userID, userUUID, username, role_code, familyID, absoluteExp)
+}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		return "", errors.New("invalid claims")
+func signRefreshToken(userID, userUUID, username, role_code string, familyID uuid.UUID, absoluteExp time.Time) (string, RefreshTokenMeta, error) {
+	meta := RefreshTokenMeta{
+		JTI:               uuid.New(),
+		FamilyID:          familyID,
+		ExpiresAt:         time.Unix(time.Now().Add(DurationFromConfig("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL)).Unix(), 0),
+		AbsoluteExpiresAt: absoluteExp,
 	}
-
-	// Validate absolute expiration
-	absoluteExp := int64(claims["absolute_exp"].(float64))
-	now := time.Now().Unix()
-	if absoluteExp <= now {
-		return "", errors.New("refresh token expired")
+	if meta.ExpiresAt.After(absoluteExp) {
+		meta.ExpiresAt = absoluteExp
 	}
 
-	// Generate new refresh token with the same absolute_exp
-	userID := claims["sub"].(string)
-	userUUID := claims["user_uuid"].(string)
-	username := claims["user_name"].(string)
-	roleCode := claims["role_code"].(string)
-
-	newRefreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"jti":          meta.JTI.String(),
+		"fid":          familyID.String(),
+		"typ":          TokenTypeRefresh,
 		"sub":          userID,
 		"user_uuid":    userUUID,
 		"user_name":    username,
-		"role_code":    roleCode,
-		"exp":          time.Now().Add(time.Hour * 24).Unix(), // Temporary validity 1 day
-		"absolute_exp": absoluteExp,                           // Keep original absolute expiry
+		"role_code":    role_code,
+		"exp":          meta.ExpiresAt.Unix(),
+		"absolute_exp": absoluteExp.Unix(),
 	})
 
-	signedToken, err := newRefreshToken.SignedString(jwtSecret)
+	signedRefreshToken, err := refreshToken.SignedString(jwtSecret)
 	if err != nil {
-		return "", err
+		return "", RefreshTokenMeta{}, err
 	}
 
-	newEncryptedToken, err := encryptToken(signedToken)
+	encryptedRefreshToken, err := encryptToken(signedRefreshToken)
 	if err != nil {
-		return "", err
+		return "", RefreshTokenMeta{}, err
 	}
 
-	return newEncryptedToken, nil
+	return encryptedRefreshToken, meta, nil
 }
 
 // AES encryption for tokens
@@ -207,38 +202,3 @@ func ValidateToken(encryptedToken string) (jwt.MapClaims, error) {
 	}
 	return nil, err
 }
-
-func SaveRefreshToken(userUUID string, refreshToken string) error {
-	ID := time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6)
-	expiration := time.Now().Add(time.Hour * 24 * 15)
-
-	parsedUUID, parseErr := uuid.Parse(userUUID)
-	if parseErr != nil {
-		return parseErr
-	}
-
-	err := repositories.SaveRefreshToken(*db, entity.RefreshToken{
-		ID:           ID,
-		UserUUID:     parsedUUID,
-		RefreshToken: refreshToken,
-		ExpiredAt:    expiration,
-	})
-	if err != nil {
-		logger.LogError(err, "Failed to save refresh token", map[string]interface{}{
-			"user_id": userUUID,
-		})
-		return err
-	}
-
-	return nil
-}
-
-var InvalidTokens = make(map[string]struct{})
-
-func InvalidateToken(token string) {
-	const bearerPrefix = "Bearer "
-	if len(token) > len(bearerPrefix) && token[:len(bearerPrefix)] == bearerPrefix {
-		token = token[len(bearerPrefix):]
-	}
-	InvalidTokens[token] = struct{}{}
-}
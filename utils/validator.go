@@ -3,6 +3,7 @@ package utils
 import (
 	"fmt"
 	"regexp"
+	"shuttle/models/geo"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
@@ -32,12 +33,18 @@ func CustomGenderValidator(fl validator.FieldLevel) bool {
 	return regexp.MustCompile(genderRegex).MatchString(value)
 }
 
+func CustomGeoPointValidator(fl validator.FieldLevel) bool {
+	point, ok := fl.Field().Interface().(geo.GeoPoint)
+	return ok && point.Validate() == nil
+}
+
 func ValidateStruct(c *fiber.Ctx, v interface{}) error {
 	validate := validator.New()
 	validate.RegisterValidation("phone", CustomPhoneValidator)
 	validate.RegisterValidation("username", CustomUsernameValidator)
 	validate.RegisterValidation("role", CustomRoleValidator)
 	validate.RegisterValidation("gender", CustomGenderValidator)
+	validate.RegisterValidation("geopoint", CustomGeoPointValidator)
 
 	if err := validate.Struct(v); err != nil {
 		for _, err := range err.(validator.ValidationErrors) {
@@ -56,6 +63,12 @@ func ValidateStruct(c *fiber.Ctx, v interface{}) error {
 				return fmt.Errorf("the %s field must be at least %s characters", err.Field(), err.Param())
 			case "max":
 				return fmt.Errorf("the %s field must be at most %s characters", err.Field(), err.Param())
+			case "geopoint":
+				return fmt.Errorf("the %s field must have a latitude between -90 and 90 and a longitude between -180 and 180", err.Field())
+			case "latitude":
+				return fmt.Errorf("the %s field must be between -90 and 90", err.Field())
+			case "longitude":
+				return fmt.Errorf("the %s field must be between -180 and 180", err.Field())
 			case "role":
 				return fmt.Errorf("the %s field must be either superadmin, schooladmin, driver, or parent", err.Field())
 			}
@@ -1,117 +1,276 @@
 package utils
 
 import (
+	"database/sql"
 	"encoding/json"
-	"sync"
 	"time"
 
 	"shuttle/logger"
+	"shuttle/models/dto"
+	"shuttle/models/entity"
 	"shuttle/repositories"
 
 	"github.com/gofiber/contrib/websocket"
 	"github.com/google/uuid"
 )
 
+// Notified of every accepted driver position, e.g. by the geofence engine
+type PositionObserver interface {
+	ObservePosition(shuttleUUID, driverUUID uuid.UUID, latitude, longitude float64, recordedAt time.Time)
+}
+
 type WebSocketServiceInterface interface {
 	HandleWebSocketConnection(c *websocket.Conn)
+	RunPresenceSweeper()
 }
 
 type WebSocketService struct {
-	userRepository repositories.UserRepositoryInterface
-	authRepository repositories.AuthRepositoryInterface
-	shuttleRepository repositories.ShuttleRepositoryInterface
+	userRepository     repositories.UserRepositoryInterface
+	authRepository     repositories.AuthRepositoryInterface
+	shuttleRepository  repositories.ShuttleRepositoryInterface
+	locationRepository repositories.LocationRepositoryInterface
+	hub                *Hub
+	observers          []PositionObserver
 }
 
-func NewWebSocketService(userRepository repositories.UserRepositoryInterface, authRepository repositories.AuthRepositoryInterface, shuttleRepository repositories.ShuttleRepositoryInterface) WebSocketServiceInterface {
+func NewWebSocketService(userRepository repositories.UserRepositoryInterface, authRepository repositories.AuthRepositoryInterface, shuttleRepository repositories.ShuttleRepositoryInterface, locationRepository repositories.LocationRepositoryInterface, hub *Hub, observers ...PositionObserver) WebSocketServiceInterface {
 	return &WebSocketService{
-		userRepository: userRepository,
-		authRepository: authRepository,
-		shuttleRepository: shuttleRepository,
+		userRepository:     userRepository,
+		authRepository:     authRepository,
+		shuttleRepository:  shuttleRepository,
+		locationRepository: locationRepository,
+		hub:                hub,
+		observers:          observers,
 	}
 }
 
-type WebSocketResponse struct {
+const (
+	MessageTypePosition = "position"
+	MessageTypeStatus   = "status"
+	MessageTypeGeofence = "geofence"
+	MessageTypeETA      = "eta"
+	MessageTypeHandover = "handover"
+	MessageTypePing     = "ping"
+	MessageTypeAck      = "ack"
+	MessageTypeError    = "error"
+)
+
+// Envelope for every frame exchanged over the shuttle WebSocket
+type WebSocketMessage struct {
+	Type        string          `json:"type"`
+	ShuttleUUID string          `json:"shuttle_uuid,omitempty"`
+	SenderUUID  string          `json:"sender_uuid,omitempty"`
+	Data        json.RawMessage `json:"data,omitempty"`
+	Timestamp   string          `json:"timestamp"`
+}
+
+type WebSocketError struct {
 	Code    int    `json:"code"`
 	Status  string `json:"status"`
 	Message string `json:"message"`
 }
 
-var (
-	activeConnections = make(map[string]*websocket.Conn)
-	mutex             = &sync.Mutex{}
+type WebSocketAck struct {
+	Message string `json:"message"`
+}
+
+type PositionPayload struct {
+	Longitude  float64  `json:"longitude"`
+	Latitude   float64  `json:"latitude"`
+	Speed      *float64 `json:"speed,omitempty"`
+	Heading    *float64 `json:"heading,omitempty"`
+	RecordedAt string   `json:"recorded_at,omitempty"`
+	AgeSeconds *int64   `json:"age_seconds,omitempty"`
+	Snapshot   bool     `json:"snapshot,omitempty"`
+}
 
-	connGroups = make(map[string]map[string]*websocket.Conn)
-	groupMutex = &sync.Mutex{}
-)
+type StatusPayload struct {
+	StudentUUID string `json:"student_uuid"`
+	OldStatus   string `json:"old_status"`
+	NewStatus   string `json:"new_status"`
+	ChangedAt   string `json:"changed_at"`
+}
 
-func AddConnection(ID string, conn *websocket.Conn) {
-	mutex.Lock()
-	defer mutex.Unlock()
-	activeConnections[ID] = conn
+type GeofencePayload struct {
+	Event           string  `json:"event"`
+	StudentUUID     string  `json:"student_uuid"`
+	DistanceMeters  float64 `json:"distance_meters"`
+	SuggestedStatus string  `json:"suggested_status,omitempty"`
+	Applied         bool    `json:"applied"`
 }
 
-func RemoveConnection(ID string) {
-	mutex.Lock()
-	defer mutex.Unlock()
-	delete(activeConnections, ID)
+// Last known position of a shuttle, kept after the driver disconnects
+type LastPosition struct {
+	Longitude  float64   `json:"longitude"`
+	Latitude   float64   `json:"latitude"`
+	Speed      *float64  `json:"speed,omitempty"`
+	Heading    *float64  `json:"heading,omitempty"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+func NewWebSocketMessage(messageType, shuttleUUID, senderUUID string, data interface{}) ([]byte, error) {
+	var raw json.RawMessage
+	if data != nil {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		raw = encoded
+	}
+
+	return json.Marshal(WebSocketMessage{
+		Type:        messageType,
+		ShuttleUUID: shuttleUUID,
+		SenderUUID:  senderUUID,
+		Data:        raw,
+		Timestamp:   time.Now().Format(time.RFC3339),
+	})
 }
 
-func GetConnection(ID string) (*websocket.Conn, bool) {
-	mutex.Lock()
-	defer mutex.Unlock()
-	conn, exists := activeConnections[ID]
-	return conn, exists
+// Push a status transition to the shuttle group and to the parent's own connections
+func PublishStatusChange(hub *Hub, change dto.ShuttleStatusChange, senderUUID string) error {
+	message, err := NewWebSocketMessage(MessageTypeStatus, change.ShuttleUUID, senderUUID, StatusPayload{
+		StudentUUID: change.StudentUUID,
+		OldStatus:   change.OldStatus,
+		NewStatus:   change.NewStatus,
+		ChangedAt:   change.ChangedAt.Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+
+	hub.BroadcastToGroupAndUser(change.ShuttleUUID, change.ParentUUID, message)
+	return nil
 }
 
-func AddToGroup(shuttleUUID, userUUID string, conn *websocket.Conn) {
-	groupMutex.Lock()
-	defer groupMutex.Unlock()
+func sendWebSocketError(client *Client, code int, status, message string) {
+	msg, err := NewWebSocketMessage(MessageTypeError, "", "", WebSocketError{
+		Code:    code,
+		Status:  status,
+		Message: message,
+	})
+	if err != nil {
+		logger.LogError(err, "WebSocket Error Encoding Error Frame", nil)
+		return
+	}
+	client.Send(msg)
+}
 
-	if _, exists := connGroups[shuttleUUID]; !exists {
-		connGroups[shuttleUUID] = make(map[string]*websocket.Conn)
+func sendWebSocketAck(client *Client, shuttleUUID, message string) {
+	msg, err := NewWebSocketMessage(MessageTypeAck, shuttleUUID, "", WebSocketAck{Message: message})
+	if err != nil {
+		logger.LogError(err, "WebSocket Error Encoding Ack Frame", nil)
+		return
 	}
-	connGroups[shuttleUUID][userUUID] = conn
+	client.Send(msg)
 }
 
-func RemoveFromGroup(shuttleUUID, userUUID string) {
-	groupMutex.Lock()
-	defer groupMutex.Unlock()
+// Fall back to the location history when the cache is cold (e.g. after a restart)
+func (s *WebSocketService) lastKnownPosition(shuttleUUID uuid.UUID) (LastPosition, bool) {
+	if position, exists := s.hub.GetLastPosition(shuttleUUID.String()); exists {
+		return position, true
+	}
 
-	if group, exists := connGroups[shuttleUUID]; exists {
-		delete(group, userUUID)
-		if len(group) == 0 {
-			delete(connGroups, shuttleUUID)
+	location, err := s.locationRepository.FetchLatestLocation(shuttleUUID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logger.LogError(err, "WebSocket Error Fetching Last Location", map[string]interface{}{
+				"ShuttleUUID": shuttleUUID.String(),
+			})
 		}
+		return LastPosition{}, false
+	}
+
+	position := LastPosition{
+		Longitude:  location.Longitude,
+		Latitude:   location.Latitude,
+		RecordedAt: location.RecordedAt,
 	}
+	if location.Speed.Valid {
+		position.Speed = &location.Speed.Float64
+	}
+	if location.Heading.Valid {
+		position.Heading = &location.Heading.Float64
+	}
+	s.hub.SetLastPosition(shuttleUUID.String(), position)
+
+	return position, true
 }
 
-func BroadcastToGroup(shuttleUUID string, message []byte) {
-	groupMutex.Lock()
-	defer groupMutex.Unlock()
+func sendPositionSnapshot(client *Client, shuttleUUID string, position LastPosition) {
+	age := int64(time.Since(position.RecordedAt).Seconds())
+	msg, err := NewWebSocketMessage(MessageTypePosition, shuttleUUID, "", PositionPayload{
+		Longitude:  position.Longitude,
+		Latitude:   position.Latitude,
+		Speed:      position.Speed,
+		Heading:    position.Heading,
+		RecordedAt: position.RecordedAt.Format(time.RFC3339),
+		AgeSeconds: &age,
+		Snapshot:   true,
+	})
+	if err != nil {
+		logger.LogError(err, "WebSocket Error Encoding Snapshot", nil)
+		return
+	}
+	client.Send(msg)
+}
 
-	if group, exists := connGroups[shuttleUUID]; exists {
-		for _, conn := range group {
-			if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
-				logger.LogError(err, "WebSocket Broadcast Error", nil)
-			}
+// Keep user_last_active fresh while the socket is alive so the sweeper leaves the user alone
+func (s *WebSocketService) touchPresence(userUUID uuid.UUID) {
+	if err := s.userRepository.UpdateUserStatus(userUUID, "online", time.Now()); err != nil {
+		logger.LogError(err, "WebSocket Error Updating User Status", map[string]interface{}{
+			"UserUUID": userUUID.String(),
+		})
+	}
+}
+
+// Periodically mark users offline whose heartbeat stopped without a clean close,
+// e.g. after a crash or restart of the server holding their socket. Cached
+// shuttle positions that went stale are dropped on the same tick.
+func (s *WebSocketService) RunPresenceSweeper() {
+	ticker := time.NewTicker(s.hub.PresenceSweepInterval())
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.hub.PruneLastPositions()
+
+		staleBefore := time.Now().Add(-2 * s.hub.PongTimeout())
+		swept, err := s.userRepository.MarkStaleUsersOffline(staleBefore)
+		if err != nil {
+			logger.LogError(err, "Failed to sweep stale user presence", nil)
+			continue
 		}
+
+		if swept > 0 {
+			logger.LogInfo("Marked stale users offline", map[string]interface{}{
+				"count": swept,
+			})
+		}
+	}
+}
+
+// Reply with an error frame on a connection that has not been handed to the hub yet
+func rejectWebSocket(c *websocket.Conn, code int, status, message string) {
+	msg, err := NewWebSocketMessage(MessageTypeError, "", "", WebSocketError{
+		Code:    code,
+		Status:  status,
+		Message: message,
+	})
+	if err == nil {
+		c.WriteMessage(websocket.TextMessage, msg)
 	}
+	c.Close()
 }
 
 // Handle WebSocket connection
 func (s *WebSocketService) HandleWebSocketConnection(c *websocket.Conn) {
 	userUUID, ok := c.Locals("userUUID").(string)
 	if !ok || userUUID == "" {
-		response := WebSocketResponse{
-			Code:    401,
-			Status:  "Unauthorized",
-			Message: "Unauthorized access",
-		}
-		responseMsg, _ := json.Marshal(response)
-		c.WriteMessage(websocket.TextMessage, responseMsg)
-		c.Close()
+		rejectWebSocket(c, 401, "Unauthorized", "Unauthorized access")
 		return
 	}
+	roleCode, _ := c.Locals("role_code").(string)
+	sessionUUID, _ := c.Locals("sessionUUID").(string)
 	shuttleUUID := c.Params("id")
 
 	userUUIDParsed, err := uuid.Parse(userUUID)
@@ -121,33 +280,15 @@ func (s *WebSocketService) HandleWebSocketConnection(c *websocket.Conn) {
 		return
 	}
 
-	err = s.userRepository.UpdateUserStatus(userUUIDParsed, "online", time.Time{})
-	if err != nil {
-		logger.LogError(err, "WebSocket Error Updating User Status", nil)
-	}
-
-	defer func() {
-		if shuttleUUID != "" {
-			RemoveFromGroup(shuttleUUID, userUUID)
-		} else {
-			RemoveConnection(userUUID)
-		}
-
-		if err := s.userRepository.UpdateUserStatus(userUUIDParsed, "offline", time.Now()); err != nil {
-			logger.LogError(err, "WebSocket Error Updating User Status", nil)
-		}
-
-		logger.LogInfo("WebSocket connection closed", map[string]interface{}{
-			"UserUUID":    userUUID,
-			"ShuttleUUID": shuttleUUID,
-		})
-	}()
+	// Only the driver assigned to the shuttle may publish positions, everyone else is read-only
+	canPublish := false
+	var shuttleUUIDParsed uuid.UUID
 
 	if shuttleUUID != "" {
-		shuttleUUIDParsed, err := uuid.Parse(shuttleUUID)
+		shuttleUUIDParsed, err = uuid.Parse(shuttleUUID)
 		if err != nil {
 			logger.LogError(err, "Invalid UUID format", nil)
-			c.Close()
+			rejectWebSocket(c, 400, "Bad Request", "Invalid shuttle UUID format")
 			return
 		}
 
@@ -158,32 +299,74 @@ func (s *WebSocketService) HandleWebSocketConnection(c *websocket.Conn) {
 		}
 
 		if !exist {
-			response := WebSocketResponse{
-				Code:    404,
-				Status:  "Not Found",
-				Message: "User not found in shuttle",	
-			}
-			responseMsg, _ := json.Marshal(response)
-			c.WriteMessage(websocket.TextMessage, responseMsg)
-			
 			logger.LogError(err, "User not found in shuttle", map[string]interface{}{
 				"ShuttleUUID": shuttleUUID,
 				"UserUUID":    userUUID,
 			})
 
-			c.Close()
+			rejectWebSocket(c, 404, "Not Found", "User not found in shuttle")
 			return
 		}
 
-		AddToGroup(shuttleUUID, userUUID, c)
+		if roleCode == "D" {
+			canPublish, err = s.shuttleRepository.IsShuttleDriver(userUUIDParsed, shuttleUUIDParsed)
+			if err != nil {
+				logger.LogError(err, "WebSocket Error Checking Shuttle Driver", map[string]interface{}{
+					"ShuttleUUID": shuttleUUID,
+					"UserUUID":    userUUID,
+				})
+			}
+		}
+	}
+
+	s.touchPresence(userUUIDParsed)
+
+	client := NewClient(c, userUUID, sessionUUID, shuttleUUID, roleCode)
+	s.hub.Register(client)
+	go client.WritePump(s.hub.PingInterval())
+
+	// A peer that stops answering pings is evicted once the read deadline passes
+	c.SetReadDeadline(time.Now().Add(s.hub.PongTimeout()))
+	c.SetPongHandler(func(string) error {
+		c.SetReadDeadline(time.Now().Add(s.hub.PongTimeout()))
+		s.touchPresence(userUUIDParsed)
+		return nil
+	})
+
+	defer func() {
+		remaining := s.hub.Unregister(client)
+		client.Wait()
+
+		// Other devices of the same user keep them online
+		if remaining == 0 {
+			if err := s.userRepository.UpdateUserStatus(userUUIDParsed, "offline", time.Now()); err != nil {
+				logger.LogError(err, "WebSocket Error Updating User Status", nil)
+			}
+		}
+
+		logger.LogInfo("WebSocket connection closed", map[string]interface{}{
+			"ClientID":    client.ID,
+			"UserUUID":    userUUID,
+			"ShuttleUUID": shuttleUUID,
+		})
+	}()
+
+	if shuttleUUID != "" {
 		logger.LogInfo("WebSocket Connection Added to Group", map[string]interface{}{
+			"ClientID":    client.ID,
 			"ShuttleUUID": shuttleUUID,
 			"UserUUID":    userUUID,
+			"RoleCode":    roleCode,
+			"CanPublish":  canPublish,
 		})
-		c.WriteMessage(websocket.TextMessage, []byte("Connected to group"))
+		sendWebSocketAck(client, shuttleUUID, "Connected to group")
+
+		if position, exists := s.lastKnownPosition(shuttleUUIDParsed); exists {
+			sendPositionSnapshot(client, shuttleUUID, position)
+		}
 	} else {
-		AddConnection(userUUID, c)
 		logger.LogInfo("WebSocket connection established for user", map[string]interface{}{
+			"ClientID": client.ID,
 			"UserUUID": userUUID,
 		})
 	}
@@ -193,39 +376,104 @@ func (s *WebSocketService) HandleWebSocketConnection(c *websocket.Conn) {
 		if err != nil {
 			break
 		}
+		c.SetReadDeadline(time.Now().Add(s.hub.PongTimeout()))
 
-		if shuttleUUID != "" {
-			var data struct {
-				Longitude float64 `json:"longitude"`
-				Latitude  float64 `json:"latitude"`
-			}
+		var envelope WebSocketMessage
+		if err := json.Unmarshal(msg, &envelope); err != nil {
+			sendWebSocketError(client, 400, "Bad Request", "Invalid message format")
+			continue
+		}
 
-			if err := json.Unmarshal(msg, &data); err != nil || data.Longitude == 0 || data.Latitude == 0 {
-				response := WebSocketResponse{
-					Code:    400,
-					Status:  "Bad Request",
-					Message: "Invalid request format",
-				}
-				responseMsg, _ := json.Marshal(response)
-				c.WriteMessage(websocket.TextMessage, responseMsg)
-				continue
-			}
+		// Older driver apps send the bare {longitude, latitude} object without an envelope
+		if envelope.Type == "" {
+			envelope.Type = MessageTypePosition
+			envelope.Data = msg
+		}
 
-			logger.LogInfo("Broadcasting Message", map[string]interface{}{
-				"ShuttleUUID": shuttleUUID,
-				"UserUUID":    userUUID,
-				"Longitude":   data.Longitude,
-				"Latitude":    data.Latitude,
-			})
-			BroadcastToGroup(shuttleUUID, msg)
+		switch envelope.Type {
+		case MessageTypePing:
+			sendWebSocketAck(client, shuttleUUID, "pong")
 
-			response := WebSocketResponse{
-				Code:    200,
-				Status:  "OK",
-				Message: "Message broadcasted",
+		case MessageTypePosition:
+			if shuttleUUID == "" {
+				sendWebSocketError(client, 400, "Bad Request", "Positions can only be published to a shuttle group")
+				continue
+			}
+			if !canPublish {
+				sendWebSocketError(client, 403, "Forbidden", "Only the assigned driver can publish positions")
+				continue
 			}
-			responseMsg, _ := json.Marshal(response)
-			c.WriteMessage(websocket.TextMessage, responseMsg)
+			s.handlePosition(client, shuttleUUIDParsed, userUUIDParsed, envelope.Data)
+
+		default:
+			sendWebSocketError(client, 400, "Bad Request", "Unsupported message type: "+envelope.Type)
 		}
 	}
-}
\ No newline at end of file
+}
+
+func (s *WebSocketService) handlePosition(client *Client, shuttleUUIDParsed uuid.UUID, userUUIDParsed uuid.UUID, raw json.RawMessage) {
+	shuttleUUID := client.ShuttleUUID
+	userUUID := client.UserUUID
+
+	var data PositionPayload
+	if err := json.Unmarshal(raw, &data); err != nil || data.Longitude == 0 || data.Latitude == 0 {
+		sendWebSocketError(client, 400, "Bad Request", "Invalid position format")
+		return
+	}
+
+	recordedAt := time.Now()
+	outgoing, err := NewWebSocketMessage(MessageTypePosition, shuttleUUID, userUUID, PositionPayload{
+		Longitude:  data.Longitude,
+		Latitude:   data.Latitude,
+		Speed:      data.Speed,
+		Heading:    data.Heading,
+		RecordedAt: recordedAt.Format(time.RFC3339),
+	})
+	if err != nil {
+		logger.LogError(err, "WebSocket Error Encoding Position", nil)
+		sendWebSocketError(client, 500, "Internal Server Error", "Failed to broadcast position")
+		return
+	}
+
+	logger.LogInfo("Broadcasting Message", map[string]interface{}{
+		"ShuttleUUID": shuttleUUID,
+		"UserUUID":    userUUID,
+		"Longitude":   data.Longitude,
+		"Latitude":    data.Latitude,
+	})
+	s.hub.PublishPosition(shuttleUUID, outgoing, client, LastPosition{
+		Longitude:  data.Longitude,
+		Latitude:   data.Latitude,
+		Speed:      data.Speed,
+		Heading:    data.Heading,
+		RecordedAt: recordedAt,
+	})
+
+	location := entity.ShuttleLocation{
+		LocationID:  time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
+		ShuttleUUID: shuttleUUIDParsed,
+		UserUUID:    userUUIDParsed,
+		Latitude:    data.Latitude,
+		Longitude:   data.Longitude,
+		RecordedAt:  recordedAt,
+	}
+	if data.Speed != nil {
+		location.Speed = sql.NullFloat64{Float64: *data.Speed, Valid: true}
+	}
+	if data.Heading != nil {
+		location.Heading = sql.NullFloat64{Float64: *data.Heading, Valid: true}
+	}
+
+	if err := s.locationRepository.SaveLocation(location); err != nil {
+		logger.LogError(err, "WebSocket Error Saving Location", map[string]interface{}{
+			"ShuttleUUID": shuttleUUID,
+			"UserUUID":    userUUID,
+		})
+	}
+
+	for _, observer := range s.observers {
+		go observer.ObservePosition(shuttleUUIDParsed, userUUIDParsed, data.Latitude, data.Longitude, recordedAt)
+	}
+
+	sendWebSocketAck(client, shuttleUUID, "Message broadcasted")
+}